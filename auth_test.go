@@ -0,0 +1,115 @@
+package HiHttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 返回请求中 Authorization 头的测试服务
+func newAuthEchoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte(req.Header.Get("Authorization")))
+	}))
+}
+
+// 测试Basic认证, 包括基础地址中携带的用户信息
+func TestHttpClient_BasicAuth(t *testing.T) {
+	server := newAuthEchoServer()
+	defer server.Close()
+	client, err := HiHttp(context.Background(), strings.Replace(server.URL, "://", "://Aladdin:open%20sesame@", 1), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	result, err := client.Get("/")
+	if err != nil || result != "Basic QWxhZGRpbjpvcGVuIHNlc2FtZQ==" {
+		t.Fatalf("userinfo auth: %q, %v", result, err)
+	}
+	client.SetAuth(BasicAuth("user", "pass"))
+	if result, err = client.Get("/"); err != nil || result != "Basic dXNlcjpwYXNz" {
+		t.Fatalf("basic auth: %q, %v", result, err)
+	}
+	client.SetAuth(nil)
+	if result, err = client.Get("/"); err != nil || result != "" {
+		t.Fatalf("auth removed: %q, %v", result, err)
+	}
+}
+
+// 测试固定令牌的Bearer认证
+func TestHttpClient_BearerToken(t *testing.T) {
+	server := newAuthEchoServer()
+	defer server.Close()
+	client, err := HiHttp(context.Background(), server.URL, Options{Auth: BearerToken("abc.def")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	if result, err := client.Get("/"); err != nil || result != "Bearer abc.def" {
+		t.Fatalf("bearer auth: %q, %v", result, err)
+	}
+}
+
+// 测试客户端的认证方式只发往基础地址所在的源, 其它源只使用其地址中的用户信息
+func TestHttpClient_Auth_Scoped_To_Base_Origin(t *testing.T) {
+	home := newAuthEchoServer()
+	defer home.Close()
+	other := newAuthEchoServer()
+	defer other.Close()
+	client, err := HiHttp(context.Background(), home.URL, Options{Auth: BearerToken("abc.def")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	if result, err := client.Get(home.URL + "/"); err != nil || result != "Bearer abc.def" {
+		t.Fatalf("base origin: %q, %v", result, err)
+	}
+	if result, err := client.Get(other.URL + "/"); err != nil || result != "" {
+		t.Fatalf("second origin received credentials: %q, %v", result, err)
+	}
+	client.SetAuth(DigestAuth("admin", "secret"))
+	if result, err := client.Get(strings.Replace(other.URL, "://", "://user:pass@", 1) + "/"); err != nil || result != "Basic dXNlcjpwYXNz" {
+		t.Fatalf("second origin userinfo: %q, %v", result, err)
+	}
+	noBase, err := HiHttp(context.Background(), "", Options{Auth: BasicAuth("user", "pass")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer noBase.End()
+	if result, err := noBase.Get(other.URL + "/"); err != nil || result != "" {
+		t.Fatalf("client without a base url sent credentials: %q, %v", result, err)
+	}
+}
+
+// 测试动态令牌在重试时重新获取
+func TestHttpClient_BearerTokenSource_Refresh_On_Retry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte("expired"))
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	var count int
+	source := TokenSourceFunc(func() (string, error) {
+		count++
+		return fmt.Sprintf("token-%d", count), nil
+	})
+	client, err := HiHttp(context.Background(), server.URL, Options{Retry: 1, Auth: BearerTokenSource(source)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	if result, err := client.Get("/"); err != nil || result != "ok" || count != 2 {
+		t.Fatalf("got %q, %v after %d tokens", result, err, count)
+	}
+	client.SetAuth(BearerTokenSource(TokenSourceFunc(func() (string, error) { return "", nil })))
+	if _, err = client.Get("/"); err != ErrEmptyToken {
+		t.Fatalf("expected ErrEmptyToken, got %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+// 连接HTTPS服务并打印TLS握手信息与证书链, 用于监控证书轮换:
+//
+//	go run ./certinfo -warn 30 example.com api.example.com:8443
+//
+// 任一证书已过期或在 -warn 天内过期时以退出码1结束(已过期的证书不论 -warn 都报告), 连接失败时以退出码2结束
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"HiHttp"
+)
+
+func main() {
+	var (
+		warn     = flag.Int("warn", 0, "exit with status 1 if a certificate expires within this many days")
+		timeout  = flag.Duration("timeout", 10*time.Second, "connect and handshake timeout")
+		caFile   = flag.String("ca", "", "PEM file with trusted root certificates instead of the system roots")
+		insecure = flag.Bool("insecure", false, "do not verify the chain, to inspect untrusted or expired certificates")
+		http2    = flag.Bool("http2", false, "offer h2 via ALPN")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: certinfo [flags] host[:port] ...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	opts := HiHttp.Options{
+		Timeouts: HiHttp.Timeouts{Total: *timeout},
+		TLS:      HiHttp.TLSOptions{InsecureSkipVerifyDevelopmentOnly: *insecure},
+	}
+	if *caFile != "" {
+		opts.TLS.RootCAFiles = []string{*caFile}
+	}
+	if *http2 {
+		opts.Version = HiHttp.HTTP2
+	}
+	status := 0
+	for _, host := range flag.Args() {
+		code := inspect(host, opts, *warn)
+		if code > status {
+			status = code
+		}
+	}
+	os.Exit(status)
+}
+
+// 检查一个主机, 返回退出码
+func inspect(host string, opts HiHttp.Options, warn int) int {
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	client, err := HiHttp.HiHttp(context.Background(), host, opts)
+	if err != nil {
+		fmt.Printf("%s: %v\n", host, err)
+		return 2
+	}
+	defer client.End()
+	// 只需要握手, 响应状态无关紧要
+	res, err := client.Do("HEAD", "/", nil)
+	if res.TLS == nil {
+		fmt.Printf("%s: %v\n", host, err)
+		return 2
+	}
+	info := res.TLS
+	fmt.Printf("%s\n  %s, %s, alpn %q, sni %q, resumed %v\n", host, info.VersionName(), info.CipherSuiteName(),
+		info.Protocol, info.ServerName, info.Resumed)
+	code := 0
+	now := time.Now()
+	for i, cert := range info.PeerCertificates {
+		// 剩余天数向上取整, 不足一天按一天计
+		left := cert.NotAfter.Sub(now)
+		days := int(math.Ceil(left.Hours() / 24))
+		spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		fmt.Printf("  [%d] subject: %s\n", i, cert.Subject)
+		fmt.Printf("      issuer:  %s\n", cert.Issuer)
+		if len(cert.DNSNames) > 0 || len(cert.IPAddresses) > 0 {
+			names := append([]string(nil), cert.DNSNames...)
+			for _, ip := range cert.IPAddresses {
+				names = append(names, ip.String())
+			}
+			fmt.Printf("      names:   %s\n", strings.Join(names, ", "))
+		}
+		fmt.Printf("      valid:   %s - %s (%s)\n", cert.NotBefore.Format(time.RFC3339),
+			cert.NotAfter.Format(time.RFC3339), HiHttp.If(left > 0, fmt.Sprintf("%d days left", days), "expired"))
+		fmt.Printf("      spki:    sha256//%s\n", base64.StdEncoding.EncodeToString(spki[:]))
+		switch {
+		case left <= 0:
+			// 已过期的证书不论 -warn 都报告
+			fmt.Printf("      WARNING: expired %s\n", cert.NotAfter.Format(time.RFC3339))
+			code = 1
+		case warn > 0 && left < time.Duration(warn)*24*time.Hour:
+			fmt.Printf("      WARNING: expires in %d days\n", days)
+			code = 1
+		}
+	}
+	return code
+}
@@ -0,0 +1,110 @@
+package HiHttp
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"mime"
+	"strings"
+	"sync"
+)
+
+// Errors
+var (
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	ErrNotProtoMessage      = errors.New("value does not implement the protobuf marshaler interface")
+)
+
+// Codec 负责某一种媒体类型与Go对象之间的编解码
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// ProtoMarshaler Protobuf消息的最小序列化接口(兼容 gogo/protobuf 等生成代码)
+type ProtoMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+// ProtoUnmarshaler Protobuf消息的最小反序列化接口
+type ProtoUnmarshaler interface {
+	Unmarshal(data []byte) error
+}
+
+// 编解码器注册表, 以不带参数的媒体类型作为key
+var (
+	codecs     = map[string]Codec{}
+	codecsLock sync.RWMutex
+)
+
+func init() {
+	RegisterCodec(JSON, jsonCodec{})
+	RegisterCodec(XML, xmlCodec{})
+	RegisterCodec(XML2, xmlCodec{})
+	RegisterCodec(MSGPACK, msgpackCodec{})
+	RegisterCodec(MSGPACK2, msgpackCodec{})
+	RegisterCodec(PROTOBUF, protobufCodec{})
+}
+
+// RegisterCodec 注册(或覆盖)指定媒体类型的编解码器
+func RegisterCodec(mediaType string, codec Codec) {
+	codecsLock.Lock()
+	codecs[normalizeMediaType(mediaType)] = codec
+	codecsLock.Unlock()
+}
+
+// GetCodec 根据 Content-Type / Accept 的值查找编解码器, 忽略 charset 等参数
+func GetCodec(contentType string) (Codec, bool) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	// Accept 可能包含多个候选类型, 按出现顺序匹配第一个已注册的
+	for _, part := range strings.Split(contentType, ",") {
+		if codec, ok := codecs[normalizeMediaType(part)]; ok {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
+// 去掉参数并统一为小写, 例: "Application/JSON; charset=utf-8" -> "application/json"
+func normalizeMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.TrimSpace(strings.Split(contentType, ";")[0])
+	}
+	return strings.ToLower(mediaType)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type xmlCodec struct{}
+
+func (xmlCodec) Marshal(v interface{}) ([]byte, error) { return xml.Marshal(v) }
+
+func (xmlCodec) Unmarshal(data []byte, v interface{}) error { return xml.Unmarshal(data, v) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) { return MsgpackMarshal(v) }
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error { return MsgpackUnmarshal(data, v) }
+
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(ProtoMarshaler); ok {
+		return m.Marshal()
+	}
+	return nil, ErrNotProtoMessage
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	if m, ok := v.(ProtoUnmarshaler); ok {
+		return m.Unmarshal(data)
+	}
+	return ErrNotProtoMessage
+}
@@ -0,0 +1,184 @@
+package HiHttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type codecUser struct {
+	Name     string            `msgpack:"name" xml:"name"`
+	Age      uint8             `msgpack:"age" xml:"age"`
+	Score    float64           `msgpack:"score,omitempty" xml:"score"`
+	Tags     []string          `msgpack:"tags" xml:"tag"`
+	Extra    map[string]int64  `msgpack:"extra" xml:"-"`
+	Avatar   []byte            `msgpack:"avatar" xml:"-"`
+	Birthday time.Time         `msgpack:"birthday" xml:"-"`
+	Ignored  string            `msgpack:"-" xml:"-"`
+	Labels   map[string]string `msgpack:"labels,omitempty" xml:"-"`
+}
+
+// 最小化的Protobuf消息实现
+type fakeProto struct{ data []byte }
+
+func (p *fakeProto) Marshal() ([]byte, error) { return p.data, nil }
+
+func (p *fakeProto) Unmarshal(data []byte) error { p.data = append([]byte(nil), data...); return nil }
+
+// 测试MessagePack编码结果与规范一致
+func TestMsgpack_Marshal_Spec(t *testing.T) {
+	cases := []struct {
+		in   interface{}
+		want []byte
+	}{
+		{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{1, []byte{0x01}},
+		{-1, []byte{0xff}},
+		{-33, []byte{0xd0, 0xdf}},
+		{200, []byte{0xcc, 0xc8}},
+		{70000, []byte{0xce, 0x00, 0x01, 0x11, 0x70}},
+		{1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"hi", []byte{0xa2, 'h', 'i'}},
+		{[]byte{1, 2}, []byte{0xc4, 0x02, 0x01, 0x02}},
+		{[]int{1, 2}, []byte{0x92, 0x01, 0x02}},
+		{map[string]int{"b": 2, "a": 1}, []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02}},
+		{time.Unix(1, 0), []byte{0xd6, 0xff, 0, 0, 0, 1}},
+	}
+	for _, c := range cases {
+		got, err := MsgpackMarshal(c.in)
+		if err != nil {
+			t.Fatalf("marshal %v: %v", c.in, err)
+		}
+		if !bytes.Equal(got, c.want) {
+			t.Errorf("marshal %v: got % x, want % x", c.in, got, c.want)
+		}
+	}
+}
+
+// 测试结构体的MessagePack编解码
+func TestMsgpack_RoundTrip(t *testing.T) {
+	in := codecUser{
+		Name:     "李鸿辉",
+		Age:      28,
+		Tags:     []string{"go", "http"},
+		Extra:    map[string]int64{"min": -1 << 40, "max": 1 << 40},
+		Avatar:   []byte{0, '\r', '\n', 0xff},
+		Birthday: time.Date(1992, 5, 1, 8, 0, 0, 123, time.UTC),
+		Ignored:  "ignored",
+	}
+	data, err := MsgpackMarshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out codecUser
+	if err = MsgpackUnmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	in.Ignored = ""
+	if !out.Birthday.Equal(in.Birthday) {
+		t.Fatalf("birthday: got %v, want %v", out.Birthday, in.Birthday)
+	}
+	out.Birthday = in.Birthday
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("got %+v, want %+v", out, in)
+	}
+	var generic map[string]interface{}
+	if err = MsgpackUnmarshal(data, &generic); err != nil {
+		t.Fatal(err)
+	}
+	if generic["name"] != in.Name || generic["age"] != int64(28) {
+		t.Fatalf("generic decode: %+v", generic)
+	}
+	if _, ok := generic["score"]; ok {
+		t.Fatal("omitempty field was encoded")
+	}
+	var small struct {
+		Age int8 `msgpack:"extra"`
+	}
+	if err = MsgpackUnmarshal(data, &small); err == nil {
+		t.Fatal("expected type mismatch error")
+	}
+}
+
+// 测试按媒体类型查找编解码器
+func TestGetCodec(t *testing.T) {
+	for _, contentType := range []string{JSON, "Application/JSON; charset=utf-8", XML2, MSGPACK2, PROTOBUF, "text/html, application/xml;q=0.9"} {
+		if _, ok := GetCodec(contentType); !ok {
+			t.Errorf("codec for %q not found", contentType)
+		}
+	}
+	if _, ok := GetCodec(HTML); ok {
+		t.Errorf("unexpected codec for %q", HTML)
+	}
+	codec, _ := GetCodec(PROTOBUF)
+	msg := fakeProto{data: []byte{0x08, 0x96, 0x01}}
+	data, err := codec.Marshal(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded fakeProto
+	if err = codec.Unmarshal(data, &decoded); err != nil || !bytes.Equal(decoded.data, msg.data) {
+		t.Fatalf("protobuf round trip: %v, %v", decoded.data, err)
+	}
+	if _, err = codec.Marshal(struct{}{}); err != ErrNotProtoMessage {
+		t.Fatalf("expected ErrNotProtoMessage, got %v", err)
+	}
+}
+
+// 测试PostObject根据Content-Type编码请求、根据响应Content-Type解码响应
+func TestHttpClient_PostObject(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		var user codecUser
+		if err := MsgpackUnmarshal(body, &user); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		user.Age++
+		w.Header().Set("Content-Type", XML2+"; charset=utf-8")
+		_ = xml.NewEncoder(w).Encode(user)
+	}))
+	defer server.Close()
+	client, err := HiHttp(context.Background(), server.URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	client.SetHeader("Content-Type", MSGPACK)
+	var out codecUser
+	if err = client.PostObject("/users", codecUser{Name: "hi", Age: 1, Tags: []string{"a"}}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "hi" || out.Age != 2 || len(out.Tags) != 1 {
+		t.Fatalf("unexpected response: %+v", out)
+	}
+}
+
+// 测试响应未声明Content-Type时按Accept解码
+func TestHttpClient_GetObject_With_Accept(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := MsgpackMarshal(map[string]interface{}{"ok": true})
+		w.Header()["Content-Type"] = nil
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+	client, err := HiHttp(context.Background(), server.URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	client.SetHeader("Accept", MSGPACK2)
+	var out struct {
+		Ok bool `msgpack:"ok"`
+	}
+	if err = client.GetObject("/", &out); err != nil || !out.Ok {
+		t.Fatalf("got %+v, err: %v", out, err)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package HiHttp
+
+import "net"
+
+// 检查空闲连接是否仍然可用
+func connAlive(conn net.Conn) bool {
+	return connAliveByDeadline(conn)
+}
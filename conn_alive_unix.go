@@ -0,0 +1,38 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package HiHttp
+
+import (
+	"net"
+	"syscall"
+)
+
+// 检查空闲连接是否仍然可用: 以非阻塞的 MSG_PEEK 读取, 无数据可读说明连接正常;
+// 读到EOF说明服务端已关闭(或半关闭)连接; 空闲连接上出现数据说明协议状态已错乱, 同样不可复用
+func connAlive(conn net.Conn) bool {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return connAliveByDeadline(conn)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var (
+		buf     [1]byte
+		peekErr error
+	)
+	err = raw.Read(func(fd uintptr) bool {
+		_, _, peekErr = syscall.Recvfrom(int(fd), buf[:], syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		// 只检查一次, 不等待数据到来
+		return true
+	})
+	if err != nil {
+		return false
+	}
+	if peekErr == syscall.EAGAIN || peekErr == syscall.EWOULDBLOCK {
+		return true
+	}
+	return false
+}
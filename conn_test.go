@@ -0,0 +1,253 @@
+package HiHttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 测试服务端静默关闭空闲连接后, 复用前能检测到并重新建立连接
+func TestHttpClient_Reconnect_Stale_Conn(t *testing.T) {
+	server := newRawServer(t, func(req *http.Request, _ int) (string, bool) {
+		// 未声明 Connection: close, 但响应后立即关闭连接
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", true
+	})
+	defer server.Close()
+	client, err := HiHttp(context.Background(), server.URL(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	for i := 0; i < 3; i++ {
+		// 等待服务端的FIN到达
+		time.Sleep(20 * time.Millisecond)
+		if result, err := client.Get("/"); err != nil || result != "ok" {
+			t.Fatalf("request %d: %q, %v", i, result, err)
+		}
+	}
+	if conns := atomic.LoadInt32(&server.conns); conns != 3 {
+		t.Fatalf("server accepted %d connections, want 3", conns)
+	}
+}
+
+// 测试服务端在收到复用连接上的请求后才关闭连接时, 幂等请求自动重发, 非幂等请求返回错误
+func TestHttpClient_Replay_Idempotent_Request(t *testing.T) {
+	server := newRawServer(t, func(req *http.Request, n int) (string, bool) {
+		if n > 0 {
+			return "", true
+		}
+		return "HTTP/1.1 200 OK\r\nContent-Length: " + string(rune('0'+len(req.Method))) + "\r\n\r\n" + req.Method, false
+	})
+	defer server.Close()
+	client, err := HiHttp(context.Background(), server.URL(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	for i := 0; i < 3; i++ {
+		if result, err := client.Get("/"); err != nil || result != "GET" {
+			t.Fatalf("request %d: %q, %v", i, result, err)
+		}
+	}
+	if result, err := client.Post("/", strings.NewReader("x")); !errors.Is(err, ErrConnClosed) {
+		t.Fatalf("expected ErrConnClosed for POST, got %q, %v", result, err)
+	}
+	if result, err := client.Post("/", strings.NewReader("x")); err != nil || result != "POST" {
+		t.Fatalf("POST on new connection: %q, %v", result, err)
+	}
+}
+
+// 测试调用End之后客户端仍然可以继续使用
+func TestHttpClient_Reuse_After_End(t *testing.T) {
+	server := newRawServer(t, func(req *http.Request, _ int) (string, bool) {
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", false
+	})
+	defer server.Close()
+	client, err := HiHttp(context.Background(), server.URL(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if result, err := client.Get("/"); err != nil || result != "ok" {
+			t.Fatalf("request %d: %q, %v", i, result, err)
+		}
+		client.End()
+	}
+	if conns := atomic.LoadInt32(&server.conns); conns != 2 {
+		t.Fatalf("server accepted %d connections, want 2", conns)
+	}
+}
+
+// 测试多个协程共享同一个客户端时, 每个请求的方法、路径、请求体与Content-Type互不干扰
+func TestHttpClient_Concurrent_Requests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = fmt.Fprintf(w, "%s %s %s %s", r.Method, r.URL.Path, body, r.Header.Get("Content-Type"))
+	}))
+	defer server.Close()
+	client, err := HiHttp(context.Background(), server.URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path := fmt.Sprintf("/%d", i)
+			var result, want string
+			var err error
+			switch i % 3 {
+			case 0:
+				result, err = client.Get(path)
+				want = "GET " + path + "  "
+			case 1:
+				result, err = client.Post(path, strings.NewReader(path))
+				want = "POST " + path + " " + path + " "
+			case 2:
+				result, err = client.PostForm(path, map[string]string{"i": path})
+				want = "POST " + path + " i=%2F" + path[1:] + " " + URLENCODED
+			}
+			if err != nil || result != want {
+				t.Errorf("request %d: %q, %v, want %q", i, result, err, want)
+			}
+			// 并发修改客户端的默认配置
+			client.SetHeader(fmt.Sprintf("X-Req-%d", i), path)
+			client.SetTimeout(DefTimeout)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// 只返回一半响应体的服务端, 用于模拟请求在读取响应中途超时或被取消
+func newStallingServer(t *testing.T) *rawServer {
+	return newRawServer(t, func(req *http.Request, _ int) (string, bool) {
+		if req.URL.Path == "/stall" {
+			return "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhalf", false
+		}
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", false
+	})
+}
+
+// 等待协程数回落到n以内, 超时则判定为协程泄漏
+func waitGoroutines(t *testing.T, n int) {
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutine leak: %d running, want <= %d", runtime.NumGoroutine(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 测试请求超时后中断读取并丢弃残留半个响应的连接, 下一个请求在新连接上得到正确的响应
+func TestHttpClient_Timeout_Retires_Conn(t *testing.T) {
+	server := newStallingServer(t)
+	defer server.Close()
+	goroutines := runtime.NumGoroutine()
+	client, err := HiHttp(context.Background(), server.URL(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetTimeout(100 * time.Millisecond)
+	start := time.Now()
+	if _, err := client.Get("/stall"); err != ErrRequestTimeout {
+		t.Fatalf("expected ErrRequestTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("timeout took %v", elapsed)
+	}
+	if result, err := client.Get("/"); err != nil || result != "ok" {
+		t.Fatalf("request after timeout: %q, %v", result, err)
+	}
+	if conns := atomic.LoadInt32(&server.conns); conns != 2 {
+		t.Fatalf("server accepted %d connections, want 2", conns)
+	}
+	client.End()
+	// 服务端的连接协程在客户端关闭连接后退出
+	waitGoroutines(t, goroutines)
+}
+
+// 测试取消上下文时立即中断进行中的请求, 且不遗留协程
+func TestHttpClient_Cancel_Interrupts_Request(t *testing.T) {
+	server := newStallingServer(t)
+	defer server.Close()
+	goroutines := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := HiHttp(ctx, server.URL(), Options{Retry: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := client.Get("/stall"); err != ErrRequestCanceled {
+		t.Fatalf("expected ErrRequestCanceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("cancel took %v", elapsed)
+	}
+	// 请求协程已退出, 且残留半个响应的连接已关闭, 服务端的连接协程随之退出
+	waitGoroutines(t, goroutines)
+	client.End()
+}
+
+// 测试连接被拒绝时立即返回, 错误归入 ErrConnectingTimeout 并带有实际原因
+func TestHttpClient_Connect_Refused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+	client, err := HiHttp(context.Background(), "http://"+addr, Options{Retry: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	start := time.Now()
+	_, err = client.Get("/")
+	if !errors.Is(err, ErrConnectingTimeout) || err.Error() == ErrConnectingTimeout.Error() {
+		t.Fatalf("expected wrapped ErrConnectingTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("refused connection took %v", elapsed)
+	}
+}
+
+// 测试多个请求同时等待同一次建立连接, 只建立一个连接
+func TestHttpClient_Shared_Dial(t *testing.T) {
+	server := newRawServer(t, func(req *http.Request, _ int) (string, bool) {
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", false
+	})
+	defer server.Close()
+	client, err := HiHttp(context.Background(), server.URL(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if result, err := client.Get("/"); err != nil || result != "ok" {
+				t.Errorf("request %d: %q, %v", i, result, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if conns := atomic.LoadInt32(&server.conns); conns != 1 {
+		t.Fatalf("server accepted %d connections, want 1", conns)
+	}
+}
@@ -0,0 +1,20 @@
+package HiHttp
+
+import (
+	"context"
+	"net"
+)
+
+// 连接建立器: 客户端通过它建立HTTP/1.x与HTTP/2所用的连接(HTTPS时在返回的连接上进行TLS握手),
+// 可用于内存中的连接(net.Pipe)、带统计或故障注入的连接以及其他网络; *net.Dialer 即实现了该接口
+type Dialer interface {
+	// 建立连接, network 为 "tcp" 或 "unix"; ctx 带有 Connect 阶段的截止时间, 只作用于连接的建立
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// 以函数实现 Dialer
+type DialerFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func (f DialerFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return f(ctx, network, addr)
+}
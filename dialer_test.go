@@ -0,0 +1,198 @@
+package HiHttp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var errListenerClosed = errors.New("listener closed")
+
+// 内存中的监听器, 连接由 dial 通过 net.Pipe 创建
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errListenerClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+func (l *pipeListener) dial(ctx context.Context) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+	case <-ctx.Done():
+		_ = server.Close()
+		_ = client.Close()
+		return nil, ctx.Err()
+	}
+	_ = server.Close()
+	_ = client.Close()
+	return nil, &net.OpError{Op: "dial", Net: "pipe", Err: syscall.ECONNREFUSED}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// 在内存中运行 handler, 返回只接受 addrs 中地址的连接建立器, 其余地址返回连接被拒绝
+func newPipeServer(t *testing.T, handler http.Handler, addrs ...string) Dialer {
+	return newPipeServerTLS(t, nil, handler, addrs...)
+}
+
+// 同 newPipeServer, config 不为空时以HTTPS提供服务
+func newPipeServerTLS(t *testing.T, config *tls.Config, handler http.Handler, addrs ...string) Dialer {
+	l := &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+	server := &http.Server{Handler: handler, TLSConfig: config}
+	go func() {
+		if config != nil {
+			_ = server.ServeTLS(l, "", "")
+		} else {
+			_ = server.Serve(l)
+		}
+	}()
+	t.Cleanup(func() { _ = server.Close() })
+	return DialerFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		for _, a := range addrs {
+			if a == addr {
+				return l.dial(ctx)
+			}
+		}
+		return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ECONNREFUSED}
+	})
+}
+
+// 与 /server/main.go 相同的测试接口
+func devHandler() http.Handler {
+	type baseResponse struct {
+		Code    uint32      `json:"code"`
+		Message string      `json:"msg"`
+		Data    interface{} `json:"data"`
+	}
+	notFound := func(w http.ResponseWriter) {
+		w.WriteHeader(http.StatusNotFound)
+		resBytes, _ := json.Marshal(baseResponse{Code: 404, Message: "Request Path Not Found!"})
+		_, _ = w.Write(resBytes)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte("<html><body>upload</body></html>"))
+	})
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			notFound(w)
+			return
+		}
+		resBytes, _ := json.Marshal(baseResponse{Code: 200, Message: "Success!", Data: "World!"})
+		_, _ = w.Write(resBytes)
+	})
+	mux.HandleFunc("/json", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if req.Method != http.MethodPost {
+			notFound(w)
+			return
+		}
+		bodyBytes, _ := ioutil.ReadAll(req.Body)
+		resBytes, _ := json.Marshal(baseResponse{Code: 200, Message: "Success!", Data: string(bodyBytes)})
+		_, _ = w.Write(resBytes)
+	})
+	mux.HandleFunc("/head", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodHead {
+			notFound(w)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+// 在内存中运行测试接口, 代替 localhost:888 上的测试服务器
+func devDialer(t *testing.T) Dialer {
+	return newPipeServer(t, devHandler(), "localhost:888")
+}
+
+// HTTPS的测试接口, 服务端证书由测试CA签发, 对 api.test 有效; 同时返回信任该CA的TLS配置
+func devTLSDialer(t *testing.T) (Dialer, *tls.Config) {
+	ca := newTestCA(t)
+	cert, _, _ := ca.issue(t, "server", false)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	dialer := newPipeServerTLS(t, &tls.Config{Certificates: []tls.Certificate{cert}}, devHandler(), "api.test:8443")
+	return dialer, &tls.Config{RootCAs: pool}
+}
+
+// 测试自定义连接建立器: 收到未经解析的地址与 Connect 截止时间, 错误归入 ErrConnectingTimeout
+func TestHttpClient_Dialer(t *testing.T) {
+	pipe := newPipeServer(t, devHandler(), "api.test:80")
+	var dials int32
+	var deadline time.Time
+	dialer := DialerFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		deadline, _ = ctx.Deadline()
+		if network != "tcp" {
+			return nil, errors.New("unexpected network " + network)
+		}
+		return pipe.DialContext(ctx, network, addr)
+	})
+	client, err := HiHttp(context.Background(), "http://api.test", Options{Dialer: dialer, Pipeline: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	for i := 0; i < 3; i++ {
+		if result, err := client.Get("/hello"); err != nil || result != `{"code":200,"msg":"Success!","data":"World!"}` {
+			t.Fatalf("%q, %v", result, err)
+		}
+	}
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Fatalf("dials = %d, want 1", n)
+	}
+	if deadline.IsZero() || time.Until(deadline) > DefTimeout {
+		t.Fatalf("unexpected connect deadline %v", deadline)
+	}
+	// 未被接受的地址
+	if _, err := client.Get("http://other.test/hello"); !errors.Is(err, ErrConnectingTimeout) || !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("expected refused connection, got %v", err)
+	}
+	// 连接建立器超时
+	slow, err := HiHttp(context.Background(), "", Options{Dialer: DialerFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}), Timeouts: Timeouts{Connect: 50 * time.Millisecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.End()
+	if _, err := slow.Get("http://api.test/hello"); !errors.Is(err, ErrConnectTimeout) {
+		t.Fatalf("expected ErrConnectTimeout, got %v", err)
+	}
+}
@@ -0,0 +1,260 @@
+package HiHttp
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Errors
+var (
+	ErrDigestChallenge   = errors.New("invalid digest challenge")
+	ErrDigestUnsupported = errors.New("unsupported digest algorithm or qop")
+)
+
+// ChallengeAuthenticator 需要根据服务端质询(401 WWW-Authenticate)计算认证信息的认证方式
+type ChallengeAuthenticator interface {
+	Authenticator
+	// Challenge 处理401响应, 返回true表示已根据质询更新认证状态, 请求需要重新发送
+	Challenge(req *Request, res *Response) (bool, error)
+}
+
+// 支持的摘要算法, 按安全性由高到低排列
+var digestAlgorithms = []struct {
+	name string
+	hash func() hash.Hash
+}{
+	{"SHA-512-256", sha512.New512_256},
+	{"SHA-256", sha256.New},
+	{"MD5", md5.New},
+}
+
+type digestAuth struct {
+	username, password string
+	lock               sync.Mutex
+	realm, nonce       string
+	opaque, algorithm  string
+	qop                string // "auth" 或空(RFC 2069 兼容模式)
+	userhash           bool
+	hash               func() hash.Hash
+	sess               bool
+	cnonce             string // -sess 算法在同一nonce内固定使用的cnonce
+	ha1                string
+	nc                 uint32 // 当前nonce已使用的次数
+}
+
+// DigestAuth RFC 7616 Digest认证, 支持 MD5 / SHA-256 / SHA-512-256 及其 -sess 变体, qop=auth;
+// 首次请求收到401质询后自动重新发送, 同一客户端后续请求复用nonce并递增nc
+func DigestAuth(username, password string) ChallengeAuthenticator {
+	return &digestAuth{username: username, password: password}
+}
+
+func (a *digestAuth) Authenticate(req *Request) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.nonce == "" {
+		// 尚未收到质询, 先发送不带认证的请求
+		return nil
+	}
+	a.nc++
+	cnonce := a.cnonce
+	if !a.sess {
+		cnonce = newCnonce()
+	}
+	nc := fmt.Sprintf("%08x", a.nc)
+	ha1 := a.ha1
+	if a.sess {
+		ha1 = a.h(ha1 + ":" + a.nonce + ":" + cnonce)
+	}
+	ha2 := a.h(req.Method + ":" + req.Url)
+	var response string
+	if a.qop == "" {
+		response = a.h(ha1 + ":" + a.nonce + ":" + ha2)
+	} else {
+		response = a.h(strings.Join([]string{ha1, a.nonce, nc, cnonce, a.qop, ha2}, ":"))
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Digest ")
+	switch {
+	case a.userhash:
+		fmt.Fprintf(&sb, `username="%s", userhash=true, `, a.h(a.username+":"+a.realm))
+	case needsExtValue(a.username):
+		fmt.Fprintf(&sb, "username*=UTF-8''%s, ", url.PathEscape(a.username))
+	default:
+		fmt.Fprintf(&sb, `username=%s, `, quoteParam(a.username))
+	}
+	fmt.Fprintf(&sb, `realm=%s, nonce=%s, uri=%s, algorithm=%s, response="%s"`,
+		quoteParam(a.realm), quoteParam(a.nonce), quoteParam(req.Url), a.algorithm, response)
+	if a.qop != "" {
+		fmt.Fprintf(&sb, `, qop=%s, nc=%s, cnonce="%s"`, a.qop, nc, cnonce)
+	}
+	if a.opaque != "" {
+		fmt.Fprintf(&sb, `, opaque=%s`, quoteParam(a.opaque))
+	}
+	req.Headers.Set("Authorization", sb.String())
+	return nil
+}
+
+func (a *digestAuth) Challenge(req *Request, res *Response) (bool, error) {
+	var challenge *authChallenge
+	var best = len(digestAlgorithms)
+	for _, c := range parseChallenges(res.Headers.Get("WWW-Authenticate")) {
+		if !strings.EqualFold(c.scheme, "Digest") {
+			continue
+		}
+		algorithm := strings.ToUpper(strings.TrimSuffix(strings.ToLower(c.param("algorithm", "MD5")), "-sess"))
+		for i, alg := range digestAlgorithms {
+			if alg.name == algorithm && i < best {
+				c := c
+				challenge, best = &c, i
+			}
+		}
+	}
+	if challenge == nil {
+		return false, nil
+	}
+	nonce := challenge.params["nonce"]
+	if nonce == "" {
+		return false, ErrDigestChallenge
+	}
+	qop := ""
+	if qops := challenge.params["qop"]; qops != "" {
+		for _, q := range strings.Split(qops, ",") {
+			if strings.TrimSpace(q) == "auth" {
+				qop = "auth"
+			}
+		}
+		if qop == "" {
+			return false, fmt.Errorf("%w: qop=%s", ErrDigestUnsupported, qops)
+		}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	// 使用同一nonce的认证仍被拒绝, 且服务端未声明nonce过期, 说明用户名或密码错误, 不再重发
+	stale := strings.EqualFold(challenge.params["stale"], "true")
+	if strings.HasPrefix(req.Headers.Get("Authorization"), "Digest ") && nonce == a.nonce && !stale {
+		return false, nil
+	}
+	algorithm := challenge.param("algorithm", "MD5")
+	a.realm = challenge.params["realm"]
+	a.nonce = nonce
+	a.opaque = challenge.params["opaque"]
+	a.algorithm = algorithm
+	a.qop = qop
+	a.userhash = strings.EqualFold(challenge.params["userhash"], "true")
+	a.hash = digestAlgorithms[best].hash
+	a.sess = strings.HasSuffix(strings.ToLower(algorithm), "-sess")
+	a.cnonce = newCnonce()
+	a.ha1 = a.h(a.username + ":" + a.realm + ":" + a.password)
+	a.nc = 0
+	return true, nil
+}
+
+func (a *digestAuth) h(s string) string {
+	hash := a.hash()
+	hash.Write([]byte(s))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// 生成客户端随机数, 测试中可替换为固定值
+var newCnonce = func() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func quoteParam(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// 用户名包含非ASCII字符或引号时需使用 RFC 5987 扩展格式
+func needsExtValue(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 || s[i] < 0x20 || s[i] == '"' || s[i] == '\\' {
+			return true
+		}
+	}
+	return false
+}
+
+/// -------------------------------- 质询解析 --------------------------------
+
+// 一个认证质询, 例: Digest realm="x", nonce="y", qop="auth"
+type authChallenge struct {
+	scheme string
+	params map[string]string // 参数名为小写
+}
+
+func (c authChallenge) param(key, def string) string {
+	if val, ok := c.params[key]; ok && val != "" {
+		return val
+	}
+	return def
+}
+
+// 解析 WWW-Authenticate 的值, 多个质询可以用逗号合并在同一个头中
+func parseChallenges(s string) (challenges []authChallenge) {
+	current := -1
+	for i := 0; i < len(s); {
+		// 跳过分隔符
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == ',') {
+			i++
+		}
+		start := i
+		for i < len(s) && s[i] != '=' && s[i] != ' ' && s[i] != '\t' && s[i] != ',' {
+			i++
+		}
+		token := s[start:i]
+		if token == "" {
+			break
+		}
+		j := i
+		for j < len(s) && (s[j] == ' ' || s[j] == '\t') {
+			j++
+		}
+		if j >= len(s) || s[j] != '=' || current < 0 {
+			// 新的质询
+			challenges = append(challenges, authChallenge{scheme: token, params: map[string]string{}})
+			current = len(challenges) - 1
+			// token68 形式的参数(例: Negotiate abc==)直接跳过
+			if j < len(s) && s[j] == '=' {
+				for i < len(s) && s[i] != ',' {
+					i++
+				}
+			}
+			continue
+		}
+		// key=value 或 key="quoted value"
+		i = j + 1
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+			i++
+		}
+		var value strings.Builder
+		if i < len(s) && s[i] == '"' {
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			i++
+		} else {
+			for i < len(s) && s[i] != ',' && s[i] != ' ' && s[i] != '\t' {
+				value.WriteByte(s[i])
+				i++
+			}
+		}
+		challenges[current].params[strings.ToLower(token)] = value.String()
+	}
+	return
+}
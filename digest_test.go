@@ -0,0 +1,172 @@
+package HiHttp
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 测试 RFC 7616 3.9.1 中的示例
+func TestDigestAuth_RFC7616_Example(t *testing.T) {
+	defer func(f func() string) { newCnonce = f }(newCnonce)
+	newCnonce = func() string { return "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ" }
+	want := map[string]string{
+		"MD5":     "8ca523f5e9506fed4657c9700eebdbec",
+		"SHA-256": "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1",
+	}
+	for algorithm, response := range want {
+		auth := DigestAuth("Mufasa", "Circle of Life")
+		req := &Request{Method: "GET", Url: "/dir/index.html", Headers: Headers{}}
+		res := &Response{Status: UNAUTHORIZED, Headers: Headers{"WWW-Authenticate": `Digest realm="http-auth@example.org", ` +
+			`qop="auth, auth-int", algorithm=` + algorithm + `, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", ` +
+			`opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`}}
+		if resend, err := auth.Challenge(req, res); !resend || err != nil {
+			t.Fatalf("%s: challenge not accepted: %v", algorithm, err)
+		}
+		if err := auth.Authenticate(req); err != nil {
+			t.Fatal(err)
+		}
+		params := parseChallenges(req.Headers.Get("Authorization"))[0].params
+		if params["response"] != response || params["nc"] != "00000001" || params["opaque"] == "" {
+			t.Fatalf("%s: unexpected authorization %s", algorithm, req.Headers.Get("Authorization"))
+		}
+	}
+}
+
+// 测试解析合并在一起的多个质询
+func TestParseChallenges(t *testing.T) {
+	challenges := parseChallenges(`Basic realm="a, b", Digest realm="x\"y", nonce=abc, qop="auth,auth-int", Bearer`)
+	if len(challenges) != 3 {
+		t.Fatalf("got %d challenges: %+v", len(challenges), challenges)
+	}
+	if challenges[0].scheme != "Basic" || challenges[0].params["realm"] != "a, b" {
+		t.Fatalf("unexpected basic challenge: %+v", challenges[0])
+	}
+	digest := challenges[1]
+	if digest.params["realm"] != `x"y` || digest.params["nonce"] != "abc" || digest.params["qop"] != "auth,auth-int" {
+		t.Fatalf("unexpected digest challenge: %+v", digest)
+	}
+	if challenges[2].scheme != "Bearer" {
+		t.Fatalf("unexpected bearer challenge: %+v", challenges[2])
+	}
+}
+
+// 进程内的Digest认证服务, 每处理 rotate 个认证请求后更换nonce并以 stale=true 质询
+type digestServer struct {
+	lock      sync.Mutex
+	algorithm string
+	realm     string
+	password  string
+	nonce     int
+	lastNc    uint64
+	rotate    int
+	served    int
+	requests  int
+}
+
+func (s *digestServer) h(data string) string {
+	var hash hash.Hash = md5.New()
+	if strings.HasPrefix(strings.ToUpper(s.algorithm), "SHA-256") {
+		hash = sha256.New()
+	}
+	hash.Write([]byte(data))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (s *digestServer) challenge(w http.ResponseWriter, stale bool) {
+	w.Header().Add("WWW-Authenticate", `Basic realm="`+s.realm+`"`)
+	w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Digest realm="%s", qop="auth", algorithm=%s, nonce="n%d", opaque="op", stale=%t`,
+		s.realm, s.algorithm, s.nonce, stale))
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+func (s *digestServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests++
+	challenges := parseChallenges(req.Header.Get("Authorization"))
+	if len(challenges) == 0 || challenges[0].scheme != "Digest" {
+		s.challenge(w, false)
+		return
+	}
+	p := challenges[0].params
+	if p["nonce"] != fmt.Sprintf("n%d", s.nonce) {
+		s.challenge(w, true)
+		return
+	}
+	nc, err := strconv.ParseUint(p["nc"], 16, 32)
+	if err != nil || nc <= s.lastNc || p["uri"] != req.URL.RequestURI() || p["opaque"] != "op" || p["algorithm"] != s.algorithm {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ha1 := s.h(p["username"] + ":" + s.realm + ":" + s.password)
+	if strings.HasSuffix(s.algorithm, "-sess") {
+		ha1 = s.h(ha1 + ":" + p["nonce"] + ":" + p["cnonce"])
+	}
+	ha2 := s.h(req.Method + ":" + p["uri"])
+	if p["response"] != s.h(strings.Join([]string{ha1, p["nonce"], p["nc"], p["cnonce"], p["qop"], ha2}, ":")) {
+		s.challenge(w, false)
+		return
+	}
+	s.lastNc = nc
+	if s.served++; s.rotate > 0 && s.served%s.rotate == 0 {
+		s.nonce++
+		s.lastNc = 0
+	}
+	_, _ = w.Write([]byte("hello " + p["username"] + " " + p["nc"]))
+}
+
+// 测试各摘要算法的Digest认证, 包括nc递增与nonce过期后的自动重新认证
+func TestHttpClient_DigestAuth(t *testing.T) {
+	for _, algorithm := range []string{"MD5", "MD5-sess", "SHA-256", "SHA-256-sess"} {
+		handler := &digestServer{algorithm: algorithm, realm: "test@hihttp", password: "secret", rotate: 2}
+		server := httptest.NewServer(handler)
+		client, err := HiHttp(context.Background(), server.URL, Options{Auth: DigestAuth("admin", "secret")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wants := []string{"hello admin 00000001", "hello admin 00000002", "hello admin 00000001"}
+		for i, want := range wants {
+			result, err := client.Post("/dir/index.html?x=1", strings.NewReader("body"))
+			if err != nil || result != want {
+				t.Fatalf("%s request %d: got %q, %v; want %q", algorithm, i, result, err, want)
+			}
+		}
+		// 首次请求与nonce过期时各多一次质询往返
+		if handler.requests != 5 {
+			t.Fatalf("%s: server saw %d requests, want 5", algorithm, handler.requests)
+		}
+		client.End()
+		server.Close()
+	}
+}
+
+// 测试密码错误时不会反复重发
+func TestHttpClient_DigestAuth_Wrong_Password(t *testing.T) {
+	handler := &digestServer{algorithm: "SHA-256", realm: "test@hihttp", password: "secret"}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	client, err := HiHttp(context.Background(), server.URL, Options{Auth: DigestAuth("admin", "wrong")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	if _, err = client.Get("/"); err != ErrRequestFail {
+		t.Fatalf("expected ErrRequestFail, got %v", err)
+	}
+	if _, err = client.Get("/"); err != ErrRequestFail {
+		t.Fatalf("expected ErrRequestFail, got %v", err)
+	}
+	if handler.requests != 3 {
+		t.Fatalf("server saw %d requests, want 3", handler.requests)
+	}
+}
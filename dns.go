@@ -0,0 +1,290 @@
+package HiHttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 主机名解析: 静态解析(类似 curl --resolve)优先, 其次是进程内缓存, 最后调用解析器;
+// 解析得到的多个地址按 RFC 8305 (Happy Eyeballs) 交替地址族、错开时间并行连接
+
+// Errors
+var (
+	ErrNoAddress      = errors.New("no addresses found for host")
+	ErrInvalidAddress = errors.New("invalid ip address")
+)
+
+// default configs
+const (
+	DefDNSTTL       = 30 * time.Second       // 解析器未给出TTL时的缓存时长
+	DefAttemptDelay = 250 * time.Millisecond // RFC 8305 推荐的连接尝试间隔
+)
+
+// 主机名解析器
+type Resolver interface {
+	// 解析主机名, 返回地址以及结果可以缓存的时长(TTL), ttl为0时使用 DNSOptions.CacheTTL
+	LookupHost(ctx context.Context, host string) (addrs []net.IP, ttl time.Duration, err error)
+}
+
+// 以函数实现 Resolver
+type ResolverFunc func(ctx context.Context, host string) ([]net.IP, time.Duration, error)
+
+func (f ResolverFunc) LookupHost(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	return f(ctx, host)
+}
+
+// 系统解析器, 遵循 /etc/hosts 与搜索域, 但不提供TTL
+type systemResolver struct{}
+
+func (systemResolver) LookupHost(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, 0, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, 0, nil
+}
+
+// DNS解析配置
+type DNSOptions struct {
+	// 自定义解析器, 为空时使用系统解析器; DNSResolver 会按记录的TTL缓存
+	Resolver Resolver
+	// 静态解析, 键为 "host:port" 或 "host", 值为一个或多个IP, 优先于解析器与缓存
+	Hosts map[string][]string
+	// 解析器未给出TTL时的缓存时长, 为0时为 DefDNSTTL, 为负时不缓存任何结果
+	CacheTTL time.Duration
+	// 上一个地址尚未连接成功时开始连接下一个地址的间隔, 为0时为 DefAttemptDelay
+	AttemptDelay time.Duration
+}
+
+// 解析静态解析表, 键转为小写
+func parseHosts(hosts map[string][]string) (map[string][]net.IP, error) {
+	parsed := make(map[string][]net.IP, len(hosts))
+	for key, addrs := range hosts {
+		ips := make([]net.IP, 0, len(addrs))
+		for _, addr := range addrs {
+			ip := net.ParseIP(strings.Trim(addr, "[]"))
+			if ip == nil {
+				return nil, fmt.Errorf("%w: %q for %s", ErrInvalidAddress, addr, key)
+			}
+			ips = append(ips, ip)
+		}
+		if len(ips) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrNoAddress, key)
+		}
+		parsed[strings.ToLower(key)] = ips
+	}
+	return parsed, nil
+}
+
+// DNS缓存的最大条目数, 已满时先清除过期的条目, 仍然满时淘汰最早过期的条目
+const dnsCacheSize = 1024
+
+// 进程内的DNS缓存, 按TTL过期
+type dnsCache struct {
+	lock    sync.Mutex
+	ttl     time.Duration // 解析器未给出TTL时的缓存时长, 为负时不缓存
+	entries map[string]dnsEntry
+}
+
+type dnsEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+func newDNSCache(ttl time.Duration) *dnsCache {
+	if ttl == 0 {
+		ttl = DefDNSTTL
+	}
+	return &dnsCache{ttl: ttl, entries: make(map[string]dnsEntry)}
+}
+
+func (c *dnsCache) get(host string) []net.IP {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.entries[host]
+	if !ok {
+		return nil
+	}
+	if !time.Now().Before(entry.expires) {
+		delete(c.entries, host)
+		return nil
+	}
+	return entry.ips
+}
+
+func (c *dnsCache) put(host string, ips []net.IP, ttl time.Duration) {
+	if c.ttl < 0 {
+		return
+	}
+	if ttl <= 0 {
+		ttl = c.ttl
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	if _, ok := c.entries[host]; !ok && len(c.entries) >= dnsCacheSize {
+		c.sweep(now)
+	}
+	c.entries[host] = dnsEntry{ips: ips, expires: now.Add(ttl)}
+}
+
+// 清除过期的条目, 没有过期的条目时淘汰最早过期的一个; 调用时需持有锁
+func (c *dnsCache) sweep(now time.Time) {
+	var oldest string
+	for host, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, host)
+		} else if oldest == "" || entry.expires.Before(c.entries[oldest].expires) {
+			oldest = host
+		}
+	}
+	if len(c.entries) >= dnsCacheSize {
+		delete(c.entries, oldest)
+	}
+}
+
+// 丢弃缓存的地址, 例: 缓存的地址全部无法连接
+func (c *dnsCache) forget(host string) {
+	c.lock.Lock()
+	delete(c.entries, host)
+	c.lock.Unlock()
+}
+
+// 解析主机地址: IP直接返回, 其次是静态解析与缓存, 最后在 DNS 超时内调用解析器并缓存结果
+func (h *hiHttp) resolve(req *Request, host, port string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	host = strings.ToLower(host)
+	if ips, ok := h.hosts[net.JoinHostPort(host, port)]; ok {
+		return ips, nil
+	}
+	if ips, ok := h.hosts[host]; ok {
+		return ips, nil
+	}
+	if ips := h.dnsCache.get(host); ips != nil {
+		return ips, nil
+	}
+	deadline, timeoutErr := req.phaseDeadline(req.Timeouts.DNS, req.Timeout, ErrDNSTimeout)
+	ctx, cancel := context.WithDeadline(h.ctx, deadline)
+	ips, ttl, err := h.resolver.LookupHost(ctx, host)
+	cancel()
+	if err != nil {
+		return nil, phaseError(timeoutErr, err)
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoAddress, host)
+	}
+	h.dnsCache.put(host, ips, ttl)
+	return ips, nil
+}
+
+// 按 RFC 8305 4 排列地址: 保持解析结果中第一个地址的优先, 之后两个地址族交替排列
+func interleaveFamilies(ips []net.IP) []net.IP {
+	var first, second []net.IP
+	for _, ip := range ips {
+		if (ip.To4() == nil) == (ips[0].To4() == nil) {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+	sorted := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			sorted = append(sorted, first[i])
+		}
+		if i < len(second) {
+			sorted = append(sorted, second[i])
+		}
+	}
+	return sorted
+}
+
+// Happy Eyeballs (RFC 8305): 按交替地址族的顺序连接, 上一个连接在delay内未成功或已失败时开始下一个,
+// 返回最先成功的连接并关闭其余的; 全部失败时返回最后一个错误
+func dialParallel(ctx context.Context, dial func(ctx context.Context, addr string) (net.Conn, error),
+	ips []net.IP, port string, delay time.Duration) (net.Conn, error) {
+	if len(ips) == 1 {
+		return dial(ctx, net.JoinHostPort(ips[0].String(), port))
+	}
+	ips = interleaveFamilies(ips)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(ips))
+	next, pending := 0, 0
+	start := func() {
+		addr := net.JoinHostPort(ips[next].String(), port)
+		next++
+		pending++
+		go func() {
+			conn, err := dial(ctx, addr)
+			results <- result{conn, err}
+		}()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	start()
+	var err error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// 关闭随后建立成功的其余连接
+				go func(n int) {
+					for ; n > 0; n-- {
+						if r := <-results; r.conn != nil {
+							_ = r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			err = r.err
+			// 连接失败时不再等待, 立即开始下一个
+			if next < len(ips) {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				start()
+				timer.Reset(delay)
+			}
+		case <-timer.C:
+			if next < len(ips) {
+				start()
+				timer.Reset(delay)
+			}
+		}
+	}
+	return nil, err
+}
+
+// 将 host:port 解析为首选的 ip:port, 用于不进行并行连接的场合, 例: QUIC
+func (h *hiHttp) resolveAddr(req *Request, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	ips, err := h.resolve(req, host, port)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
@@ -0,0 +1,276 @@
+package HiHttp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 测试地址族的交替排列
+func TestInterleaveFamilies(t *testing.T) {
+	var ips []net.IP
+	for _, s := range []string{"::1", "::2", "::3", "10.0.0.1", "10.0.0.2"} {
+		ips = append(ips, net.ParseIP(s))
+	}
+	var got []string
+	for _, ip := range interleaveFamilies(ips) {
+		got = append(got, ip.String())
+	}
+	if want := "::1 10.0.0.1 ::2 10.0.0.2 ::3"; strings.Join(got, " ") != want {
+		t.Fatalf("got %v, want %s", got, want)
+	}
+}
+
+// 测试首选地址无响应时, 在间隔之后连接另一地址族并很快成功, 且不等待首选地址的连接超时
+func TestDialParallel(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	var attempts int32
+	dial := func(ctx context.Context, addr string) (net.Conn, error) {
+		atomic.AddInt32(&attempts, 1)
+		if strings.HasPrefix(addr, "[") {
+			// 模拟不通的IPv6: 直到被取消才返回
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	ips := []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), net.ParseIP("127.0.0.1")}
+	start := time.Now()
+	conn, err := dialParallel(context.Background(), dial, ips, port, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("happy eyeballs took %v", elapsed)
+	}
+	// 第二个尝试即为IPv4地址
+	if n := atomic.LoadInt32(&attempts); n != 2 {
+		t.Fatalf("attempts = %d, want 2", n)
+	}
+
+	// 全部失败时返回错误
+	refused := []net.IP{net.ParseIP("::1"), net.ParseIP("127.0.0.1")}
+	listener.Close()
+	tcp := func(ctx context.Context, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	if _, err := dialParallel(context.Background(), tcp, refused, port, time.Second); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// 测试自定义解析器、TTL缓存与静态解析
+func TestHttpClient_DNS_Cache_Hosts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	var lookups int32
+	resolver := ResolverFunc(func(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+		atomic.AddInt32(&lookups, 1)
+		if host != "api.test" {
+			return nil, 0, ErrNoAddress
+		}
+		return []net.IP{net.ParseIP("127.0.0.1")}, 200 * time.Millisecond, nil
+	})
+	client, err := HiHttp(context.Background(), "", Options{DNS: DNSOptions{
+		Resolver: resolver,
+		Hosts:    map[string][]string{"static.test:" + port: {"127.0.0.1"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	get := func(host string) {
+		t.Helper()
+		if result, err := client.Get("http://" + host + ":" + port + "/"); err != nil || result != host+":"+port {
+			t.Fatalf("%s: %q, %v", host, result, err)
+		}
+		// 关闭连接, 下次请求需要重新解析
+		client.End()
+	}
+	get("API.test")
+	get("api.test")
+	if n := atomic.LoadInt32(&lookups); n != 1 {
+		t.Fatalf("lookups = %d, want 1 within ttl", n)
+	}
+	time.Sleep(250 * time.Millisecond)
+	get("api.test")
+	if n := atomic.LoadInt32(&lookups); n != 2 {
+		t.Fatalf("lookups = %d, want 2 after ttl", n)
+	}
+	get("static.test")
+	if n := atomic.LoadInt32(&lookups); n != 2 {
+		t.Fatalf("static host was resolved: lookups = %d", n)
+	}
+	if _, err := client.Get("http://missing.test:" + port + "/"); !errors.Is(err, ErrNoAddress) || !errors.Is(err, ErrConnectingTimeout) {
+		t.Fatalf("expected ErrNoAddress, got %v", err)
+	}
+	if _, err := HiHttp(context.Background(), "", Options{DNS: DNSOptions{Hosts: map[string][]string{"x": {"nope"}}}}); !errors.Is(err, ErrInvalidAddress) {
+		t.Fatalf("expected ErrInvalidAddress, got %v", err)
+	}
+}
+
+// 模拟DNS服务器: 对A查询返回127.0.0.1, 对AAAA查询返回空的回答; truncate 为true时UDP响应只带截断标志
+func newDNSServer(t *testing.T, ttl uint32, truncate bool) string {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp, err := net.ListenPacket("udp", tcp.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tcp.Close(); _ = udp.Close() })
+	answer := func(query []byte, tc bool) []byte {
+		qtype := binary.BigEndian.Uint16(query[len(query)-4:])
+		msg := append([]byte(nil), query...)
+		msg[2] |= 0x80
+		if tc {
+			msg[2] |= 0x02
+			return msg
+		}
+		if strings.Contains(string(query), "missing") {
+			msg[3] |= dnsRcodeNameError
+			return msg
+		}
+		if qtype == dnsTypeA {
+			msg[7] = 2
+			// 别名记录与地址记录, 名字以压缩指针指向问题中的域名
+			msg = append(msg, 0xc0, 12, 0, 5, 0, 1, 0, 0, 0, 60, 0, 2, 0xc0, 12)
+			msg = append(msg, 0xc0, 12, 0, 1, 0, 1)
+			msg = append(msg, byte(ttl>>24), byte(ttl>>16), byte(ttl>>8), byte(ttl), 0, 4, 127, 0, 0, 1)
+		}
+		return msg
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = udp.WriteTo(answer(buf[:n], truncate), addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 514)
+			n, _ := conn.Read(buf)
+			if n > 2 {
+				msg := answer(buf[2:n], false)
+				_, _ = conn.Write(append([]byte{byte(len(msg) >> 8), byte(len(msg))}, msg...))
+			}
+			conn.Close()
+		}
+	}()
+	return tcp.Addr().String()
+}
+
+// 测试存根解析器读取记录的TTL, 跳过别名记录, 以及截断时改用TCP
+func TestDNSResolver(t *testing.T) {
+	for _, truncate := range []bool{false, true} {
+		r := DNSResolver(newDNSServer(t, 42, truncate))
+		ips, ttl, err := r.LookupHost(context.Background(), "api.test")
+		if err != nil || len(ips) != 1 || !ips[0].Equal(net.IPv4(127, 0, 0, 1)) || ttl != 42*time.Second {
+			t.Fatalf("truncate %v: got %v, %v, %v", truncate, ips, ttl, err)
+		}
+		if _, _, err := r.LookupHost(context.Background(), "missing.test"); !errors.Is(err, ErrNoAddress) {
+			t.Fatalf("expected ErrNoAddress, got %v", err)
+		}
+	}
+}
+
+// 测试查询报文拒绝过长的名字, 以及回答中只接受属于查询域名或其别名链的记录
+func TestParseDNSAnswer(t *testing.T) {
+	for _, host := range []string{strings.Repeat("a", 64) + ".test", strings.Repeat("abcdefg.", 32) + "test", "a..test"} {
+		if _, _, err := appendDNSQuery(nil, host, dnsTypeA); !errors.Is(err, ErrDNSName) {
+			t.Fatalf("%s: expected ErrDNSName, got %v", host, err)
+		}
+	}
+	query, id, err := appendDNSQuery(nil, "api.test", dnsTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := func(s string) []byte {
+		var b []byte
+		for _, label := range strings.Split(s, ".") {
+			b = append(append(b, byte(len(label))), label...)
+		}
+		return append(b, 0)
+	}
+	rr := func(owner []byte, typ uint16, data []byte) []byte {
+		b := append(append([]byte(nil), owner...), byte(typ>>8), byte(typ), 0, 1, 0, 0, 0, 60, 0, byte(len(data)))
+		return append(b, data...)
+	}
+	answer := func(records ...[]byte) []byte {
+		msg := append([]byte(nil), query...)
+		msg[2] |= 0x80
+		msg[7] = byte(len(records))
+		for _, r := range records {
+			msg = append(msg, r...)
+		}
+		return msg
+	}
+	cases := []struct {
+		name string
+		msg  []byte
+		want string
+	}{
+		// 别名记录在地址记录之后, 所属名字大小写不同
+		{"cname chain", answer(rr(name("cdn.example"), dnsTypeA, []byte{10, 0, 0, 2}),
+			rr([]byte{0xc0, 12}, dnsTypeCNAME, name("edge.example")), rr(name("EDGE.example"), dnsTypeCNAME, name("cdn.example"))), "10.0.0.2"},
+		{"unrelated owner", answer(rr(name("evil.test"), dnsTypeA, []byte{10, 0, 0, 3}),
+			rr([]byte{0xc0, 12}, dnsTypeA, []byte{10, 0, 0, 1})), "10.0.0.1"},
+		{"only unrelated", answer(rr(name("evil.test"), dnsTypeA, []byte{10, 0, 0, 3})), ""},
+		// 指向自身的压缩指针
+		{"pointer loop", answer(rr([]byte{0xc0, byte(len(query))}, dnsTypeA, []byte{10, 0, 0, 1})), ""},
+	}
+	for _, c := range cases {
+		ips, _, err := parseDNSAnswer(c.msg, id, dnsTypeA, "api.test")
+		if got := fmt.Sprint(ips); c.want == "" && err == nil || c.want != "" && (err != nil || got != "["+c.want+"]") {
+			t.Fatalf("%s: got %v, %v", c.name, ips, err)
+		}
+	}
+}
+
+// 测试DNS缓存的容量: 已满时先清除过期的条目, 再淘汰最早过期的条目
+func TestDNSCache_Size(t *testing.T) {
+	c := newDNSCache(0)
+	ip := []net.IP{net.IPv4(127, 0, 0, 1)}
+	c.put("expired", ip, time.Nanosecond)
+	c.put("soonest", ip, time.Minute)
+	for i := 0; len(c.entries) < dnsCacheSize; i++ {
+		c.put(fmt.Sprint("host", i), ip, time.Hour)
+	}
+	time.Sleep(time.Millisecond)
+	c.put("new1", ip, time.Hour)
+	if _, ok := c.entries["expired"]; ok || len(c.entries) != dnsCacheSize || c.get("soonest") == nil {
+		t.Fatalf("expired entry should be swept first: %d entries", len(c.entries))
+	}
+	c.put("new2", ip, time.Hour)
+	if _, ok := c.entries["soonest"]; ok || len(c.entries) != dnsCacheSize || c.get("new2") == nil {
+		t.Fatalf("soonest entry should be evicted: %d entries", len(c.entries))
+	}
+}
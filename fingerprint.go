@@ -0,0 +1,240 @@
+package HiHttp
+
+import (
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ClientHello的解析与JA3指纹: 握手时实际发出的ClientHello记录在 TLSInfo.ClientHello 中, 用于观察与比较;
+// ClientHello由 crypto/tls 生成, 本库不改变其结构(扩展及其顺序、GREASE), 因此不能用来模拟其它客户端的指纹
+
+// Errors
+var (
+	ErrMalformedHello = errors.New("malformed tls client hello")
+)
+
+// ClientHello中与指纹相关的内容, 均按发送的顺序并包括GREASE值
+type ClientHelloSpec struct {
+	Version           uint16 // legacy_version
+	CipherSuites      []uint16
+	Extensions        []uint16
+	Curves            []uint16 // supported_groups
+	PointFormats      []uint8
+	ALPN              []string
+	SupportedVersions []uint16
+	ServerName        string
+}
+
+// 解析一个或多个TLS记录中的ClientHello
+func ParseClientHello(records []byte) (*ClientHelloSpec, error) {
+	// 拼接记录中的握手数据
+	var msg []byte
+	for len(records) >= 5 && records[0] == 0x16 {
+		size := int(binary.BigEndian.Uint16(records[3:]))
+		if len(records) < 5+size {
+			break
+		}
+		msg = append(msg, records[5:5+size]...)
+		records = records[5+size:]
+	}
+	if len(msg) < 4 || msg[0] != 0x01 {
+		return nil, ErrMalformedHello
+	}
+	size := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+	if len(msg) < 4+size {
+		return nil, ErrMalformedHello
+	}
+	r := helloReader(msg[4 : 4+size])
+	hello := &ClientHelloSpec{}
+	var random, sessionID, suites, compression, extensions helloReader
+	if !r.uint16(&hello.Version) || !r.bytes(&random, 32) || !r.vector8(&sessionID) ||
+		!r.vector16(&suites) || !r.vector8(&compression) {
+		return nil, ErrMalformedHello
+	}
+	for len(suites) > 0 {
+		var id uint16
+		if !suites.uint16(&id) {
+			return nil, ErrMalformedHello
+		}
+		hello.CipherSuites = append(hello.CipherSuites, id)
+	}
+	if len(r) == 0 {
+		return hello, nil
+	}
+	if !r.vector16(&extensions) {
+		return nil, ErrMalformedHello
+	}
+	for len(extensions) > 0 {
+		var typ uint16
+		var data helloReader
+		if !extensions.uint16(&typ) || !extensions.vector16(&data) {
+			return nil, ErrMalformedHello
+		}
+		hello.Extensions = append(hello.Extensions, typ)
+		if !hello.parseExtension(typ, data) {
+			return nil, fmt.Errorf("%w: extension %d", ErrMalformedHello, typ)
+		}
+	}
+	return hello, nil
+}
+
+func (h *ClientHelloSpec) parseExtension(typ uint16, data helloReader) bool {
+	var list helloReader
+	switch typ {
+	case 0: // server_name
+		var name helloReader
+		if !data.vector16(&list) {
+			return false
+		}
+		for len(list) > 0 {
+			var kind uint8
+			if !list.uint8(&kind) || !list.vector16(&name) {
+				return false
+			}
+			if kind == 0 {
+				h.ServerName = string(name)
+			}
+		}
+	case 10: // supported_groups
+		if !data.vector16(&list) {
+			return false
+		}
+		for len(list) > 0 {
+			var id uint16
+			if !list.uint16(&id) {
+				return false
+			}
+			h.Curves = append(h.Curves, id)
+		}
+	case 11: // ec_point_formats
+		if !data.vector8(&list) {
+			return false
+		}
+		h.PointFormats = append([]uint8(nil), list...)
+	case 16: // application_layer_protocol_negotiation
+		var proto helloReader
+		if !data.vector16(&list) {
+			return false
+		}
+		for len(list) > 0 {
+			if !list.vector8(&proto) {
+				return false
+			}
+			h.ALPN = append(h.ALPN, string(proto))
+		}
+	case 43: // supported_versions
+		if !data.vector8(&list) {
+			return false
+		}
+		for len(list) > 0 {
+			var v uint16
+			if !list.uint16(&v) {
+				return false
+			}
+			h.SupportedVersions = append(h.SupportedVersions, v)
+		}
+	}
+	return true
+}
+
+// JA3指纹: 版本,密码套件,扩展,曲线,点格式, 各项以十进制表示并以"-"连接, 不包括GREASE值
+func (h *ClientHelloSpec) JA3() string {
+	join := func(values []uint16) string {
+		parts := make([]string, 0, len(values))
+		for _, v := range values {
+			if !isGREASE(v) {
+				parts = append(parts, strconv.Itoa(int(v)))
+			}
+		}
+		return strings.Join(parts, "-")
+	}
+	formats := make([]uint16, len(h.PointFormats))
+	for i, f := range h.PointFormats {
+		formats[i] = uint16(f)
+	}
+	return strconv.Itoa(int(h.Version)) + "," + join(h.CipherSuites) + "," + join(h.Extensions) + "," +
+		join(h.Curves) + "," + join(formats)
+}
+
+// JA3指纹的MD5, 以十六进制表示
+func (h *ClientHelloSpec) JA3Hash() string {
+	sum := md5.Sum([]byte(h.JA3()))
+	return hex.EncodeToString(sum[:])
+}
+
+// GREASE值(RFC 8701): 0x0a0a, 0x1a1a, ... 0xfafa
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>12 == v>>4&0xf
+}
+
+// 按TLS的编码读取ClientHello
+type helloReader []byte
+
+func (r *helloReader) uint8(v *uint8) bool {
+	if len(*r) < 1 {
+		return false
+	}
+	*v, *r = (*r)[0], (*r)[1:]
+	return true
+}
+
+func (r *helloReader) uint16(v *uint16) bool {
+	if len(*r) < 2 {
+		return false
+	}
+	*v, *r = binary.BigEndian.Uint16(*r), (*r)[2:]
+	return true
+}
+
+func (r *helloReader) bytes(v *helloReader, n int) bool {
+	if len(*r) < n {
+		return false
+	}
+	*v, *r = (*r)[:n], (*r)[n:]
+	return true
+}
+
+// 读取以1字节长度为前缀的数据
+func (r *helloReader) vector8(v *helloReader) bool {
+	var n uint8
+	return r.uint8(&n) && r.bytes(v, int(n))
+}
+
+// 读取以2字节长度为前缀的数据
+func (r *helloReader) vector16(v *helloReader) bool {
+	var n uint16
+	return r.uint16(&n) && r.bytes(v, int(n))
+}
+
+// 记录TLS握手时写出的第一段数据, 即ClientHello
+type helloRecorder struct {
+	net.Conn
+	hello []byte
+}
+
+func (c *helloRecorder) Write(b []byte) (int, error) {
+	if c.hello == nil {
+		c.hello = append([]byte(nil), b...)
+	}
+	return c.Conn.Write(b)
+}
+
+// 连接实际发出的ClientHello, 无法解析时为nil
+func sentClientHello(conn *tls.Conn) *ClientHelloSpec {
+	rec, ok := conn.NetConn().(*helloRecorder)
+	if !ok {
+		return nil
+	}
+	hello, err := ParseClientHello(rec.hello)
+	if err != nil {
+		return nil
+	}
+	return hello
+}
@@ -0,0 +1,39 @@
+package HiHttp
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// 测试解析捕获的Chrome ClientHello并计算JA3
+func TestParseClientHello(t *testing.T) {
+	hello, err := ParseClientHello(getClientHello())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53," +
+		"23-65281-10-11-35-16-5-13-18-51-45-43-27-21-41,29-23-24,0"
+	if hello.JA3() != want {
+		t.Fatalf("JA3 = %s", hello.JA3())
+	}
+	sum := md5.Sum([]byte(want))
+	if hello.JA3Hash() != hex.EncodeToString(sum[:]) {
+		t.Fatalf("JA3Hash = %s", hello.JA3Hash())
+	}
+	// GREASE 保留在解析结果中, 只在JA3中去除
+	if !isGREASE(hello.CipherSuites[0]) || !reflect.DeepEqual(hello.ALPN, []string{"h2", "http/1.1"}) {
+		t.Fatalf("%+v", hello)
+	}
+	if _, err := ParseClientHello(getClientHello()[:100]); !errors.Is(err, ErrMalformedHello) {
+		t.Fatalf("expected ErrMalformedHello for a truncated record, got %v", err)
+	}
+}
+
+// 捕获的Chrome ClientHello记录(GREASE、ALPN h2/http1.1 与常见的扩展)
+func getClientHello() []byte {
+	return []byte{0x16, 0x03, 0x01, 0x02, 0x00, 0x01, 0x00, 0x01, 0xfc, 0x03, 0x03, 0xf8, 0x1d, 0x99, 0xa4, 0x55, 0x20, 0x3d, 0x8b, 0xc8, 0x72, 0xda, 0xf7, 0x68, 0x7b, 0x23, 0xb5, 0x36, 0x45, 0xe3, 0x00, 0x2f, 0xe7, 0xf7, 0x10, 0xf2, 0x99, 0x45, 0x62, 0x81, 0x98, 0x1a, 0x76, 0x20, 0xbe, 0x14, 0x37, 0xff, 0x1e, 0x71, 0x5a, 0x29, 0x40, 0x2f, 0x96, 0xfd, 0xf0, 0x8f, 0x9a, 0x39, 0x32, 0x19, 0xc6, 0x9d, 0xf6, 0xcd, 0x9f, 0x98, 0x0b, 0xe3, 0x6a, 0x73, 0xdd, 0x80, 0x34, 0x22, 0x00, 0x20, 0x7a, 0x7a, 0x13, 0x01, 0x13, 0x02, 0x13, 0x03, 0xc0, 0x2b, 0xc0, 0x2f, 0xc0, 0x2c, 0xc0, 0x30, 0xcc, 0xa9, 0xcc, 0xa8, 0xc0, 0x13, 0xc0, 0x14, 0x00, 0x9c, 0x00, 0x9d, 0x00, 0x2f, 0x00, 0x35, 0x01, 0x00, 0x01, 0x93, 0x8a, 0x8a, 0x00, 0x00, 0x00, 0x17, 0x00, 0x00, 0xff, 0x01, 0x00, 0x01, 0x00, 0x00, 0x0a, 0x00, 0x0a, 0x00, 0x08, 0x6a, 0x6a, 0x00, 0x1d, 0x00, 0x17, 0x00, 0x18, 0x00, 0x0b, 0x00, 0x02, 0x01, 0x00, 0x00, 0x23, 0x00, 0x00, 0x00, 0x10, 0x00, 0x0e, 0x00, 0x0c, 0x02, 0x68, 0x32, 0x08, 0x68, 0x74, 0x74, 0x70, 0x2f, 0x31, 0x2e, 0x31, 0x00, 0x05, 0x00, 0x05, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0d, 0x00, 0x12, 0x00, 0x10, 0x04, 0x03, 0x08, 0x04, 0x04, 0x01, 0x05, 0x03, 0x08, 0x05, 0x05, 0x01, 0x08, 0x06, 0x06, 0x01, 0x00, 0x12, 0x00, 0x00, 0x00, 0x33, 0x00, 0x2b, 0x00, 0x29, 0x6a, 0x6a, 0x00, 0x01, 0x00, 0x00, 0x1d, 0x00, 0x20, 0x64, 0xbd, 0x66, 0xa0, 0x4a, 0xb9, 0x44, 0xc2, 0x04, 0x1b, 0x77, 0xce, 0xee, 0x69, 0x96, 0xff, 0x51, 0x00, 0x71, 0xeb, 0xd5, 0x08, 0x40, 0xe5, 0xb1, 0x2e, 0x14, 0xce, 0xbe, 0xd6, 0x34, 0x4c, 0x00, 0x2d, 0x00, 0x02, 0x01, 0x01, 0x00, 0x2b, 0x00, 0x0b, 0x0a, 0x4a, 0x4a, 0x03, 0x04, 0x03, 0x03, 0x03, 0x02, 0x03, 0x01, 0x00, 0x1b, 0x00, 0x03, 0x02, 0x00, 0x02, 0xca, 0xca, 0x00, 0x01, 0x00, 0x00, 0x15, 0x00, 0x45, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x29, 0x00, 0x9c, 0x00, 0x77, 0x00, 0x71, 0x2c, 0x8b, 0xa6, 0x74, 0x85, 0x07, 0xfb, 0x13, 0x96, 0x90, 0x06, 0xf1, 0x87, 0x03, 0xba, 0xf7, 0x53, 0x89, 0x40, 0xdb, 0xa2, 0x41, 0x88, 0x12, 0xe9, 0x72, 0x80, 0x08, 0xbd, 0x3b, 0x86, 0x46, 0x24, 0x8a, 0x46, 0x88, 0xc8, 0xba, 0xd3, 0x83, 0xbc, 0xd5, 0x96, 0x98, 0x73, 0x79, 0x4d, 0xfb, 0x66, 0x3e, 0x5f, 0x3b, 0x91, 0x5a, 0x4a, 0x64, 0xf2, 0xbc, 0x72, 0x7c, 0xe9, 0x1c, 0xa8, 0xdb, 0x39, 0x00, 0xda, 0xd4, 0x94, 0x37, 0xa7, 0x5b, 0x81, 0xc1, 0xad, 0xe1, 0xf1, 0x5b, 0xd3, 0x31, 0x8d, 0x7b, 0xb8, 0xb9, 0x04, 0x4a, 0x85, 0x73, 0x38, 0xf4, 0x6e, 0x2b, 0x24, 0xc8, 0x39, 0xf7, 0x8c, 0x12, 0x77, 0x49, 0x48, 0x51, 0xaa, 0x4a, 0x08, 0xa8, 0x5b, 0x56, 0xb9, 0xdc, 0x04, 0x87, 0xfe, 0x00, 0x01, 0xdc, 0x90, 0x00, 0x21, 0x20, 0xb8, 0xa9, 0x61, 0xc6, 0xf5, 0x23, 0x90, 0xc4, 0xb5, 0x60, 0x12, 0x45, 0x95, 0x77, 0x57, 0xbd, 0x5e, 0x8e, 0x00, 0x65, 0x01, 0x02, 0xff, 0x44, 0xd8, 0x7d, 0x92, 0x7f, 0x58, 0x50, 0x90, 0xd6}
+	//return []byte{0x16, 1, 0, 0, 0x11, 3, 1, 1, 2, 3, 4, 4, 5, 6, 7, 8, 4, 1, 1, 0, 1, 0}
+}
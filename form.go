@@ -0,0 +1,407 @@
+package HiHttp
+
+import (
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// application/x-www-form-urlencoded 表单编码与查询参数构建
+// 结构体字段名可通过 `form:"name,omitempty"` 标签指定, "-" 表示忽略; 切片/数组字段编码为重复的key
+
+func init() {
+	RegisterCodec(URLENCODED, formCodec{})
+}
+
+// FormValues 将 url.Values / map[string]string / map[string][]string / map[string]interface{}
+// 或带 form 标签的结构体转换为表单值
+func FormValues(v interface{}) (url.Values, error) {
+	values := url.Values{}
+	switch x := v.(type) {
+	case nil:
+		return values, nil
+	case url.Values:
+		for key, vals := range x {
+			values[key] = append([]string(nil), vals...)
+		}
+		return values, nil
+	case map[string][]string:
+		return FormValues(url.Values(x))
+	case map[string]string:
+		for key, val := range x {
+			values.Set(key, val)
+		}
+		return values, nil
+	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return values, nil
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("form: unsupported map key type %s", rv.Type().Key())
+		}
+		iter := rv.MapRange()
+		for iter.Next() {
+			if err := addFormValue(values, iter.Key().String(), iter.Value(), false); err != nil {
+				return nil, err
+			}
+		}
+	case reflect.Struct:
+		for _, f := range formFields(rv.Type()) {
+			if err := addFormValue(values, f.name, rv.FieldByIndex(f.index), f.omitEmpty); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("form: unsupported type %s", rv.Type())
+	}
+	return values, nil
+}
+
+// EncodeForm 编码为 a=1&b=2 形式的表单字符串, key按字典序排列
+func EncodeForm(v interface{}) (string, error) {
+	values, err := FormValues(v)
+	if err != nil {
+		return "", err
+	}
+	return values.Encode(), nil
+}
+
+// 添加一个字段的值, 切片与数组展开为重复的key
+func addFormValue(values url.Values, key string, v reflect.Value, omitEmpty bool) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if omitEmpty && isEmptyValue(v) {
+		return nil
+	}
+	if (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() != reflect.Uint8 {
+		for i := 0; i < v.Len(); i++ {
+			if err := addFormValue(values, key, v.Index(i), false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	s, err := formatFormValue(v)
+	if err != nil {
+		return fmt.Errorf("form: field %q: %w", key, err)
+	}
+	values.Add(key, s)
+	return nil
+}
+
+func formatFormValue(v reflect.Value) (string, error) {
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).Format(time.RFC3339Nano), nil
+	}
+	if v.CanInterface() {
+		if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+			b, err := m.MarshalText()
+			return string(b), err
+		}
+		if s, ok := v.Interface().(fmt.Stringer); ok {
+			return s.String(), nil
+		}
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+	case reflect.Slice:
+		// []byte 按字符串处理
+		return string(v.Bytes()), nil
+	}
+	return "", fmt.Errorf("unsupported type %s", v.Type())
+}
+
+/// -------------------------------- 结构体字段 --------------------------------
+
+type formField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var formFieldCache sync.Map // reflect.Type -> []formField
+
+func formFields(t reflect.Type) []formField {
+	if cached, ok := formFieldCache.Load(t); ok {
+		return cached.([]formField)
+	}
+	var fields []formField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("form")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if idx := strings.Index(tag, ","); idx >= 0 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+		// 未指定名称的匿名结构体字段展开到外层
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			for _, inner := range formFields(sf.Type) {
+				inner.index = append([]int{i}, inner.index...)
+				fields = append(fields, inner)
+			}
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, formField{
+			name:      name,
+			index:     []int{i},
+			omitEmpty: strings.Contains(opts, "omitempty"),
+		})
+	}
+	formFieldCache.Store(t, fields)
+	return fields
+}
+
+/// -------------------------------- 表单编解码器 --------------------------------
+
+type formCodec struct{}
+
+func (formCodec) Marshal(v interface{}) ([]byte, error) {
+	s, err := EncodeForm(v)
+	return []byte(s), err
+}
+
+// 支持解码到 *url.Values / *map[string][]string / *map[string]string 或结构体指针
+func (formCodec) Unmarshal(data []byte, v interface{}) error {
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return err
+	}
+	switch x := v.(type) {
+	case *url.Values:
+		*x = values
+		return nil
+	case *map[string][]string:
+		*x = values
+		return nil
+	case *map[string]string:
+		*x = make(map[string]string, len(values))
+		for key := range values {
+			(*x)[key] = values.Get(key)
+		}
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("form: cannot decode into %T", v)
+	}
+	rv = rv.Elem()
+	for _, f := range formFields(rv.Type()) {
+		vals, ok := values[f.name]
+		if !ok {
+			continue
+		}
+		if err = setFormField(fieldByIndexAlloc(rv, f.index), vals); err != nil {
+			return fmt.Errorf("form: field %q: %w", f.name, err)
+		}
+	}
+	return nil
+}
+
+func setFormField(v reflect.Value, vals []string) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setFormField(v.Elem(), vals)
+	}
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+		slice := reflect.MakeSlice(v.Type(), len(vals), len(vals))
+		for i, s := range vals {
+			if err := parseFormValue(slice.Index(i), s); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+	return parseFormValue(v, vals[0])
+}
+
+func parseFormValue(v reflect.Value, s string) error {
+	if v.Type() == timeType {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err == nil {
+			v.Set(reflect.ValueOf(t))
+		}
+		return err
+	}
+	if v.CanAddr() {
+		if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText([]byte(s))
+		}
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		v.SetBytes([]byte(s))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+/// -------------------------------- 查询参数 --------------------------------
+
+// Query 查询参数构建器, 例:
+//
+//	client.Get(NewQuery().Add("q", "你好").Add("tag", "a&b").Apply("/search?page=1"))
+type Query struct {
+	values url.Values
+	keys   []string // 记录key首次出现的顺序, 保证编码结果与添加顺序一致
+}
+
+func NewQuery() *Query {
+	return &Query{values: url.Values{}}
+}
+
+// Add 追加参数, 同名参数会保留多个值
+func (q *Query) Add(key, value string) *Query {
+	if _, ok := q.values[key]; !ok {
+		q.keys = append(q.keys, key)
+	}
+	q.values.Add(key, value)
+	return q
+}
+
+// Set 设置参数, 会覆盖构建器中已有的同名参数
+func (q *Query) Set(key, value string) *Query {
+	if _, ok := q.values[key]; !ok {
+		q.keys = append(q.keys, key)
+	}
+	q.values.Set(key, value)
+	return q
+}
+
+// Del 删除构建器中的参数
+func (q *Query) Del(key string) *Query {
+	if _, ok := q.values[key]; !ok {
+		return q
+	}
+	q.values.Del(key)
+	for i, k := range q.keys {
+		if k == key {
+			q.keys = append(q.keys[:i], q.keys[i+1:]...)
+			break
+		}
+	}
+	return q
+}
+
+// AddValues 从 map 或带 form 标签的结构体中追加参数
+func (q *Query) AddValues(v interface{}) error {
+	values, err := FormValues(v)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, val := range values[key] {
+			q.Add(key, val)
+		}
+	}
+	return nil
+}
+
+// Encode 编码为查询字符串(不含"?")
+func (q *Query) Encode() string {
+	var sb strings.Builder
+	for _, key := range q.keys {
+		for _, val := range q.values[key] {
+			if sb.Len() > 0 {
+				sb.WriteByte('&')
+			}
+			sb.WriteString(url.QueryEscape(key))
+			sb.WriteByte('=')
+			sb.WriteString(url.QueryEscape(val))
+		}
+	}
+	return sb.String()
+}
+
+// Apply 将参数合并到path已有的查询字符串之后, path中原有的参数与#片段保持不变
+func (q *Query) Apply(path string) string {
+	encoded := q.Encode()
+	if encoded == "" {
+		return path
+	}
+	fragment := ""
+	if idx := strings.IndexByte(path, '#'); idx >= 0 {
+		path, fragment = path[:idx], path[idx:]
+	}
+	switch {
+	case !strings.Contains(path, "?"):
+		path += "?"
+	case !strings.HasSuffix(path, "?") && !strings.HasSuffix(path, "&"):
+		path += "&"
+	}
+	return path + encoded + fragment
+}
+
+func (q *Query) String() string {
+	return q.Encode()
+}
@@ -0,0 +1,117 @@
+package HiHttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+type formUser struct {
+	Name    string   `form:"name"`
+	Age     int      `form:"age,omitempty"`
+	Tags    []string `form:"tag"`
+	Admin   bool     `form:"admin"`
+	Comment *string  `form:"comment"`
+	Ignored string   `form:"-"`
+}
+
+// 测试结构体与map的表单编码, 包括重复key与UTF-8/保留字符转义
+func TestEncodeForm(t *testing.T) {
+	encoded, err := EncodeForm(formUser{Name: "李 鸿辉", Tags: []string{"a&b", "c=d/e?"}, Ignored: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "admin=false&name=%E6%9D%8E+%E9%B8%BF%E8%BE%89&tag=a%26b&tag=c%3Dd%2Fe%3F"
+	if encoded != want {
+		t.Fatalf("got %s, want %s", encoded, want)
+	}
+	encoded, err = EncodeForm(map[string]interface{}{"n": 1.5, "list": []int{1, 2}, "+": "100%"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want = "%2B=100%25&list=1&list=2&n=1.5"; encoded != want {
+		t.Fatalf("got %s, want %s", encoded, want)
+	}
+	if _, err = EncodeForm(42); err == nil {
+		t.Fatal("expected error for unsupported type")
+	}
+}
+
+// 测试表单解码到结构体
+func TestFormCodec_Unmarshal(t *testing.T) {
+	codec, ok := GetCodec(URLENCODED + "; charset=utf-8")
+	if !ok {
+		t.Fatal("form codec not registered")
+	}
+	comment := "ok"
+	in := formUser{Name: "名字", Age: 3, Tags: []string{"x", "y"}, Admin: true, Comment: &comment}
+	data, err := codec.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out formUser
+	if err = codec.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("got %+v, want %+v", out, in)
+	}
+}
+
+// 测试查询参数构建器与路径中已有参数的合并
+func TestQuery_Apply(t *testing.T) {
+	q := NewQuery().Add("q", "你好 world").Add("tag", "a&b").Add("tag", "c").Set("page", "2")
+	cases := map[string]string{
+		"/search":             "/search?q=%E4%BD%A0%E5%A5%BD+world&tag=a%26b&tag=c&page=2",
+		"/search?":            "/search?q=%E4%BD%A0%E5%A5%BD+world&tag=a%26b&tag=c&page=2",
+		"/search?page=1":      "/search?page=1&q=%E4%BD%A0%E5%A5%BD+world&tag=a%26b&tag=c&page=2",
+		"/search?sort=asc#id": "/search?sort=asc&q=%E4%BD%A0%E5%A5%BD+world&tag=a%26b&tag=c&page=2#id",
+	}
+	for path, want := range cases {
+		if got := q.Apply(path); got != want {
+			t.Errorf("Apply(%q) = %q, want %q", path, got, want)
+		}
+	}
+	q.Del("tag")
+	if got := q.Encode(); got != "q=%E4%BD%A0%E5%A5%BD+world&page=2" {
+		t.Fatalf("after Del: %s", got)
+	}
+	if err := q.AddValues(url.Values{"k": {"1", "2"}}); err != nil || q.Encode() != "q=%E4%BD%A0%E5%A5%BD+world&page=2&k=1&k=2" {
+		t.Fatalf("after AddValues: %s, %v", q.Encode(), err)
+	}
+}
+
+// 测试PostForm提交表单, 以及Get时合并查询参数
+func TestHttpClient_PostForm(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", URLENCODED)
+		_, _ = w.Write([]byte(req.Form.Encode()))
+	}))
+	defer server.Close()
+	client, err := HiHttp(context.Background(), server.URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	result, err := client.PostForm("/form", formUser{Name: "a b", Tags: []string{"1", "2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "admin=false&name=a+b&tag=1&tag=2"; result != want {
+		t.Fatalf("got %s, want %s", result, want)
+	}
+	var values url.Values
+	if err = client.GetObject(NewQuery().Add("b", "中/文").Apply("/query?a=1"), &values); err != nil {
+		t.Fatal(err)
+	}
+	if values.Get("a") != "1" || values.Get("b") != "中/文" {
+		t.Fatalf("unexpected query: %v", values)
+	}
+}
@@ -1,3 +1,3 @@
 module HiHttp
 
-go 1.15
+go 1.21
@@ -0,0 +1,18 @@
+//go:build go1.24
+// +build go1.24
+
+package HiHttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 以h2c(prior knowledge)启动测试服务
+func startH2C(t *testing.T, server *httptest.Server) {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	server.Config.Protocols = protocols
+	server.Start()
+}
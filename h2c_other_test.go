@@ -0,0 +1,14 @@
+//go:build !go1.24
+// +build !go1.24
+
+package HiHttp
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// 标准库在Go 1.24之前的服务端不支持h2c
+func startH2C(t *testing.T, server *httptest.Server) {
+	t.Skip("h2c test server requires go1.24")
+}
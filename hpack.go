@@ -0,0 +1,478 @@
+package HiHttp
+
+import (
+	"errors"
+	"strings"
+)
+
+// HPACK 报文头压缩(RFC 7541), 供HTTP/2使用;
+// 编码时不使用动态表(仅静态表引用与字面值), 解码时完整支持动态表与Huffman编码
+
+// Errors
+var (
+	ErrHpackDecode = errors.New("hpack: invalid header block")
+)
+
+type hpackField struct {
+	name, value string
+}
+
+// 动态表中条目的大小 (RFC 7541 4.1)
+func (f hpackField) size() uint32 {
+	return uint32(len(f.name)+len(f.value)) + 32
+}
+
+// 静态表 (RFC 7541 附录A), 索引从1开始
+var hpackStaticTable = [...]hpackField{
+	{":authority", ""},
+	{":method", "GET"},
+	{":method", "POST"},
+	{":path", "/"},
+	{":path", "/index.html"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "200"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "304"},
+	{":status", "400"},
+	{":status", "404"},
+	{":status", "500"},
+	{"accept-charset", ""},
+	{"accept-encoding", "gzip, deflate"},
+	{"accept-language", ""},
+	{"accept-ranges", ""},
+	{"accept", ""},
+	{"access-control-allow-origin", ""},
+	{"age", ""},
+	{"allow", ""},
+	{"authorization", ""},
+	{"cache-control", ""},
+	{"content-disposition", ""},
+	{"content-encoding", ""},
+	{"content-language", ""},
+	{"content-length", ""},
+	{"content-location", ""},
+	{"content-range", ""},
+	{"content-type", ""},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"expect", ""},
+	{"expires", ""},
+	{"from", ""},
+	{"host", ""},
+	{"if-match", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"if-range", ""},
+	{"if-unmodified-since", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"max-forwards", ""},
+	{"proxy-authenticate", ""},
+	{"proxy-authorization", ""},
+	{"range", ""},
+	{"referer", ""},
+	{"refresh", ""},
+	{"retry-after", ""},
+	{"server", ""},
+	{"set-cookie", ""},
+	{"strict-transport-security", ""},
+	{"transfer-encoding", ""},
+	{"user-agent", ""},
+	{"vary", ""},
+	{"via", ""},
+	{"www-authenticate", ""},
+}
+
+// 静态表的反向索引: 完全匹配与仅名称匹配
+var (
+	hpackStaticFields = map[hpackField]int{}
+	hpackStaticNames  = map[string]int{}
+)
+
+func init() {
+	for i, f := range hpackStaticTable {
+		if _, ok := hpackStaticFields[f]; !ok {
+			hpackStaticFields[f] = i + 1
+		}
+		if _, ok := hpackStaticNames[f.name]; !ok {
+			hpackStaticNames[f.name] = i + 1
+		}
+	}
+	buildHuffmanTree()
+}
+
+/// -------------------------------- 编码 --------------------------------
+
+// 追加一个报文头字段的编码, 名称需为小写
+func appendHpackField(dst []byte, name, value string) []byte {
+	if idx, ok := hpackStaticFields[hpackField{name, value}]; ok {
+		// 索引字段 (RFC 7541 6.1)
+		return appendHpackInt(dst, 7, 0x80, uint64(idx))
+	}
+	// 不索引的字面值 (RFC 7541 6.2.2), 敏感字段使用永不索引
+	pattern := byte(If(neverIndexed(name), 0x10, 0).(int))
+	if idx, ok := hpackStaticNames[name]; ok {
+		dst = appendHpackInt(dst, 4, pattern, uint64(idx))
+	} else {
+		dst = append(dst, pattern)
+		dst = appendHpackString(dst, name)
+	}
+	return appendHpackString(dst, value)
+}
+
+// 携带凭据的字段, 编码时要求中间节点不得索引
+func neverIndexed(name string) bool {
+	return name == "authorization" || name == "cookie" || name == "proxy-authorization"
+}
+
+// 整数编码 (RFC 7541 5.1), pattern 为首字节中前缀之外的高位
+func appendHpackInt(dst []byte, prefix uint8, pattern byte, n uint64) []byte {
+	max := uint64(1)<<prefix - 1
+	if n < max {
+		return append(dst, pattern|byte(n))
+	}
+	dst = append(dst, pattern|byte(max))
+	for n -= max; n >= 0x80; n >>= 7 {
+		dst = append(dst, byte(n&0x7f)|0x80)
+	}
+	return append(dst, byte(n))
+}
+
+// 字符串编码 (RFC 7541 5.2), Huffman编码更短时使用Huffman
+func appendHpackString(dst []byte, s string) []byte {
+	return appendPrefixedString(dst, 7, 0, s)
+}
+
+// 长度使用prefix位前缀的字符串编码, Huffman标志位于前缀之上, 供QPACK复用
+func appendPrefixedString(dst []byte, prefix uint8, pattern byte, s string) []byte {
+	if n := huffmanEncodedLen(s); n < uint64(len(s)) {
+		dst = appendHpackInt(dst, prefix, pattern|1<<prefix, n)
+		return appendHuffman(dst, s)
+	}
+	dst = appendHpackInt(dst, prefix, pattern, uint64(len(s)))
+	return append(dst, s...)
+}
+
+/// -------------------------------- 解码 --------------------------------
+
+type hpackDecoder struct {
+	dynamic []hpackField // 动态表, 最新的条目在末尾
+	size    uint32       // 动态表当前大小
+	maxSize uint32       // 动态表当前上限
+	limit   uint32       // 本端通告的 SETTINGS_HEADER_TABLE_SIZE, 上限不能超过该值
+}
+
+func newHpackDecoder(limit uint32) *hpackDecoder {
+	return &hpackDecoder{maxSize: limit, limit: limit}
+}
+
+// 解码一个完整的报文头块
+func (d *hpackDecoder) decode(block []byte) (fields []hpackField, err error) {
+	for len(block) > 0 {
+		b := block[0]
+		switch {
+		case b&0x80 != 0:
+			// 索引字段
+			var idx uint64
+			if idx, block, err = readHpackInt(block, 7); err != nil {
+				return nil, err
+			}
+			f, ok := d.field(idx)
+			if !ok {
+				return nil, ErrHpackDecode
+			}
+			fields = append(fields, f)
+		case b&0xc0 == 0x40:
+			// 增量索引的字面值
+			var f hpackField
+			if f, block, err = d.readLiteral(block, 6); err != nil {
+				return nil, err
+			}
+			d.add(f)
+			fields = append(fields, f)
+		case b&0xe0 == 0x20:
+			// 动态表大小更新
+			var size uint64
+			if size, block, err = readHpackInt(block, 5); err != nil {
+				return nil, err
+			}
+			if size > uint64(d.limit) {
+				return nil, ErrHpackDecode
+			}
+			d.maxSize = uint32(size)
+			d.evict(0)
+		default:
+			// 不索引或永不索引的字面值
+			var f hpackField
+			if f, block, err = d.readLiteral(block, 4); err != nil {
+				return nil, err
+			}
+			fields = append(fields, f)
+		}
+	}
+	return fields, nil
+}
+
+func (d *hpackDecoder) field(idx uint64) (hpackField, bool) {
+	if idx == 0 {
+		return hpackField{}, false
+	}
+	if idx <= uint64(len(hpackStaticTable)) {
+		return hpackStaticTable[idx-1], true
+	}
+	idx -= uint64(len(hpackStaticTable))
+	if idx > uint64(len(d.dynamic)) {
+		return hpackField{}, false
+	}
+	return d.dynamic[uint64(len(d.dynamic))-idx], true
+}
+
+func (d *hpackDecoder) readLiteral(block []byte, prefix uint8) (f hpackField, rest []byte, err error) {
+	var idx uint64
+	if idx, rest, err = readHpackInt(block, prefix); err != nil {
+		return
+	}
+	if idx == 0 {
+		if f.name, rest, err = readHpackString(rest); err != nil {
+			return
+		}
+	} else {
+		named, ok := d.field(idx)
+		if !ok {
+			return f, nil, ErrHpackDecode
+		}
+		f.name = named.name
+	}
+	f.value, rest, err = readHpackString(rest)
+	return
+}
+
+// 向动态表插入条目, 超出上限时淘汰最早的条目
+func (d *hpackDecoder) add(f hpackField) {
+	if f.size() > d.maxSize {
+		d.dynamic, d.size = d.dynamic[:0], 0
+		return
+	}
+	d.evict(f.size())
+	d.dynamic = append(d.dynamic, f)
+	d.size += f.size()
+}
+
+func (d *hpackDecoder) evict(incoming uint32) {
+	n := 0
+	for d.size+incoming > d.maxSize && n < len(d.dynamic) {
+		d.size -= d.dynamic[n].size()
+		n++
+	}
+	d.dynamic = append(d.dynamic[:0], d.dynamic[n:]...)
+}
+
+func readHpackInt(block []byte, prefix uint8) (n uint64, rest []byte, err error) {
+	if len(block) == 0 {
+		return 0, nil, ErrHpackDecode
+	}
+	max := uint64(1)<<prefix - 1
+	n = uint64(block[0]) & max
+	rest = block[1:]
+	if n < max {
+		return n, rest, nil
+	}
+	for shift := uint(0); len(rest) > 0; shift += 7 {
+		if shift > 56 {
+			break
+		}
+		b := rest[0]
+		rest = rest[1:]
+		n += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return n, rest, nil
+		}
+	}
+	return 0, nil, ErrHpackDecode
+}
+
+func readHpackString(block []byte) (s string, rest []byte, err error) {
+	return readPrefixedString(block, 7)
+}
+
+func readPrefixedString(block []byte, prefix uint8) (s string, rest []byte, err error) {
+	if len(block) == 0 {
+		return "", nil, ErrHpackDecode
+	}
+	huffman := block[0]&(1<<prefix) != 0
+	var n uint64
+	if n, rest, err = readHpackInt(block, prefix); err != nil {
+		return
+	}
+	if n > uint64(len(rest)) {
+		return "", nil, ErrHpackDecode
+	}
+	data := rest[:n]
+	rest = rest[n:]
+	if !huffman {
+		return string(data), rest, nil
+	}
+	s, err = huffmanDecode(data)
+	return
+}
+
+/// -------------------------------- Huffman --------------------------------
+
+// Huffman编码表 (RFC 7541 附录B), 下标为符号, 256为EOS
+var huffmanCodes = [257]struct {
+	code uint32
+	bits uint8
+}{
+	{0x1ff8, 13}, {0x7fffd8, 23}, {0xfffffe2, 28}, {0xfffffe3, 28},
+	{0xfffffe4, 28}, {0xfffffe5, 28}, {0xfffffe6, 28}, {0xfffffe7, 28},
+	{0xfffffe8, 28}, {0xffffea, 24}, {0x3ffffffc, 30}, {0xfffffe9, 28},
+	{0xfffffea, 28}, {0x3ffffffd, 30}, {0xfffffeb, 28}, {0xfffffec, 28},
+	{0xfffffed, 28}, {0xfffffee, 28}, {0xfffffef, 28}, {0xffffff0, 28},
+	{0xffffff1, 28}, {0xffffff2, 28}, {0x3ffffffe, 30}, {0xffffff3, 28},
+	{0xffffff4, 28}, {0xffffff5, 28}, {0xffffff6, 28}, {0xffffff7, 28},
+	{0xffffff8, 28}, {0xffffff9, 28}, {0xffffffa, 28}, {0xffffffb, 28},
+	{0x14, 6}, {0x3f8, 10}, {0x3f9, 10}, {0xffa, 12},
+	{0x1ff9, 13}, {0x15, 6}, {0xf8, 8}, {0x7fa, 11},
+	{0x3fa, 10}, {0x3fb, 10}, {0xf9, 8}, {0x7fb, 11},
+	{0xfa, 8}, {0x16, 6}, {0x17, 6}, {0x18, 6},
+	{0x0, 5}, {0x1, 5}, {0x2, 5}, {0x19, 6},
+	{0x1a, 6}, {0x1b, 6}, {0x1c, 6}, {0x1d, 6},
+	{0x1e, 6}, {0x1f, 6}, {0x5c, 7}, {0xfb, 8},
+	{0x7ffc, 15}, {0x20, 6}, {0xffb, 12}, {0x3fc, 10},
+	{0x1ffa, 13}, {0x21, 6}, {0x5d, 7}, {0x5e, 7},
+	{0x5f, 7}, {0x60, 7}, {0x61, 7}, {0x62, 7},
+	{0x63, 7}, {0x64, 7}, {0x65, 7}, {0x66, 7},
+	{0x67, 7}, {0x68, 7}, {0x69, 7}, {0x6a, 7},
+	{0x6b, 7}, {0x6c, 7}, {0x6d, 7}, {0x6e, 7},
+	{0x6f, 7}, {0x70, 7}, {0x71, 7}, {0x72, 7},
+	{0xfc, 8}, {0x73, 7}, {0xfd, 8}, {0x1ffb, 13},
+	{0x7fff0, 19}, {0x1ffc, 13}, {0x3ffc, 14}, {0x22, 6},
+	{0x7ffd, 15}, {0x3, 5}, {0x23, 6}, {0x4, 5},
+	{0x24, 6}, {0x5, 5}, {0x25, 6}, {0x26, 6},
+	{0x27, 6}, {0x6, 5}, {0x74, 7}, {0x75, 7},
+	{0x28, 6}, {0x29, 6}, {0x2a, 6}, {0x7, 5},
+	{0x2b, 6}, {0x76, 7}, {0x2c, 6}, {0x8, 5},
+	{0x9, 5}, {0x2d, 6}, {0x77, 7}, {0x78, 7},
+	{0x79, 7}, {0x7a, 7}, {0x7b, 7}, {0x7ffe, 15},
+	{0x7fc, 11}, {0x3ffd, 14}, {0x1ffd, 13}, {0xffffffc, 28},
+	{0xfffe6, 20}, {0x3fffd2, 22}, {0xfffe7, 20}, {0xfffe8, 20},
+	{0x3fffd3, 22}, {0x3fffd4, 22}, {0x3fffd5, 22}, {0x7fffd9, 23},
+	{0x3fffd6, 22}, {0x7fffda, 23}, {0x7fffdb, 23}, {0x7fffdc, 23},
+	{0x7fffdd, 23}, {0x7fffde, 23}, {0xffffeb, 24}, {0x7fffdf, 23},
+	{0xffffec, 24}, {0xffffed, 24}, {0x3fffd7, 22}, {0x7fffe0, 23},
+	{0xffffee, 24}, {0x7fffe1, 23}, {0x7fffe2, 23}, {0x7fffe3, 23},
+	{0x7fffe4, 23}, {0x1fffdc, 21}, {0x3fffd8, 22}, {0x7fffe5, 23},
+	{0x3fffd9, 22}, {0x7fffe6, 23}, {0x7fffe7, 23}, {0xffffef, 24},
+	{0x3fffda, 22}, {0x1fffdd, 21}, {0xfffe9, 20}, {0x3fffdb, 22},
+	{0x3fffdc, 22}, {0x7fffe8, 23}, {0x7fffe9, 23}, {0x1fffde, 21},
+	{0x7fffea, 23}, {0x3fffdd, 22}, {0x3fffde, 22}, {0xfffff0, 24},
+	{0x1fffdf, 21}, {0x3fffdf, 22}, {0x7fffeb, 23}, {0x7fffec, 23},
+	{0x1fffe0, 21}, {0x1fffe1, 21}, {0x3fffe0, 22}, {0x1fffe2, 21},
+	{0x7fffed, 23}, {0x3fffe1, 22}, {0x7fffee, 23}, {0x7fffef, 23},
+	{0xfffea, 20}, {0x3fffe2, 22}, {0x3fffe3, 22}, {0x3fffe4, 22},
+	{0x7ffff0, 23}, {0x3fffe5, 22}, {0x3fffe6, 22}, {0x7ffff1, 23},
+	{0x3ffffe0, 26}, {0x3ffffe1, 26}, {0xfffeb, 20}, {0x7fff1, 19},
+	{0x3fffe7, 22}, {0x7ffff2, 23}, {0x3fffe8, 22}, {0x1ffffec, 25},
+	{0x3ffffe2, 26}, {0x3ffffe3, 26}, {0x3ffffe4, 26}, {0x7ffffde, 27},
+	{0x7ffffdf, 27}, {0x3ffffe5, 26}, {0xfffff1, 24}, {0x1ffffed, 25},
+	{0x7fff2, 19}, {0x1fffe3, 21}, {0x3ffffe6, 26}, {0x7ffffe0, 27},
+	{0x7ffffe1, 27}, {0x3ffffe7, 26}, {0x7ffffe2, 27}, {0xfffff2, 24},
+	{0x1fffe4, 21}, {0x1fffe5, 21}, {0x3ffffe8, 26}, {0x3ffffe9, 26},
+	{0xffffffd, 28}, {0x7ffffe3, 27}, {0x7ffffe4, 27}, {0x7ffffe5, 27},
+	{0xfffec, 20}, {0xfffff3, 24}, {0xfffed, 20}, {0x1fffe6, 21},
+	{0x3fffe9, 22}, {0x1fffe7, 21}, {0x1fffe8, 21}, {0x7ffff3, 23},
+	{0x3fffea, 22}, {0x3fffeb, 22}, {0x1ffffee, 25}, {0x1ffffef, 25},
+	{0xfffff4, 24}, {0xfffff5, 24}, {0x3ffffea, 26}, {0x7ffff4, 23},
+	{0x3ffffeb, 26}, {0x7ffffe6, 27}, {0x3ffffec, 26}, {0x3ffffed, 26},
+	{0x7ffffe7, 27}, {0x7ffffe8, 27}, {0x7ffffe9, 27}, {0x7ffffea, 27},
+	{0x7ffffeb, 27}, {0xffffffe, 28}, {0x7ffffec, 27}, {0x7ffffed, 27},
+	{0x7ffffee, 27}, {0x7ffffef, 27}, {0x7fffff0, 27}, {0x3ffffee, 26},
+	{0x3fffffff, 30}, // EOS
+}
+
+const huffmanEOS = 256
+
+// 解码树, 节点0为根; sym >= 0 的节点为叶子
+type huffmanNode struct {
+	next [2]int32
+	sym  int32
+}
+
+var huffmanTree []huffmanNode
+
+func buildHuffmanTree() {
+	huffmanTree = []huffmanNode{{sym: -1}}
+	for sym, c := range huffmanCodes {
+		node := int32(0)
+		for i := int(c.bits) - 1; i >= 0; i-- {
+			bit := (c.code >> uint(i)) & 1
+			if huffmanTree[node].next[bit] == 0 {
+				huffmanTree = append(huffmanTree, huffmanNode{sym: -1})
+				huffmanTree[node].next[bit] = int32(len(huffmanTree) - 1)
+			}
+			node = huffmanTree[node].next[bit]
+		}
+		huffmanTree[node].sym = int32(sym)
+	}
+}
+
+func huffmanDecode(data []byte) (string, error) {
+	var sb strings.Builder
+	node := int32(0)
+	// 自上一个符号以来消耗的比特数, 以及这些比特是否全为1
+	pad, ones := 0, true
+	for _, b := range data {
+		for i := 7; i >= 0; i-- {
+			bit := (b >> uint(i)) & 1
+			node = huffmanTree[node].next[bit]
+			if node == 0 {
+				return "", ErrHpackDecode
+			}
+			pad++
+			ones = ones && bit == 1
+			if sym := huffmanTree[node].sym; sym >= 0 {
+				if sym == huffmanEOS {
+					return "", ErrHpackDecode
+				}
+				sb.WriteByte(byte(sym))
+				node, pad, ones = 0, 0, true
+			}
+		}
+	}
+	// 末尾的填充必须是不超过7位的EOS前缀(全1)
+	if pad > 7 || !ones {
+		return "", ErrHpackDecode
+	}
+	return sb.String(), nil
+}
+
+func huffmanEncodedLen(s string) uint64 {
+	var bits uint64
+	for i := 0; i < len(s); i++ {
+		bits += uint64(huffmanCodes[s[i]].bits)
+	}
+	return (bits + 7) / 8
+}
+
+func appendHuffman(dst []byte, s string) []byte {
+	var acc uint64
+	var n uint
+	for i := 0; i < len(s); i++ {
+		c := huffmanCodes[s[i]]
+		acc = acc<<c.bits | uint64(c.code)
+		n += uint(c.bits)
+		for n >= 8 {
+			n -= 8
+			dst = append(dst, byte(acc>>n))
+		}
+	}
+	if n > 0 {
+		// 以EOS的高位(全1)填充
+		dst = append(dst, byte(acc<<(8-n))|byte(0xff>>n))
+	}
+	return dst
+}
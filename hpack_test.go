@@ -0,0 +1,94 @@
+package HiHttp
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+// 测试 RFC 7541 附录C.4中使用Huffman编码的请求示例, 三个报文头块共享同一个动态表
+func TestHpackDecoder_RFC7541_Requests(t *testing.T) {
+	dec := newHpackDecoder(4096)
+	cases := []struct {
+		block string
+		want  []hpackField
+		size  uint32
+	}{
+		{"828684418cf1e3c2e5f23a6ba0ab90f4ff", []hpackField{
+			{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"},
+		}, 57},
+		{"828684be5886a8eb10649cbf", []hpackField{
+			{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"},
+			{"cache-control", "no-cache"},
+		}, 110},
+		{"828785bf408825a849e95ba97d7f8925a849e95bb8e8b4bf", []hpackField{
+			{":method", "GET"}, {":scheme", "https"}, {":path", "/index.html"}, {":authority", "www.example.com"},
+			{"custom-key", "custom-value"},
+		}, 164},
+	}
+	for i, c := range cases {
+		block, _ := hex.DecodeString(c.block)
+		fields, err := dec.decode(block)
+		if err != nil || !reflect.DeepEqual(fields, c.want) {
+			t.Fatalf("block %d: %v, %v", i, fields, err)
+		}
+		if dec.size != c.size {
+			t.Fatalf("block %d: dynamic table size %d, want %d", i, dec.size, c.size)
+		}
+	}
+}
+
+// 测试动态表淘汰以及非法的报文头块
+func TestHpackDecoder_Eviction(t *testing.T) {
+	dec := newHpackDecoder(100)
+	// 两个大小为 32+10+10 的字段, 第二个插入时第一个被淘汰
+	block := appendHpackInt(nil, 6, 0x40, 0)
+	block = appendHpackString(block, "aaaaaaaaaa")
+	block = appendHpackString(block, "1111111111")
+	block = appendHpackInt(block, 6, 0x40, 0)
+	block = appendHpackString(block, "bbbbbbbbbb")
+	block = appendHpackString(block, "2222222222")
+	// 引用动态表中唯一的条目
+	block = appendHpackInt(block, 7, 0x80, 62)
+	fields, err := dec.decode(block)
+	if err != nil || len(fields) != 3 || fields[2] != (hpackField{"bbbbbbbbbb", "2222222222"}) {
+		t.Fatalf("got %v, %v", fields, err)
+	}
+	if len(dec.dynamic) != 1 {
+		t.Fatalf("dynamic table has %d entries, want 1", len(dec.dynamic))
+	}
+	// 填充超过7位的Huffman字符串, 以及长度超出数据的字符串
+	for _, bad := range []string{"4181ff", "418561"} {
+		block, _ := hex.DecodeString(bad)
+		if _, err := dec.decode(block); err != ErrHpackDecode {
+			t.Fatalf("%s: expected ErrHpackDecode, got %v", bad, err)
+		}
+	}
+	// 动态表大小更新超过通告的上限
+	if _, err := dec.decode(appendHpackInt(nil, 5, 0x20, 4096)); err != ErrHpackDecode {
+		t.Fatalf("expected ErrHpackDecode, got %v", err)
+	}
+	// 引用不存在的索引
+	if _, err := dec.decode(appendHpackInt(nil, 7, 0x80, 70)); err != ErrHpackDecode {
+		t.Fatalf("expected ErrHpackDecode, got %v", err)
+	}
+}
+
+// 测试编码结果可以被正确解码
+func TestHpack_RoundTrip(t *testing.T) {
+	if got := hex.EncodeToString(appendHuffman(nil, "www.example.com")); got != "f1e3c2e5f23a6ba0ab90f4ff" {
+		t.Fatalf("huffman encoding: %s", got)
+	}
+	fields := []hpackField{
+		{":method", "POST"}, {":path", "/a?b=c"}, {"content-type", "application/json"},
+		{"authorization", "Bearer token"}, {"x-custom", "你好, \x00\xff"}, {"accept", ""},
+	}
+	var block []byte
+	for _, f := range fields {
+		block = appendHpackField(block, f.name, f.value)
+	}
+	got, err := newHpackDecoder(4096).decode(block)
+	if err != nil || !reflect.DeepEqual(got, fields) {
+		t.Fatalf("got %v, %v", got, err)
+	}
+}
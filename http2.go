@@ -0,0 +1,888 @@
+package HiHttp
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTP/2 传输(RFC 9113): 帧层、HPACK、流多路复用与流量控制;
+// HTTPS下通过ALPN协商h2, 服务端不支持时回退到HTTP/1.1; HTTP下以h2c(prior knowledge)直接通信
+
+// Errors
+var (
+	ErrHttp2Protocol = errors.New("http2 protocol error")
+	ErrStreamReset   = errors.New("http2 stream reset by peer")
+	// 服务端未处理该流(GOAWAY 或 REFUSED_STREAM), 可以安全地在新连接上重发
+	errStreamRefused = errors.New("http2 stream refused")
+)
+
+// 客户端连接前言
+const h2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// 帧类型
+const (
+	h2FrameData         = 0x0
+	h2FrameHeaders      = 0x1
+	h2FramePriority     = 0x2
+	h2FrameRSTStream    = 0x3
+	h2FrameSettings     = 0x4
+	h2FramePushPromise  = 0x5
+	h2FramePing         = 0x6
+	h2FrameGoAway       = 0x7
+	h2FrameWindowUpdate = 0x8
+	h2FrameContinuation = 0x9
+)
+
+// 帧标志
+const (
+	h2FlagEndStream  = 0x1
+	h2FlagAck        = 0x1
+	h2FlagEndHeaders = 0x4
+	h2FlagPadded     = 0x8
+	h2FlagPriority   = 0x20
+)
+
+// SETTINGS参数
+const (
+	h2SettingHeaderTableSize      = 0x1
+	h2SettingEnablePush           = 0x2
+	h2SettingMaxConcurrentStreams = 0x3
+	h2SettingInitialWindowSize    = 0x4
+	h2SettingMaxFrameSize         = 0x5
+	h2SettingMaxHeaderListSize    = 0x6
+)
+
+// 错误码
+const (
+	h2ErrNoError       = 0x0
+	h2ErrProtocol      = 0x1
+	h2ErrFlowControl   = 0x3
+	h2ErrFrameSize     = 0x6
+	h2ErrRefusedStream = 0x7
+	h2ErrCancel        = 0x8
+	h2ErrCompression   = 0x9
+)
+
+// 默认配置
+const (
+	h2DefaultWindow   = 65535     // 协议规定的初始窗口
+	h2DefaultMaxFrame = 16384     // 协议规定的默认最大帧长度
+	h2RecvWindow      = 1 << 20   // 本端的流与连接接收窗口
+	h2MaxHeaderBytes  = 1 << 20   // 本端接受的最大报文头块
+	h2HeaderTableSize = 4096      // 本端HPACK动态表大小
+	h2MaxStreamID     = 1<<31 - 1 // 流ID耗尽后需要新建连接
+	h2PingIdle        = 15 * time.Second
+)
+
+/// -------------------------------- 传输 --------------------------------
+
+type h2Transport struct {
+	h     *origin
+	lock  sync.Mutex
+	conn  *h2Conn
+	http1 *pipeline // 服务端未协商h2时回退使用的HTTP/1.1传输
+}
+
+func newH2Transport(h *origin) *h2Transport {
+	return &h2Transport{h: h}
+}
+
+func (t *h2Transport) do(req *Request) Response {
+	for attempt := 0; ; attempt++ {
+		cc, http1, err := t.getConn(req)
+		if err != nil {
+			return Response{Error: err}
+		}
+		if http1 != nil {
+			if req.Version == HTTP2 {
+				req.Version = HTTP11
+			}
+			return http1.do(req)
+		}
+		res := cc.roundTrip(req)
+		// 未被服务端处理的流可以在新连接上重发
+		if res.Error == errStreamRefused && attempt < pipelineMaxAttempts {
+			t.h.printLog("\n△ http2 stream refused, retrying on a new connection")
+			continue
+		}
+		if res.Error == errStreamRefused {
+			res.Error = ErrConnClosed
+		}
+		return res
+	}
+}
+
+// 获取可用的HTTP/2连接, 若服务端只支持HTTP/1.1则返回回退的传输
+func (t *h2Transport) getConn(req *Request) (*h2Conn, *pipeline, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for {
+		if t.http1 != nil {
+			return nil, t.http1, nil
+		}
+		cc := t.conn
+		if cc == nil {
+			break
+		}
+		if !cc.usable() {
+			// 不可用的连接(例: 收到GOAWAY)在剩余的流结束后关闭
+			cc.retire()
+			t.conn = nil
+			break
+		}
+		if !cc.idleSince(h2PingIdle) {
+			return cc, nil, nil
+		}
+		// 空闲较久的连接先以PING确认可用, 等待确认时不持有锁, 以免阻塞同一源的其它请求
+		t.lock.Unlock()
+		err := cc.ping(req.ReadTimeout)
+		t.lock.Lock()
+		if t.conn != cc {
+			// 等待期间连接已被替换或关闭, 重新选择
+			continue
+		}
+		if err == nil {
+			return cc, nil, nil
+		}
+		cc.close()
+		t.conn = nil
+	}
+	conn, err := t.h.dialConn(alpnHTTP2, req)
+	if err != nil {
+		return nil, nil, connectError(err)
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok && tlsConn.ConnectionState().NegotiatedProtocol != "h2" {
+		t.h.printLog("h2 not negotiated, falling back to", HTTP11)
+		depth := int(t.h.options.Pipeline)
+		if depth < 1 {
+			depth = 1
+		}
+		t.http1 = newPipeline(t.h, depth)
+		t.http1.adopt(conn)
+		return nil, t.http1, nil
+	}
+	cc, err := newH2Conn(t.h, conn, req.WriteTimeout)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	t.conn = cc
+	return cc, nil, nil
+}
+
+func (t *h2Transport) close() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.conn != nil {
+		t.conn.close()
+		t.conn = nil
+	}
+	if t.http1 != nil {
+		t.http1.close()
+	}
+}
+
+/// -------------------------------- 连接 --------------------------------
+
+type h2Stream struct {
+	id         uint32
+	sendWindow int64 // 发送窗口
+	res        Response
+	body       []byte
+	gotHeaders bool  // 已收到最终响应头(非1xx)
+	finished   bool  // 已收到 END_STREAM 或被重置
+	err        error // 流被重置或连接失效
+}
+
+type h2Conn struct {
+	h    *origin
+	conn net.Conn
+
+	wlock sync.Mutex // 保证帧完整写出, 并保证流ID按顺序使用
+	bw    *bufio.Writer
+
+	lock          sync.Mutex
+	cond          *sync.Cond
+	streams       map[uint32]*h2Stream
+	active        int    // 已占用的并发流数量(含正在发送报文头的流)
+	nextID        uint32 // 下一个流ID, 客户端使用奇数
+	maxStreams    int    // 服务端 SETTINGS_MAX_CONCURRENT_STREAMS
+	sendWindow    int64  // 连接级发送窗口
+	initialWindow int64  // 服务端 SETTINGS_INITIAL_WINDOW_SIZE
+	maxFrame      uint32 // 服务端 SETTINGS_MAX_FRAME_SIZE
+	goAway        bool   // 已收到GOAWAY或连接已被替换, 不能再创建新的流
+	retired       bool   // 已被新连接替换, 最后一个流结束后关闭
+	err           error  // 连接已失效的原因
+	lastRead      time.Time
+	pings         map[[8]byte]chan struct{}
+	tls           *TLSInfo // 连接的TLS信息, h2c时为nil
+}
+
+// 在已建立的连接上发送连接前言与SETTINGS, 并启动读取协程
+func newH2Conn(h *origin, conn net.Conn, writeTimeout time.Duration) (*h2Conn, error) {
+	cc := &h2Conn{
+		h:             h,
+		conn:          conn,
+		bw:            bufio.NewWriterSize(conn, 4<<10),
+		streams:       map[uint32]*h2Stream{},
+		nextID:        1,
+		maxStreams:    1000,
+		sendWindow:    h2DefaultWindow,
+		initialWindow: h2DefaultWindow,
+		maxFrame:      h2DefaultMaxFrame,
+		lastRead:      time.Now(),
+		pings:         map[[8]byte]chan struct{}{},
+		tls:           connTLSInfo(conn),
+	}
+	cc.cond = sync.NewCond(&cc.lock)
+	settings := appendH2Setting(nil, h2SettingEnablePush, 0)
+	settings = appendH2Setting(settings, h2SettingInitialWindowSize, h2RecvWindow)
+	settings = appendH2Setting(settings, h2SettingMaxHeaderListSize, h2MaxHeaderBytes)
+	_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, _ = cc.bw.WriteString(h2Preface)
+	cc.writeFrame(h2FrameSettings, 0, 0, settings)
+	cc.writeFrame(h2FrameWindowUpdate, 0, 0, appendUint32(nil, h2RecvWindow-h2DefaultWindow))
+	if err := cc.bw.Flush(); err != nil {
+		return nil, err
+	}
+	go cc.readLoop(bufio.NewReaderSize(conn, 16<<10))
+	return cc, nil
+}
+
+func appendH2Setting(dst []byte, id uint16, val uint32) []byte {
+	return appendUint32(appendUint16(dst, id), val)
+}
+
+// 写入一个帧到缓冲区, 调用时需持有写锁
+func (cc *h2Conn) writeFrame(typ, flags uint8, stream uint32, payload []byte) {
+	var head [9]byte
+	head[0], head[1], head[2] = byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload))
+	head[3], head[4] = typ, flags
+	binary.BigEndian.PutUint32(head[5:], stream&h2MaxStreamID)
+	_, _ = cc.bw.Write(head[:])
+	_, _ = cc.bw.Write(payload)
+}
+
+// 写出若干帧, 失败时连接失效
+func (cc *h2Conn) write(timeout time.Duration, frames func()) error {
+	cc.wlock.Lock()
+	defer cc.wlock.Unlock()
+	_ = cc.conn.SetWriteDeadline(time.Now().Add(timeout))
+	frames()
+	if err := cc.bw.Flush(); err != nil {
+		cc.fail(ErrConnClosed)
+		return err
+	}
+	return nil
+}
+
+// 连接是否可以创建新的流
+func (cc *h2Conn) usable() bool {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	return cc.err == nil && !cc.goAway && cc.nextID < h2MaxStreamID
+}
+
+// 连接上没有进行中的流且超过d没有收到数据
+func (cc *h2Conn) idleSince(d time.Duration) bool {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	return cc.active == 0 && time.Since(cc.lastRead) > d
+}
+
+// 发送PING并等待确认, 用于检查连接是否可用
+func (cc *h2Conn) ping(timeout time.Duration) error {
+	var data [8]byte
+	_, _ = rand.Read(data[:])
+	ack := make(chan struct{})
+	cc.lock.Lock()
+	cc.pings[data] = ack
+	cc.lock.Unlock()
+	defer func() {
+		cc.lock.Lock()
+		delete(cc.pings, data)
+		cc.lock.Unlock()
+	}()
+	if err := cc.write(timeout, func() { cc.writeFrame(h2FramePing, 0, 0, data[:]) }); err != nil {
+		return err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ack:
+		return nil
+	case <-timer.C:
+		return ErrRequestTimeout
+	}
+}
+
+// 发送GOAWAY并关闭连接
+func (cc *h2Conn) close() {
+	cc.connError(h2ErrNoError, ErrConnClosed)
+}
+
+// 不再使用连接: 没有进行中的流时立即关闭, 否则在最后一个流结束时关闭
+func (cc *h2Conn) retire() {
+	cc.lock.Lock()
+	cc.goAway, cc.retired = true, true
+	idle := cc.active == 0 && cc.err == nil
+	cc.lock.Unlock()
+	if idle {
+		cc.close()
+	}
+}
+
+// 连接失效, 结束所有进行中的流
+func (cc *h2Conn) fail(err error) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	if cc.err != nil {
+		return
+	}
+	cc.err = err
+	_ = cc.conn.Close()
+	for _, s := range cc.streams {
+		cc.endStream(s, err)
+	}
+	cc.cond.Broadcast()
+}
+
+// 结束一个流并释放并发占用, 调用时需持有锁
+func (cc *h2Conn) endStream(s *h2Stream, err error) {
+	if s.finished {
+		return
+	}
+	s.finished, s.err = true, err
+	delete(cc.streams, s.id)
+	cc.active--
+	cc.cond.Broadcast()
+	if cc.retired && cc.active == 0 && cc.err == nil {
+		// 关闭时需要获取锁
+		go cc.close()
+	}
+}
+
+// 在连接上发送一个请求并等待响应
+func (cc *h2Conn) roundTrip(req *Request) Response {
+	body, err := req.readBody()
+	if err != nil {
+		return Response{Error: err}
+	}
+	deadline, timeoutErr := req.attemptDeadline()
+	// 超时或取消时唤醒等待中的协程
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-cc.h.ctx.Done():
+		case <-stop:
+			return
+		}
+		cc.lock.Lock()
+		cc.cond.Broadcast()
+		cc.lock.Unlock()
+	}()
+	expired := func() error {
+		if cc.h.ctx.Err() != nil {
+			return ErrRequestCanceled
+		}
+		if !time.Now().Before(deadline) {
+			return timeoutErr
+		}
+		return nil
+	}
+
+	// 等待可用的并发流
+	cc.lock.Lock()
+	for cc.err == nil && !cc.goAway && cc.active >= cc.maxStreams && expired() == nil {
+		cc.cond.Wait()
+	}
+	if err = expired(); err == nil && (cc.err != nil || cc.goAway) {
+		err = errStreamRefused
+	}
+	if err != nil {
+		cc.lock.Unlock()
+		return Response{Status: BAD_REQUEST, Error: err}
+	}
+	cc.active++
+	cc.lock.Unlock()
+
+	s := &h2Stream{}
+	block := cc.encodeHeaders(req, body)
+	refused := false
+	err = cc.write(req.WriteTimeout, func() {
+		// 流ID必须按报文头写出的顺序递增
+		cc.lock.Lock()
+		if refused = cc.err != nil || cc.goAway; refused {
+			cc.active--
+			cc.lock.Unlock()
+			return
+		}
+		s.id, s.sendWindow = cc.nextID, cc.initialWindow
+		cc.nextID += 2
+		cc.streams[s.id] = s
+		maxFrame := int(cc.maxFrame)
+		cc.lock.Unlock()
+		flags := uint8(If(len(body) == 0, h2FlagEndStream, 0).(int))
+		typ := uint8(h2FrameHeaders)
+		for {
+			chunk := block
+			if len(chunk) > maxFrame {
+				chunk = chunk[:maxFrame]
+			}
+			block = block[len(chunk):]
+			if len(block) == 0 {
+				flags |= h2FlagEndHeaders
+			}
+			cc.writeFrame(typ, flags, s.id, chunk)
+			if len(block) == 0 {
+				break
+			}
+			typ, flags = h2FrameContinuation, 0
+		}
+	})
+	if refused {
+		return Response{Status: BAD_REQUEST, Error: errStreamRefused}
+	}
+	if err != nil {
+		return Response{Status: BAD_REQUEST, Error: ErrConnClosed}
+	}
+	if err = cc.sendBody(s, body, req.WriteTimeout, expired); err != nil {
+		return Response{Status: BAD_REQUEST, Error: err}
+	}
+
+	// 等待响应
+	cc.lock.Lock()
+	for !s.finished && expired() == nil {
+		cc.cond.Wait()
+	}
+	if !s.finished {
+		err = expired()
+		cc.lock.Unlock()
+		cc.resetStream(s, h2ErrCancel)
+		return Response{Status: BAD_REQUEST, Error: err}
+	}
+	cc.lock.Unlock()
+	if s.err != nil {
+		return Response{Status: BAD_REQUEST, Error: s.err}
+	}
+	res := s.res
+	res.Body = string(s.body)
+	res.ContentLength = uint64(len(s.body))
+	return res
+}
+
+// 按流量控制窗口分帧发送请求体
+func (cc *h2Conn) sendBody(s *h2Stream, body []byte, timeout time.Duration, expired func() error) error {
+	for len(body) > 0 {
+		cc.lock.Lock()
+		for !s.finished && (cc.sendWindow <= 0 || s.sendWindow <= 0) && expired() == nil {
+			cc.cond.Wait()
+		}
+		if s.finished {
+			// 服务端已提前结束响应(或流被重置), 不再发送剩余的请求体
+			cc.lock.Unlock()
+			if s.err == nil {
+				cc.resetStream(s, h2ErrNoError)
+			}
+			return s.err
+		}
+		if err := expired(); err != nil {
+			cc.lock.Unlock()
+			cc.resetStream(s, h2ErrCancel)
+			return err
+		}
+		n := int64(len(body))
+		for _, limit := range []int64{cc.sendWindow, s.sendWindow, int64(cc.maxFrame)} {
+			if limit < n {
+				n = limit
+			}
+		}
+		cc.sendWindow -= n
+		s.sendWindow -= n
+		cc.lock.Unlock()
+		chunk := body[:n]
+		body = body[n:]
+		flags := uint8(If(len(body) == 0, h2FlagEndStream, 0).(int))
+		if err := cc.write(timeout, func() { cc.writeFrame(h2FrameData, flags, s.id, chunk) }); err != nil {
+			return ErrConnClosed
+		}
+	}
+	return nil
+}
+
+// 取消一个流
+func (cc *h2Conn) resetStream(s *h2Stream, code uint32) {
+	cc.lock.Lock()
+	cc.endStream(s, ErrRequestCanceled)
+	cc.lock.Unlock()
+	_ = cc.write(time.Second, func() { cc.writeFrame(h2FrameRSTStream, 0, s.id, appendUint32(nil, code)) })
+}
+
+// 编码请求的报文头
+func (cc *h2Conn) encodeHeaders(req *Request, body []byte) []byte {
+	var block []byte
+	for _, f := range requestFields(req, cc.h.base, body) {
+		block = appendHpackField(block, f.name, f.value)
+	}
+	return block
+}
+
+// HTTP/2与HTTP/3的请求报文头: 伪头部在前, 连接相关的Header不允许出现
+func requestFields(req *Request, base *baseURL, body []byte) []hpackField {
+	authority := req.Headers.Get("Host")
+	if authority == "" {
+		authority = base.header
+	}
+	fields := []hpackField{
+		{":method", req.Method},
+		{":scheme", base.scheme},
+		{":authority", authority},
+		{":path", req.Url},
+	}
+	for key, val := range req.Headers {
+		key = strings.ToLower(key)
+		switch key {
+		case "host", "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade", "content-length":
+			continue
+		case "te":
+			if !strings.EqualFold(val, "trailers") {
+				continue
+			}
+		}
+		fields = append(fields, hpackField{key, val})
+	}
+	if req.Body != nil {
+		fields = append(fields, hpackField{"content-length", strconv.Itoa(len(body))})
+	}
+	return fields
+}
+
+func (cc *h2Conn) readLoop(br *bufio.Reader) {
+	dec := newHpackDecoder(h2HeaderTableSize)
+	var head [9]byte
+	// 正在接收的报文头块(HEADERS + CONTINUATION)
+	var headerStream uint32
+	var headerBlock []byte
+	var headerEnd bool
+	for {
+		if _, err := io.ReadFull(br, head[:]); err != nil {
+			cc.h.printLog("http2 read err:", err)
+			cc.fail(ErrConnClosed)
+			return
+		}
+		length := uint32(head[0])<<16 | uint32(head[1])<<8 | uint32(head[2])
+		typ, flags := head[3], head[4]
+		stream := binary.BigEndian.Uint32(head[5:]) & h2MaxStreamID
+		if length > h2DefaultMaxFrame {
+			cc.connError(h2ErrFrameSize, h2ProtocolError(h2ErrFrameSize))
+			return
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			cc.fail(ErrConnClosed)
+			return
+		}
+		cc.lock.Lock()
+		cc.lastRead = time.Now()
+		cc.lock.Unlock()
+		// 报文头块必须连续, 中间不能插入其他帧
+		if headerStream != 0 && (typ != h2FrameContinuation || stream != headerStream) {
+			cc.connError(h2ErrProtocol, h2ProtocolError(h2ErrProtocol))
+			return
+		}
+
+		var err error
+		switch typ {
+		case h2FrameData:
+			err = cc.onData(stream, flags, payload)
+		case h2FrameHeaders, h2FrameContinuation:
+			if typ == h2FrameHeaders {
+				if payload, err = trimH2Padding(flags, payload); err == nil && flags&h2FlagPriority != 0 {
+					if len(payload) < 5 {
+						err = ErrHttp2Protocol
+					} else {
+						payload = payload[5:]
+					}
+				}
+				headerEnd = flags&h2FlagEndStream != 0
+			} else if headerStream == 0 {
+				err = ErrHttp2Protocol
+			}
+			if err != nil {
+				break
+			}
+			headerStream = stream
+			headerBlock = append(headerBlock, payload...)
+			if len(headerBlock) > h2MaxHeaderBytes {
+				err = ErrHeaderTooLarge
+				break
+			}
+			if flags&h2FlagEndHeaders != 0 {
+				// 无论流是否还存在都需要解码, 以保持HPACK动态表同步
+				fields, decErr := dec.decode(headerBlock)
+				if decErr != nil {
+					cc.connError(h2ErrCompression, h2ProtocolError(h2ErrCompression))
+					return
+				}
+				cc.onHeaders(headerStream, fields, headerEnd)
+				headerStream, headerBlock = 0, nil
+			}
+		case h2FrameRSTStream:
+			if len(payload) != 4 {
+				err = ErrHttp2Protocol
+				break
+			}
+			cc.onReset(stream, binary.BigEndian.Uint32(payload))
+		case h2FrameSettings:
+			err = cc.onSettings(flags, payload)
+		case h2FramePushPromise:
+			// 已通过 SETTINGS_ENABLE_PUSH=0 禁用服务端推送
+			err = ErrHttp2Protocol
+		case h2FramePing:
+			err = cc.onPing(flags, payload)
+		case h2FrameGoAway:
+			if len(payload) < 8 {
+				err = ErrHttp2Protocol
+				break
+			}
+			cc.onGoAway(binary.BigEndian.Uint32(payload)&h2MaxStreamID, binary.BigEndian.Uint32(payload[4:]))
+		case h2FrameWindowUpdate:
+			err = cc.onWindowUpdate(stream, payload)
+		}
+		// PRIORITY 与未知类型的帧直接忽略
+		if err != nil {
+			cc.h.printLog("http2 frame err:", err)
+			if err == errFlowControl {
+				cc.connError(h2ErrFlowControl, h2ProtocolError(h2ErrFlowControl))
+			} else {
+				cc.connError(h2ErrProtocol, h2ProtocolError(h2ErrProtocol))
+			}
+			return
+		}
+	}
+}
+
+var errFlowControl = errors.New("http2 flow control error")
+
+// 发送GOAWAY后关闭连接, 客户端不接受服务端发起的流, 因此 last stream ID 为0
+func (cc *h2Conn) connError(code uint32, err error) {
+	_ = cc.write(time.Second, func() {
+		cc.writeFrame(h2FrameGoAway, 0, 0, appendUint32(appendUint32(nil, 0), code))
+	})
+	cc.fail(err)
+}
+
+func h2ProtocolError(code uint32) error {
+	return fmt.Errorf("%w: code %d", ErrHttp2Protocol, code)
+}
+
+func trimH2Padding(flags uint8, payload []byte) ([]byte, error) {
+	if flags&h2FlagPadded == 0 {
+		return payload, nil
+	}
+	if len(payload) == 0 || int(payload[0]) >= len(payload) {
+		return nil, ErrHttp2Protocol
+	}
+	return payload[1 : len(payload)-int(payload[0])], nil
+}
+
+func (cc *h2Conn) onData(stream uint32, flags uint8, payload []byte) error {
+	if stream == 0 {
+		return ErrHttp2Protocol
+	}
+	// 填充也计入流量控制
+	size := uint32(len(payload))
+	data, err := trimH2Padding(flags, payload)
+	if err != nil {
+		return err
+	}
+	cc.lock.Lock()
+	s := cc.streams[stream]
+	open := s != nil && s.gotHeaders
+	if open {
+		s.body = append(s.body, data...)
+		if flags&h2FlagEndStream != 0 {
+			cc.endStream(s, nil)
+			open = false
+		}
+	}
+	cc.lock.Unlock()
+	if size > 0 {
+		// 数据已被读取, 立即归还接收窗口
+		_ = cc.write(time.Second, func() {
+			cc.writeFrame(h2FrameWindowUpdate, 0, 0, appendUint32(nil, size))
+			if open {
+				cc.writeFrame(h2FrameWindowUpdate, 0, stream, appendUint32(nil, size))
+			}
+		})
+	}
+	return nil
+}
+
+func (cc *h2Conn) onHeaders(stream uint32, fields []hpackField, endStream bool) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	s := cc.streams[stream]
+	if s == nil {
+		return
+	}
+	if s.gotHeaders {
+		// trailer
+		for _, f := range fields {
+			s.res.Headers[f.name] = f.value
+		}
+	} else {
+		res := responseFromFields(HTTP2, fields)
+		res.TLS = cc.tls
+		if res.Status < 200 && res.Status >= 100 {
+			// 1xx 信息响应, 继续等待最终响应
+			return
+		}
+		if res.Status == 0 {
+			cc.endStream(s, fmt.Errorf("%w: missing :status", ErrHttp2Protocol))
+			return
+		}
+		s.res, s.gotHeaders = res, true
+	}
+	if endStream {
+		cc.endStream(s, nil)
+	}
+}
+
+// 由HTTP/2或HTTP/3的响应报文头构造响应, 同名字段以", "合并
+func responseFromFields(version string, fields []hpackField) Response {
+	res := Response{Version: version, Headers: make(Headers, len(fields))}
+	for _, f := range fields {
+		if f.name == ":status" {
+			res.Status, _ = strconv.Atoi(f.value)
+			continue
+		}
+		if strings.HasPrefix(f.name, ":") {
+			continue
+		}
+		if old, ok := res.Headers[f.name]; ok {
+			f.value = old + ", " + f.value
+		}
+		res.Headers[f.name] = f.value
+	}
+	return res
+}
+
+func (cc *h2Conn) onReset(stream uint32, code uint32) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	s := cc.streams[stream]
+	if s == nil {
+		return
+	}
+	if code == h2ErrRefusedStream {
+		cc.endStream(s, errStreamRefused)
+		return
+	}
+	cc.endStream(s, fmt.Errorf("%w: code %d", ErrStreamReset, code))
+}
+
+func (cc *h2Conn) onSettings(flags uint8, payload []byte) error {
+	if flags&h2FlagAck != 0 {
+		return nil
+	}
+	if len(payload)%6 != 0 {
+		return ErrHttp2Protocol
+	}
+	cc.lock.Lock()
+	for ; len(payload) > 0; payload = payload[6:] {
+		id, val := binary.BigEndian.Uint16(payload), binary.BigEndian.Uint32(payload[2:])
+		switch id {
+		case h2SettingMaxConcurrentStreams:
+			cc.maxStreams = int(val)
+		case h2SettingInitialWindowSize:
+			if val > h2MaxStreamID {
+				cc.lock.Unlock()
+				return errFlowControl
+			}
+			// 调整所有进行中的流的发送窗口
+			delta := int64(val) - cc.initialWindow
+			for _, s := range cc.streams {
+				s.sendWindow += delta
+			}
+			cc.initialWindow = int64(val)
+		case h2SettingMaxFrameSize:
+			if val < h2DefaultMaxFrame || val > 1<<24-1 {
+				cc.lock.Unlock()
+				return ErrHttp2Protocol
+			}
+			cc.maxFrame = val
+		}
+		// 本端编码不使用动态表, 无需处理 HEADER_TABLE_SIZE
+	}
+	cc.cond.Broadcast()
+	cc.lock.Unlock()
+	return cc.write(time.Second, func() { cc.writeFrame(h2FrameSettings, h2FlagAck, 0, nil) })
+}
+
+func (cc *h2Conn) onPing(flags uint8, payload []byte) error {
+	if len(payload) != 8 {
+		return ErrHttp2Protocol
+	}
+	if flags&h2FlagAck != 0 {
+		var data [8]byte
+		copy(data[:], payload)
+		cc.lock.Lock()
+		if ack, ok := cc.pings[data]; ok {
+			close(ack)
+			delete(cc.pings, data)
+		}
+		cc.lock.Unlock()
+		return nil
+	}
+	return cc.write(time.Second, func() { cc.writeFrame(h2FramePing, h2FlagAck, 0, payload) })
+}
+
+// 服务端不会处理ID大于lastID的流, 这些流可以安全地重发
+func (cc *h2Conn) onGoAway(lastID, code uint32) {
+	cc.h.printLog("http2 goaway, last stream:", lastID, "code:", code)
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	cc.goAway = true
+	for id, s := range cc.streams {
+		if id > lastID {
+			cc.endStream(s, errStreamRefused)
+		}
+	}
+	cc.cond.Broadcast()
+}
+
+func (cc *h2Conn) onWindowUpdate(stream uint32, payload []byte) error {
+	if len(payload) != 4 {
+		return ErrHttp2Protocol
+	}
+	incr := int64(binary.BigEndian.Uint32(payload) & h2MaxStreamID)
+	if incr == 0 {
+		return ErrHttp2Protocol
+	}
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	if stream == 0 {
+		if cc.sendWindow += incr; cc.sendWindow > h2MaxStreamID {
+			return errFlowControl
+		}
+	} else if s := cc.streams[stream]; s != nil {
+		s.sendWindow += incr
+	}
+	cc.cond.Broadcast()
+	return nil
+}
@@ -0,0 +1,249 @@
+package HiHttp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 启动HTTP/2测试服务, tls为false时只接受h2c(prior knowledge)
+func newH2Server(t *testing.T, handler http.Handler, useTLS, h2 bool) (*httptest.Server, *int32) {
+	var conns int32
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	if useTLS {
+		server.EnableHTTP2 = h2
+		server.StartTLS()
+	} else {
+		startH2C(t, server)
+	}
+	return server, &conns
+}
+
+func h2Client(t *testing.T, ctx context.Context, server *httptest.Server) HttpClient {
+	opts := Options{Version: HTTP2}
+	if server.Certificate() != nil {
+		pool := x509.NewCertPool()
+		pool.AddCert(server.Certificate())
+		opts.TLSConfig = &tls.Config{RootCAs: pool}
+	}
+	client, err := HiHttp(ctx, server.URL, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// 测试通过ALPN协商h2以及h2c, 并发请求在同一连接上多路复用
+func TestHttpClient_HTTP2_Multiplexing(t *testing.T) {
+	const concurrent = 5
+	for _, useTLS := range []bool{true, false} {
+		var arrived int32
+		all := make(chan struct{})
+		server, conns := newH2Server(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// 所有请求同时到达后才响应, 只有多路复用的请求才能全部完成
+			if atomic.AddInt32(&arrived, 1) == concurrent {
+				close(all)
+			}
+			select {
+			case <-all:
+			case <-time.After(3 * time.Second):
+			}
+			w.Header().Set("X-Path", r.URL.Path)
+			_, _ = fmt.Fprintf(w, "%s %s", r.Proto, r.URL.Path)
+		}), useTLS, true)
+		client := h2Client(t, context.Background(), server)
+		results, errs := getAll(client, []string{"/0", "/1", "/2", "/3", "/4"})
+		for i := range results {
+			if want := fmt.Sprintf("HTTP/2.0 /%d", i); errs[i] != nil || results[i] != want {
+				t.Fatalf("tls=%v request %d: %q, %v", useTLS, i, results[i], errs[i])
+			}
+		}
+		if n := atomic.LoadInt32(conns); n != 1 {
+			t.Fatalf("tls=%v: server accepted %d connections, want 1", useTLS, n)
+		}
+		if err := client.Head("/head"); err != nil {
+			t.Fatalf("tls=%v: head: %v", useTLS, err)
+		}
+		client.End()
+		server.Close()
+	}
+}
+
+// 测试超过初始窗口的请求体与响应体, 需要双向的流量控制
+func TestHttpClient_HTTP2_FlowControl(t *testing.T) {
+	const size = 3 << 20
+	server, _ := newH2Server(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(ioutil.Discard, r.Body)
+		w.Header().Set("X-Received", strconv.FormatInt(n, 10))
+		_, _ = w.Write([]byte(strings.Repeat("x", size)))
+	}), true, true)
+	defer server.Close()
+	client := h2Client(t, context.Background(), server)
+	defer client.End()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := client.Post("/upload", strings.NewReader(strings.Repeat("y", size)))
+			if err != nil || len(result) != size {
+				t.Errorf("got %d bytes, %v", len(result), err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// 测试服务端不支持h2时回退到HTTP/1.1
+func TestHttpClient_HTTP2_Fallback(t *testing.T) {
+	server, conns := newH2Server(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	}), true, false)
+	defer server.Close()
+	client := h2Client(t, context.Background(), server)
+	defer client.End()
+	for i := 0; i < 2; i++ {
+		if result, err := client.Get("/"); err != nil || result != HTTP11 {
+			t.Fatalf("request %d: %q, %v", i, result, err)
+		}
+	}
+	// 协商时建立的连接会被继续使用
+	if n := atomic.LoadInt32(conns); n != 1 {
+		t.Fatalf("server accepted %d connections, want 1", n)
+	}
+}
+
+// 测试超时与取消时以RST_STREAM中止流, 连接仍可继续使用
+func TestHttpClient_HTTP2_Cancel(t *testing.T) {
+	canceled := make(chan struct{}, 2)
+	server, conns := newH2Server(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-r.Context().Done():
+				canceled <- struct{}{}
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}), true, true)
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := h2Client(t, ctx, server)
+	defer client.End()
+
+	client.SetTimeout(200 * time.Millisecond)
+	if _, err := client.Get("/slow"); err != ErrRequestTimeout {
+		t.Fatalf("expected ErrRequestTimeout, got %v", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("server did not observe the stream reset")
+	}
+	client.SetTimeout(DefTimeout)
+	if result, err := client.Get("/fast"); err != nil || result != "ok" {
+		t.Fatalf("got %q, %v", result, err)
+	}
+	if n := atomic.LoadInt32(conns); n != 1 {
+		t.Fatalf("server accepted %d connections, want 1", n)
+	}
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := client.Get("/slow"); err != ErrRequestCanceled {
+		t.Fatalf("expected ErrRequestCanceled, got %v", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("server did not observe the stream reset")
+	}
+}
+
+// 测试Digest认证在HTTP/2上的质询重发, 以及PING检查连接
+func TestHttpClient_HTTP2_DigestAuth_Ping(t *testing.T) {
+	handler := &digestServer{algorithm: "SHA-256", realm: "test@hihttp", password: "secret"}
+	server, _ := newH2Server(t, handler, true, true)
+	defer server.Close()
+	client := h2Client(t, context.Background(), server)
+	defer client.End()
+	client.SetAuth(DigestAuth("admin", "secret"))
+	if result, err := client.Get("/dir"); err != nil || result != "hello admin 00000001" {
+		t.Fatalf("got %q, %v", result, err)
+	}
+	cc := client.(*hiHttp).home.transport.(*h2Transport).conn
+	if err := cc.ping(time.Second); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+}
+
+// 测试收到GOAWAY的连接被替换后, 在剩余的流结束时关闭
+func TestHttpClient_HTTP2_Retire(t *testing.T) {
+	server, conns := newH2Server(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), true, true)
+	defer server.Close()
+	client := h2Client(t, context.Background(), server)
+	defer client.End()
+	transport := client.(*hiHttp).home.transport.(*h2Transport)
+	closed := func(cc *h2Conn) bool {
+		cc.lock.Lock()
+		defer cc.lock.Unlock()
+		return cc.err != nil
+	}
+	for _, streams := range []int{0, 1} {
+		if _, err := client.Get("/"); err != nil {
+			t.Fatal(err)
+		}
+		// 模拟收到GOAWAY, streams 为1时还有一个进行中的流
+		old := transport.conn
+		s := &h2Stream{id: h2MaxStreamID}
+		old.lock.Lock()
+		old.goAway = true
+		if streams > 0 {
+			old.streams[s.id] = s
+			old.active++
+		}
+		old.lock.Unlock()
+		if _, err := client.Get("/"); err != nil || transport.conn == old {
+			t.Fatalf("expected a new connection, got %v", err)
+		}
+		if streams > 0 {
+			if closed(old) {
+				t.Fatal("connection closed before its last stream finished")
+			}
+			old.lock.Lock()
+			old.endStream(s, nil)
+			old.lock.Unlock()
+		}
+		deadline := time.Now().Add(time.Second)
+		for !closed(old) {
+			if time.Now().After(deadline) {
+				t.Fatalf("streams %d: replaced connection was not closed", streams)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if n := atomic.LoadInt32(conns); n != 3 {
+		t.Fatalf("server accepted %d connections, want 3", n)
+	}
+}
@@ -0,0 +1,589 @@
+package HiHttp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTP/3 (RFC 9114), 实验性: 通过 Options.Version = HTTP3 直接使用, 或开启 Options.AltSvc 后
+// 按响应的 Alt-Svc 头切换; QUIC握手失败(例: UDP被阻断)时回退到TCP上的HTTP/2或HTTP/1.1, 一段时间内不再尝试.
+// QPACK 只使用静态表(动态表容量为0), 因此不需要编码器/解码器流
+
+// Errors
+var (
+	ErrHttp3Protocol = errors.New("http3 protocol error")
+	ErrQpackDecode   = errors.New("qpack: invalid field section")
+)
+
+// 帧类型
+const (
+	h3FrameData     = 0x00
+	h3FrameHeaders  = 0x01
+	h3FrameSettings = 0x04
+	h3FrameGoAway   = 0x07
+)
+
+// 单向流类型
+const h3StreamControl = 0x00
+
+// 错误码
+const (
+	h3NoError          = 0x100
+	h3RequestCancelled = 0x10c
+)
+
+const (
+	h3MaxHeaderBytes = 1 << 20         // 报文头(及控制流中的帧)的最大长度
+	h3BrokenDuration = 5 * time.Minute // QUIC不可用后使用TCP的时长
+	h3AltSvcMaxAge   = 24 * time.Hour  // Alt-Svc 未指定 ma 时的有效期
+)
+
+/// -------------------------------- 传输 --------------------------------
+
+type h3Transport struct {
+	h           *origin
+	tcp         transport // TCP上的传输, QUIC不可用时使用
+	direct      bool      // 直接尝试HTTP/3, 否则只在 Alt-Svc 声明后使用
+	lock        sync.Mutex
+	conn        *h3Conn
+	alt         string        // Alt-Svc 声明的h3地址(host:port)
+	altExpires  time.Time     // Alt-Svc 的有效期
+	brokenUntil time.Time     // 在此之前不再尝试QUIC
+	dialing     chan struct{} // 正在建立的连接, 完成时关闭
+	closed      bool
+}
+
+func newH3Transport(h *origin, tcp transport, direct bool) *h3Transport {
+	return &h3Transport{h: h, tcp: tcp, direct: direct}
+}
+
+func (t *h3Transport) do(req *Request) Response {
+	if hc := t.getConn(req); hc != nil {
+		res := hc.roundTrip(req)
+		// 连接在请求发出前已失效, 改用TCP发送
+		if res.Error != errStreamRefused {
+			return res
+		}
+	}
+	if req.Version == HTTP3 {
+		req.Version = HTTP2
+	}
+	res := t.tcp.do(req)
+	if res.Headers != nil {
+		t.observeAltSvc(res.Headers.Get("Alt-Svc"))
+	}
+	return res
+}
+
+// 获取可用的HTTP/3连接, 不使用或无法使用QUIC时返回nil
+func (t *h3Transport) getConn(req *Request) *h3Conn {
+	t.lock.Lock()
+	for t.dialing != nil {
+		// 等待其它请求正在建立的连接, 等待时不持有锁
+		dialing := t.dialing
+		t.lock.Unlock()
+		<-dialing
+		t.lock.Lock()
+	}
+	if t.conn != nil && t.conn.usable() {
+		defer t.lock.Unlock()
+		return t.conn
+	}
+	t.conn = nil
+	now := time.Now()
+	var addr string
+	switch {
+	case t.closed || now.Before(t.brokenUntil):
+	case t.direct:
+		addr = t.h.host
+	case t.alt != "" && now.Before(t.altExpires):
+		addr = t.alt
+	}
+	if addr == "" {
+		t.lock.Unlock()
+		return nil
+	}
+	dialing := make(chan struct{})
+	t.dialing = dialing
+	t.lock.Unlock()
+
+	// 解析与握手时不持有锁, 以免阻塞同一源的其它操作
+	config := t.h.tlsConfig(alpnHTTP3)
+	config.NextProtos = alpnHTTP3
+	udpAddr, err := t.h.resolveAddr(req, addr)
+	var qc *quicConn
+	if err == nil {
+		deadline, _ := req.phaseDeadline(req.Timeouts.Connect, req.Timeout, ErrConnectTimeout)
+		qc, err = dialQuic(udpAddr, config, time.Until(deadline))
+	}
+	if err == nil && qc.connectionState().NegotiatedProtocol != "h3" {
+		qc.close(quicNoError, "")
+		err = fmt.Errorf("%w: h3 not negotiated", ErrQuicHandshake)
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.dialing = nil
+	close(dialing)
+	if err != nil {
+		t.h.printLog("quic err:", err, "- falling back to TCP")
+		t.brokenUntil = now.Add(h3BrokenDuration)
+		return nil
+	}
+	if t.closed {
+		// 建立连接期间传输已关闭
+		qc.close(h3NoError, "")
+		return nil
+	}
+	t.h.printLog("connected <-", addr, HTTP3)
+	t.conn = newH3Conn(t.h, qc)
+	return t.conn
+}
+
+// 记录TCP响应中 Alt-Svc 声明的h3地址
+func (t *h3Transport) observeAltSvc(value string) {
+	if value == "" || t.direct {
+		return
+	}
+	addr, maxAge, ok := parseAltSvc(value, t.h.base.hostname)
+	if !ok {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if addr != t.alt {
+		t.brokenUntil = time.Time{}
+	}
+	t.alt, t.altExpires = addr, time.Now().Add(maxAge)
+}
+
+func (t *h3Transport) close() {
+	t.lock.Lock()
+	t.closed = true
+	if t.conn != nil {
+		t.conn.qc.close(h3NoError, "")
+		t.conn = nil
+	}
+	t.lock.Unlock()
+	t.tcp.close()
+}
+
+// 解析 Alt-Svc (RFC 7838), 返回第一个h3条目的地址与有效期;
+// 例: `h3=":443"; ma=3600, h3-29=":443"`, 地址中省略主机时使用hostname; "clear" 返回空地址
+func parseAltSvc(value, hostname string) (addr string, maxAge time.Duration, ok bool) {
+	if strings.TrimSpace(value) == "clear" {
+		return "", 0, true
+	}
+	for _, entry := range strings.Split(value, ",") {
+		params := strings.Split(entry, ";")
+		kv := strings.SplitN(strings.TrimSpace(params[0]), "=", 2)
+		if len(kv) != 2 || kv[0] != "h3" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(strings.Trim(kv[1], `"`))
+		if err != nil {
+			continue
+		}
+		if host == "" {
+			host = hostname
+		}
+		maxAge = h3AltSvcMaxAge
+		for _, p := range params[1:] {
+			if kv := strings.SplitN(strings.TrimSpace(p), "=", 2); len(kv) == 2 && kv[0] == "ma" {
+				if sec, err := strconv.Atoi(strings.Trim(kv[1], `"`)); err == nil {
+					maxAge = time.Duration(sec) * time.Second
+				}
+			}
+		}
+		return net.JoinHostPort(host, port), maxAge, true
+	}
+	return "", 0, false
+}
+
+/// -------------------------------- 连接 --------------------------------
+
+type h3Conn struct {
+	h      *origin
+	qc     *quicConn
+	lock   sync.Mutex
+	goAway bool     // 服务端已发送GOAWAY, 不再发送新的请求
+	tls    *TLSInfo // QUIC握手的TLS信息
+}
+
+func newH3Conn(h *origin, qc *quicConn) *h3Conn {
+	hc := &h3Conn{h: h, qc: qc, tls: newTLSInfo(qc.tls.ConnectionState())}
+	// 控制流: 流类型与SETTINGS, 均使用默认值(QPACK动态表容量为0)
+	if control, err := qc.openStream(false); err == nil {
+		_, _ = control.Write(appendH3Frame([]byte{h3StreamControl}, h3FrameSettings, nil))
+	}
+	go hc.acceptStreams()
+	return hc
+}
+
+func (hc *h3Conn) usable() bool {
+	hc.lock.Lock()
+	goAway := hc.goAway
+	hc.lock.Unlock()
+	return !goAway && hc.qc.usable()
+}
+
+// 读取服务端打开的单向流: 处理控制流中的GOAWAY, 其余的流(QPACK、推送)直接丢弃
+func (hc *h3Conn) acceptStreams() {
+	for {
+		s, err := hc.qc.acceptStream(false)
+		if err != nil {
+			return
+		}
+		go func() {
+			br := bufio.NewReader(s)
+			if typ, err := readVarintFrom(br); err != nil || typ != h3StreamControl {
+				_, _ = io.Copy(ioutil.Discard, br)
+				return
+			}
+			for {
+				typ, _, err := readH3Frame(br)
+				if err != nil {
+					return
+				}
+				if typ == h3FrameGoAway {
+					hc.lock.Lock()
+					hc.goAway = true
+					hc.lock.Unlock()
+				}
+			}
+		}()
+	}
+}
+
+func (hc *h3Conn) roundTrip(req *Request) Response {
+	body, err := req.readBody()
+	if err != nil {
+		return Response{Error: err}
+	}
+	s, err := hc.qc.openStream(true)
+	if err != nil {
+		return Response{Status: BAD_REQUEST, Error: errStreamRefused}
+	}
+	// 超时或取消时中止流
+	stop := make(chan struct{})
+	defer close(stop)
+	deadline, timeoutErr := req.attemptDeadline()
+	go func() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			s.abort(h3RequestCancelled, timeoutErr)
+		case <-hc.h.ctx.Done():
+			s.abort(h3RequestCancelled, ErrRequestCanceled)
+		case <-stop:
+		}
+	}()
+
+	frames := appendH3Frame(nil, h3FrameHeaders, appendQpackFieldSection(nil, requestFields(req, hc.h.base, body)))
+	if len(body) > 0 {
+		frames = appendH3Frame(frames, h3FrameData, body)
+	}
+	if _, err = s.Write(frames); err == nil {
+		err = s.CloseWrite()
+	}
+	if err != nil {
+		return Response{Status: BAD_REQUEST, Error: err}
+	}
+	res, err := readH3Response(bufio.NewReader(s))
+	if err != nil {
+		s.abort(h3RequestCancelled, err)
+		return Response{Status: BAD_REQUEST, Error: err}
+	}
+	res.TLS = hc.tls
+	return res
+}
+
+// 读取请求流上的响应: 跳过1xx响应, 合并trailer, 忽略未知类型的帧
+func readH3Response(br *bufio.Reader) (res Response, err error) {
+	var body bytes.Buffer
+	got := false
+	for {
+		typ, length, err := readH3FrameHeader(br)
+		if err == io.EOF && got {
+			break
+		}
+		if err != nil {
+			return res, err
+		}
+		if typ == h3FrameData {
+			if !got {
+				return res, fmt.Errorf("%w: DATA before HEADERS", ErrHttp3Protocol)
+			}
+			if _, err = io.CopyN(&body, br, int64(length)); err != nil {
+				return res, err
+			}
+			continue
+		}
+		payload, err := readH3Payload(br, length)
+		if err != nil {
+			return res, err
+		}
+		if typ != h3FrameHeaders {
+			continue
+		}
+		fields, err := decodeQpackFieldSection(payload)
+		if err != nil {
+			return res, err
+		}
+		if got {
+			// trailer
+			for _, f := range fields {
+				res.Headers[f.name] = f.value
+			}
+			continue
+		}
+		if res = responseFromFields(HTTP3, fields); res.Status >= 100 && res.Status < 200 {
+			continue
+		}
+		if res.Status == 0 {
+			return res, fmt.Errorf("%w: missing :status", ErrHttp3Protocol)
+		}
+		got = true
+	}
+	res.Body = body.String()
+	res.ContentLength = uint64(body.Len())
+	return res, nil
+}
+
+/// -------------------------------- 帧 --------------------------------
+
+func appendH3Frame(dst []byte, typ uint64, payload []byte) []byte {
+	dst = appendVarint(dst, typ)
+	dst = appendVarint(dst, uint64(len(payload)))
+	return append(dst, payload...)
+}
+
+// 读取帧的类型与长度, 在帧边界上结束时返回 io.EOF
+func readH3FrameHeader(br *bufio.Reader) (typ, length uint64, err error) {
+	if typ, err = readVarintFrom(br); err != nil {
+		return
+	}
+	if length, err = readVarintFrom(br); err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+func readH3Payload(br *bufio.Reader, length uint64) ([]byte, error) {
+	if length > h3MaxHeaderBytes {
+		return nil, fmt.Errorf("%w: frame too large", ErrHttp3Protocol)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return payload, nil
+}
+
+// 读取一个完整的帧, 用于控制流
+func readH3Frame(br *bufio.Reader) (uint64, []byte, error) {
+	typ, length, err := readH3FrameHeader(br)
+	if err != nil {
+		return 0, nil, err
+	}
+	payload, err := readH3Payload(br, length)
+	return typ, payload, err
+}
+
+/// -------------------------------- QPACK --------------------------------
+
+// 静态表 (RFC 9204 附录A), 索引从0开始
+var qpackStaticTable = [...]hpackField{
+	{":authority", ""},
+	{":path", "/"},
+	{"age", "0"},
+	{"content-disposition", ""},
+	{"content-length", "0"},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"referer", ""},
+	{"set-cookie", ""},
+	{":method", "CONNECT"},
+	{":method", "DELETE"},
+	{":method", "GET"},
+	{":method", "HEAD"},
+	{":method", "OPTIONS"},
+	{":method", "POST"},
+	{":method", "PUT"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "103"},
+	{":status", "200"},
+	{":status", "304"},
+	{":status", "404"},
+	{":status", "503"},
+	{"accept", "*/*"},
+	{"accept", "application/dns-message"},
+	{"accept-encoding", "gzip, deflate, br"},
+	{"accept-ranges", "bytes"},
+	{"access-control-allow-headers", "cache-control"},
+	{"access-control-allow-headers", "content-type"},
+	{"access-control-allow-origin", "*"},
+	{"cache-control", "max-age=0"},
+	{"cache-control", "max-age=2592000"},
+	{"cache-control", "max-age=604800"},
+	{"cache-control", "no-cache"},
+	{"cache-control", "no-store"},
+	{"cache-control", "public, max-age=31536000"},
+	{"content-encoding", "br"},
+	{"content-encoding", "gzip"},
+	{"content-type", "application/dns-message"},
+	{"content-type", "application/javascript"},
+	{"content-type", "application/json"},
+	{"content-type", "application/x-www-form-urlencoded"},
+	{"content-type", "image/gif"},
+	{"content-type", "image/jpeg"},
+	{"content-type", "image/png"},
+	{"content-type", "text/css"},
+	{"content-type", "text/html; charset=utf-8"},
+	{"content-type", "text/plain"},
+	{"content-type", "text/plain;charset=utf-8"},
+	{"range", "bytes=0-"},
+	{"strict-transport-security", "max-age=31536000"},
+	{"strict-transport-security", "max-age=31536000; includesubdomains"},
+	{"strict-transport-security", "max-age=31536000; includesubdomains; preload"},
+	{"vary", "accept-encoding"},
+	{"vary", "origin"},
+	{"x-content-type-options", "nosniff"},
+	{"x-xss-protection", "1; mode=block"},
+	{":status", "100"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "302"},
+	{":status", "400"},
+	{":status", "403"},
+	{":status", "421"},
+	{":status", "425"},
+	{":status", "500"},
+	{"accept-language", ""},
+	{"access-control-allow-credentials", "FALSE"},
+	{"access-control-allow-credentials", "TRUE"},
+	{"access-control-allow-headers", "*"},
+	{"access-control-allow-methods", "get"},
+	{"access-control-allow-methods", "get, post, options"},
+	{"access-control-allow-methods", "options"},
+	{"access-control-expose-headers", "content-length"},
+	{"access-control-request-headers", "content-type"},
+	{"access-control-request-method", "get"},
+	{"access-control-request-method", "post"},
+	{"alt-svc", "clear"},
+	{"authorization", ""},
+	{"content-security-policy", "script-src 'none'; object-src 'none'; base-uri 'none'"},
+	{"early-data", "1"},
+	{"expect-ct", ""},
+	{"forwarded", ""},
+	{"if-range", ""},
+	{"origin", ""},
+	{"purpose", "prefetch"},
+	{"server", ""},
+	{"timing-allow-origin", "*"},
+	{"upgrade-insecure-requests", "1"},
+	{"user-agent", ""},
+	{"x-forwarded-for", ""},
+	{"x-frame-options", "deny"},
+	{"x-frame-options", "sameorigin"},
+}
+
+// 静态表的反向索引: 完全匹配与仅名称匹配
+var (
+	qpackStaticFields = map[hpackField]int{}
+	qpackStaticNames  = map[string]int{}
+)
+
+func init() {
+	for i, f := range qpackStaticTable {
+		if _, ok := qpackStaticFields[f]; !ok {
+			qpackStaticFields[f] = i
+		}
+		if _, ok := qpackStaticNames[f.name]; !ok {
+			qpackStaticNames[f.name] = i
+		}
+	}
+}
+
+// 编码字段节 (RFC 9204 4.5), 不引用动态表, Required Insert Count 与 Base 均为0
+func appendQpackFieldSection(dst []byte, fields []hpackField) []byte {
+	dst = append(dst, 0, 0)
+	for _, f := range fields {
+		if idx, ok := qpackStaticFields[f]; ok {
+			// 静态表索引字段 (RFC 9204 4.5.2)
+			dst = appendHpackInt(dst, 6, 0xc0, uint64(idx))
+			continue
+		}
+		// 字面值, 敏感字段设置N位, 中间节点不得将其加入动态表
+		if idx, ok := qpackStaticNames[f.name]; ok {
+			dst = appendHpackInt(dst, 4, 0x50|byte(If(neverIndexed(f.name), 0x20, 0).(int)), uint64(idx))
+		} else {
+			dst = appendPrefixedString(dst, 3, 0x20|byte(If(neverIndexed(f.name), 0x10, 0).(int)), f.name)
+		}
+		dst = appendPrefixedString(dst, 7, 0, f.value)
+	}
+	return dst
+}
+
+// 解码字段节, 引用动态表的表示均视为错误
+func decodeQpackFieldSection(block []byte) (fields []hpackField, err error) {
+	ric, rest, err := readHpackInt(block, 8)
+	if err != nil || ric != 0 {
+		return nil, ErrQpackDecode
+	}
+	if _, rest, err = readHpackInt(rest, 7); err != nil {
+		return nil, ErrQpackDecode
+	}
+	for len(rest) > 0 {
+		b := rest[0]
+		var f hpackField
+		var idx uint64
+		switch {
+		case b&0xc0 == 0xc0:
+			if idx, rest, err = readHpackInt(rest, 6); err == nil {
+				f, err = qpackStaticField(idx)
+			}
+		case b&0xd0 == 0x50:
+			if idx, rest, err = readHpackInt(rest, 4); err == nil {
+				if f, err = qpackStaticField(idx); err == nil {
+					f.value, rest, err = readPrefixedString(rest, 7)
+				}
+			}
+		case b&0xe0 == 0x20:
+			if f.name, rest, err = readPrefixedString(rest, 3); err == nil {
+				f.value, rest, err = readPrefixedString(rest, 7)
+			}
+		default:
+			err = ErrQpackDecode
+		}
+		if err != nil {
+			return nil, ErrQpackDecode
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func qpackStaticField(idx uint64) (hpackField, error) {
+	if idx >= uint64(len(qpackStaticTable)) {
+		return hpackField{}, ErrQpackDecode
+	}
+	return qpackStaticTable[idx], nil
+}
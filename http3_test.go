@@ -0,0 +1,332 @@
+package HiHttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 本地回环上的HTTP/3测试服务, 证书取自TCP上的HTTPS测试服务
+type h3TestServer struct {
+	l       *quicListener
+	handler http.Handler
+	conns   int32 // 已接受的QUIC连接数
+}
+
+func newH3Server(t *testing.T, tcp *httptest.Server, handler http.Handler, pc net.PacketConn) *h3TestServer {
+	if pc == nil {
+		var err error
+		if pc, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := tcp.TLS.Clone()
+	config.NextProtos = alpnHTTP3
+	s := &h3TestServer{l: quicListen(pc, config), handler: handler}
+	t.Cleanup(func() { _ = s.l.Close() })
+	go func() {
+		for {
+			qc, err := s.l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&s.conns, 1)
+			go s.serveConn(qc)
+		}
+	}()
+	return s
+}
+
+func (s *h3TestServer) addr() string {
+	return s.l.pc.LocalAddr().String()
+}
+
+func (s *h3TestServer) serveConn(qc *quicConn) {
+	if control, err := qc.openStream(false); err == nil {
+		_, _ = control.Write(appendH3Frame([]byte{h3StreamControl}, h3FrameSettings, nil))
+	}
+	go func() {
+		for {
+			us, err := qc.acceptStream(false)
+			if err != nil {
+				return
+			}
+			go func() { _, _ = io.Copy(ioutil.Discard, us) }()
+		}
+	}()
+	for {
+		st, err := qc.acceptStream(true)
+		if err != nil {
+			return
+		}
+		go s.serveStream(st)
+	}
+}
+
+func (s *h3TestServer) serveStream(st *quicStream) {
+	br := bufio.NewReader(st)
+	typ, payload, err := readH3Frame(br)
+	if err != nil || typ != h3FrameHeaders {
+		return
+	}
+	fields, err := decodeQpackFieldSection(payload)
+	if err != nil {
+		return
+	}
+	var body bytes.Buffer
+	for {
+		typ, length, err := readH3FrameHeader(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return
+		}
+		if typ == h3FrameData {
+			_, err = io.CopyN(&body, br, int64(length))
+		} else {
+			_, err = readH3Payload(br, length)
+		}
+		if err != nil {
+			return
+		}
+	}
+	pseudo := map[string]string{}
+	header := http.Header{}
+	for _, f := range fields {
+		if strings.HasPrefix(f.name, ":") {
+			pseudo[f.name] = f.value
+		} else {
+			header.Add(f.name, f.value)
+		}
+	}
+	req := httptest.NewRequest(pseudo[":method"], "https://"+pseudo[":authority"]+pseudo[":path"], &body)
+	req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/3.0", 3, 0
+	req.Header = header
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+
+	out := []hpackField{{":status", strconv.Itoa(rec.Code)}}
+	for key, vals := range rec.Header() {
+		for _, val := range vals {
+			out = append(out, hpackField{strings.ToLower(key), val})
+		}
+	}
+	data := appendH3Frame(nil, h3FrameHeaders, appendQpackFieldSection(nil, out))
+	if rec.Body.Len() > 0 && req.Method != "HEAD" {
+		data = appendH3Frame(data, h3FrameData, rec.Body.Bytes())
+	}
+	_, _ = st.Write(data)
+	_ = st.CloseWrite()
+}
+
+func h3Client(t *testing.T, ctx context.Context, url string, cert *x509.Certificate, opts Options) HttpClient {
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	opts.TLSConfig = &tls.Config{RootCAs: pool}
+	client, err := HiHttp(ctx, url, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func protoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(ioutil.Discard, r.Body)
+		w.Header().Set("X-Received", strconv.FormatInt(n, 10))
+		if size, _ := strconv.Atoi(r.URL.Query().Get("size")); size > 0 {
+			_, _ = w.Write(bytes.Repeat([]byte{'x'}, size))
+			return
+		}
+		_, _ = fmt.Fprintf(w, "%s %s", r.Proto, r.URL.Path)
+	})
+}
+
+// 测试字段节的编解码 (RFC 9204 附录B.1), 以及引用动态表的表示被拒绝
+func TestQpack_FieldSection(t *testing.T) {
+	block, _ := hex.DecodeString("0000510b2f696e6465782e68746d6c")
+	fields, err := decodeQpackFieldSection(block)
+	if err != nil || len(fields) != 1 || fields[0] != (hpackField{":path", "/index.html"}) {
+		t.Fatalf("got %v, %v", fields, err)
+	}
+	want := []hpackField{
+		{":method", "GET"},
+		{":path", "/search?q=hi"},
+		{"authorization", "Basic YWRtaW46c2VjcmV0"},
+		{"x-custom", "www.example.com"},
+		{"content-type", "application/json"},
+	}
+	fields, err = decodeQpackFieldSection(appendQpackFieldSection(nil, want))
+	if err != nil || fmt.Sprint(fields) != fmt.Sprint(want) {
+		t.Fatalf("round trip: %v, %v", fields, err)
+	}
+	for _, invalid := range []string{"0200", "000080", "000010", "0000ff", "00005f"} {
+		block, _ := hex.DecodeString(invalid)
+		if _, err := decodeQpackFieldSection(block); err != ErrQpackDecode {
+			t.Fatalf("%s: expected ErrQpackDecode, got %v", invalid, err)
+		}
+	}
+}
+
+func TestParseAltSvc(t *testing.T) {
+	for _, c := range []struct {
+		value, addr string
+		maxAge      time.Duration
+		ok          bool
+	}{
+		{`h3=":8443"; ma=60`, "example.com:8443", time.Minute, true},
+		{`h3-29=":443", h3="alt.example.com:443"`, "alt.example.com:443", h3AltSvcMaxAge, true},
+		{`h2=":443"`, "", 0, false},
+		{`clear`, "", 0, true},
+	} {
+		addr, maxAge, ok := parseAltSvc(c.value, "example.com")
+		if addr != c.addr || maxAge != c.maxAge || ok != c.ok {
+			t.Fatalf("%s: got %q %v %v", c.value, addr, maxAge, ok)
+		}
+	}
+}
+
+// 测试 Options.Version = HTTP3: 在有丢包的回环网络上并发请求复用同一个QUIC连接, 请求体与响应体超过流量控制窗口
+func TestHttpClient_HTTP3(t *testing.T) {
+	tcp := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tcp.Close()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newH3Server(t, tcp, protoHandler(), &lossyPacketConn{PacketConn: pc, every: 20})
+	client := h3Client(t, context.Background(), "https://"+server.addr(), tcp.Certificate(), Options{Version: HTTP3})
+	defer client.End()
+
+	results, errs := getAll(client, []string{"/0", "/1", "/2", "/3", "/4"})
+	for i := range results {
+		if want := fmt.Sprintf("HTTP/3.0 /%d", i); errs[i] != nil || results[i] != want {
+			t.Fatalf("request %d: %q, %v", i, results[i], errs[i])
+		}
+	}
+	const size = 5 << 20
+	res := client.(*hiHttp).execute("POST", fmt.Sprintf("/upload?size=%d", size), strings.NewReader(strings.Repeat("y", size)), "")
+	if res.Error != nil || res.Version != HTTP3 || len(res.Body) != size || res.Headers.Get("X-Received") != strconv.Itoa(size) {
+		t.Fatalf("upload: %s %d bytes, received %q, %v", res.Version, len(res.Body), res.Headers.Get("X-Received"), res.Error)
+	}
+	if err := client.Head("/head"); err != nil {
+		t.Fatalf("head: %v", err)
+	}
+	if n := atomic.LoadInt32(&server.conns); n != 1 {
+		t.Fatalf("server accepted %d QUIC connections, want 1", n)
+	}
+}
+
+// 测试通过TCP响应的 Alt-Svc 头发现HTTP/3服务, 之后的请求改用HTTP/3
+func TestHttpClient_HTTP3_AltSvc(t *testing.T) {
+	var altSvc atomic.Value
+	tcp := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Alt-Svc", altSvc.Load().(string))
+		protoHandler().ServeHTTP(w, r)
+	}))
+	tcp.EnableHTTP2 = true
+	tcp.StartTLS()
+	defer tcp.Close()
+	server := newH3Server(t, tcp, protoHandler(), nil)
+	_, port, _ := net.SplitHostPort(server.addr())
+	altSvc.Store(fmt.Sprintf(`h3=":%s"; ma=60`, port))
+
+	for _, version := range []string{HTTP11, HTTP2} {
+		client := h3Client(t, context.Background(), tcp.URL, tcp.Certificate(), Options{Version: version, AltSvc: true})
+		first := map[string]string{HTTP11: "HTTP/1.1 /a", HTTP2: "HTTP/2.0 /a"}[version]
+		if result, err := client.Get("/a"); err != nil || result != first {
+			t.Fatalf("%s first request: %q, %v", version, result, err)
+		}
+		for i := 0; i < 2; i++ {
+			if result, err := client.Get("/b"); err != nil || result != "HTTP/3.0 /b" {
+				t.Fatalf("%s request after Alt-Svc: %q, %v", version, result, err)
+			}
+		}
+		client.End()
+	}
+	if _, err := HiHttp(context.Background(), "http://127.0.0.1", Options{AltSvc: true}); err == nil {
+		t.Fatal("expected error for Alt-Svc over plain http")
+	}
+}
+
+// 测试UDP被阻断(数据报被丢弃)或端口不可达时回退到TCP, 之后的请求不再等待QUIC握手
+func TestHttpClient_HTTP3_Fallback(t *testing.T) {
+	tcp := httptest.NewUnstartedServer(protoHandler())
+	tcp.EnableHTTP2 = true
+	tcp.StartTLS()
+	defer tcp.Close()
+	// 与TCP端口相同的UDP端口上只接收不响应
+	blackhole, err := net.ListenPacket("udp", tcp.Listener.Addr().String())
+	if err != nil {
+		t.Skip("udp port unavailable:", err)
+	}
+	client := h3Client(t, context.Background(), tcp.URL, tcp.Certificate(), Options{Version: HTTP3})
+	defer client.End()
+	client.SetTimeout(500 * time.Millisecond)
+	if result, err := client.Get("/a"); err != nil || result != "HTTP/2.0 /a" {
+		t.Fatalf("got %q, %v", result, err)
+	}
+	start := time.Now()
+	if result, err := client.Get("/b"); err != nil || result != "HTTP/2.0 /b" || time.Since(start) > 200*time.Millisecond {
+		t.Fatalf("got %q, %v after %v", result, err, time.Since(start))
+	}
+	_ = blackhole.Close()
+
+	// 端口不可达时无需等待超时
+	client = h3Client(t, context.Background(), tcp.URL, tcp.Certificate(), Options{Version: HTTP3})
+	defer client.End()
+	start = time.Now()
+	if result, err := client.Get("/c"); err != nil || result != "HTTP/2.0 /c" || time.Since(start) > 2*time.Second {
+		t.Fatalf("got %q, %v after %v", result, err, time.Since(start))
+	}
+}
+
+// 测试超时与取消时中止流, 连接仍可继续使用
+func TestHttpClient_HTTP3_Cancel(t *testing.T) {
+	tcp := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tcp.Close()
+	server := newH3Server(t, tcp, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(time.Second)
+		}
+		_, _ = w.Write([]byte("ok"))
+	}), nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := h3Client(t, ctx, "https://"+server.addr(), tcp.Certificate(), Options{Version: HTTP3})
+	defer client.End()
+
+	client.SetTimeout(200 * time.Millisecond)
+	if _, err := client.Get("/slow"); err != ErrRequestTimeout {
+		t.Fatalf("expected ErrRequestTimeout, got %v", err)
+	}
+	client.SetTimeout(DefTimeout)
+	if result, err := client.Get("/fast"); err != nil || result != "ok" {
+		t.Fatalf("got %q, %v", result, err)
+	}
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := client.Get("/slow"); err != ErrRequestCanceled {
+		t.Fatalf("expected ErrRequestCanceled, got %v", err)
+	}
+	if n := atomic.LoadInt32(&server.conns); n != 1 {
+		t.Fatalf("server accepted %d QUIC connections, want 1", n)
+	}
+}
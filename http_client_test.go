@@ -3,17 +3,18 @@ package HiHttp
 import (
 	"bytes"
 	"context"
+	"errors"
 	"testing"
 	"time"
 )
 
-/// -------------------------------- 运行单元测试前，请先运行 /server/main.go --------------------------------
+/// -------------------------------- 测试接口与 /server/main.go 相同, 通过 Dialer 在内存中运行 --------------------------------
 /// -------------------------------- HTTP TESTING --------------------------------
 
 // 测试Get请求
 func TestHttpClient_Get(t *testing.T) {
 	ctx := context.WithValue(context.Background(), "DEV", true)
-	client, err := HiHttp(ctx, "http://localhost:888", Options{Retry: 0})
+	client, err := HiHttp(ctx, "http://localhost:888", Options{Retry: 0, Dialer: devDialer(t)})
 	if err != nil {
 		t.Log(err)
 		t.Fail()
@@ -32,7 +33,8 @@ func TestHttpClient_Get(t *testing.T) {
 // 测试主动取消请求
 func TestHttpClient_Get_With_Cancel(t *testing.T) {
 	ctx, cancelFunc := context.WithCancel(context.Background())
-	client, err := HiHttp(ctx, "http://localhost:888", Options{Retry: 1})
+	defer cancelFunc()
+	client, err := HiHttp(ctx, "http://localhost:888", Options{Retry: 1, Dialer: devDialer(t)})
 	if err != nil {
 		t.Log(err)
 		t.Fail()
@@ -51,7 +53,7 @@ func TestHttpClient_Get_With_Cancel(t *testing.T) {
 // 测试连接错误的服务器地址
 func TestHttpClient_Get_With_Wrong_Conn(t *testing.T) {
 	ctx := context.WithValue(context.Background(), "DEV", true)
-	client, err := HiHttp(ctx, "http://localhost:8888", Options{Retry: 1})
+	client, err := HiHttp(ctx, "http://localhost:8888", Options{Retry: 1, Dialer: devDialer(t)})
 	if err != nil {
 		t.Log(err)
 		t.Fail()
@@ -59,7 +61,7 @@ func TestHttpClient_Get_With_Wrong_Conn(t *testing.T) {
 	}
 	defer client.End()
 	result, err := client.Get("/hello")
-	if err != nil && err == ErrConnectingTimeout {
+	if errors.Is(err, ErrConnectingTimeout) {
 		return
 	}
 	t.Logf("fail!(result -> %s), err: %v\n", result, err)
@@ -69,7 +71,7 @@ func TestHttpClient_Get_With_Wrong_Conn(t *testing.T) {
 // 测试连接错误的服务器地址
 func TestHttpClient_Get_With_Timeout(t *testing.T) {
 	ctx := context.WithValue(context.Background(), "DEV", true)
-	client, err := HiHttp(ctx, "http://localhost:888", Options{Retry: 1})
+	client, err := HiHttp(ctx, "http://localhost:888", Options{Retry: 1, Dialer: devDialer(t)})
 	if err != nil {
 		t.Log(err)
 		t.Fail()
@@ -92,7 +94,7 @@ func TestHttpClient_Get_With_Timeout(t *testing.T) {
 // 测试同一个客户端进行多次请求
 func TestHttpClient_Get_With_Multi_Req(t *testing.T) {
 	ctx := context.WithValue(context.Background(), "DEV", true)
-	client, err := HiHttp(ctx, "http://localhost:888", Options{Retry: 0})
+	client, err := HiHttp(ctx, "http://localhost:888", Options{Retry: 0, Dialer: devDialer(t)})
 	if err != nil {
 		t.Log(err)
 		t.Fail()
@@ -136,7 +138,7 @@ func TestHttpClient_Get_With_Multi_Req(t *testing.T) {
 // 测试Post请求
 func TestHttpClient_Post(t *testing.T) {
 	ctx := context.WithValue(context.Background(), "DEV", true)
-	client, err := HiHttp(ctx, "http://localhost:888", Options{Retry: 0})
+	client, err := HiHttp(ctx, "http://localhost:888", Options{Retry: 0, Dialer: devDialer(t)})
 	if err != nil {
 		t.Log(err)
 		t.Fail()
@@ -162,7 +164,7 @@ func TestHttpClient_Post(t *testing.T) {
 // 测试Head请求
 func TestHttpClient_Head(t *testing.T) {
 	ctx := context.WithValue(context.Background(), "DEV", true)
-	client, err := HiHttp(ctx, "http://localhost:888", Options{Retry: 0})
+	client, err := HiHttp(ctx, "http://localhost:888", Options{Retry: 0, Dialer: devDialer(t)})
 	if err != nil {
 		t.Log(err)
 		t.Fail()
@@ -181,7 +183,8 @@ func TestHttpClient_Head(t *testing.T) {
 // 测试Https GET请求
 func TestHttpClient_GET_With_TLS(t *testing.T) {
 	ctx := context.WithValue(context.Background(), "DEV", true)
-	client, err := HiHttp(ctx, "https://192.168.80.239:8443", Options{Retry: 0})
+	dialer, config := devTLSDialer(t)
+	client, err := HiHttp(ctx, "https://api.test:8443", Options{Retry: 0, Dialer: dialer, TLSConfig: config})
 	if err != nil {
 		t.Log(err)
 		t.Fail()
@@ -0,0 +1,864 @@
+package HiHttp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MessagePack 格式 (https://github.com/msgpack/msgpack/blob/master/spec.md) 的纯Go实现
+// 结构体以 map 形式编码, 字段名可通过 `msgpack:"name,omitempty"` 标签指定, "-" 表示忽略
+
+// Errors
+var (
+	ErrMsgpackShortData = errors.New("msgpack: unexpected end of data")
+	ErrMsgpackTarget    = errors.New("msgpack: Unmarshal target must be a non-nil pointer")
+)
+
+// 时间戳扩展类型
+const msgpackExtTimestamp int8 = -1
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// MsgpackMarshal 将v编码为MessagePack
+func MsgpackMarshal(v interface{}) ([]byte, error) {
+	e := msgpackEncoder{buf: make([]byte, 0, 64)}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+// MsgpackUnmarshal 将MessagePack数据解码到v指向的对象中
+func MsgpackUnmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return ErrMsgpackTarget
+	}
+	d := msgpackDecoder{data: data}
+	return d.decode(rv.Elem())
+}
+
+/// -------------------------------- 结构体字段 --------------------------------
+
+type msgpackField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var msgpackFieldCache sync.Map // reflect.Type -> []msgpackField
+
+func msgpackFields(t reflect.Type) []msgpackField {
+	if cached, ok := msgpackFieldCache.Load(t); ok {
+		return cached.([]msgpackField)
+	}
+	var fields []msgpackField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("msgpack")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if idx := strings.Index(tag, ","); idx >= 0 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+		// 未指定名称的匿名结构体字段展开到外层
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			for _, inner := range msgpackFields(sf.Type) {
+				inner.index = append([]int{i}, inner.index...)
+				fields = append(fields, inner)
+			}
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, msgpackField{
+			name:      name,
+			index:     []int{i},
+			omitEmpty: strings.Contains(opts, "omitempty"),
+		})
+	}
+	msgpackFieldCache.Store(t, fields)
+	return fields
+}
+
+/// -------------------------------- 编码 --------------------------------
+
+type msgpackEncoder struct {
+	buf []byte
+}
+
+func (e *msgpackEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.buf = append(e.buf, 0xc0)
+		return nil
+	}
+	if v.Type() == timeType {
+		e.encodeTime(v.Interface().(time.Time))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0xc0)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		e.buf = append(e.buf, If(v.Bool(), byte(0xc3), byte(0xc2)).(byte))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.encodeUint(v.Uint())
+	case reflect.Float32:
+		e.buf = append(e.buf, 0xca)
+		e.buf = appendUint32(e.buf, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.buf = append(e.buf, 0xcb)
+		e.buf = appendUint64(e.buf, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.encodeStringHeader(v.Len())
+		e.buf = append(e.buf, v.String()...)
+	case reflect.Slice:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.encodeBytes(v.Bytes())
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			e.encodeBytes(b)
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0xc0)
+			return nil
+		}
+		return e.encodeMap(v)
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeInt(n int64) {
+	switch {
+	case n >= 0:
+		e.encodeUint(uint64(n))
+	case n >= -32:
+		e.buf = append(e.buf, byte(n))
+	case n >= math.MinInt8:
+		e.buf = append(e.buf, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		e.buf = append(e.buf, 0xd1)
+		e.buf = appendUint16(e.buf, uint16(n))
+	case n >= math.MinInt32:
+		e.buf = append(e.buf, 0xd2)
+		e.buf = appendUint32(e.buf, uint32(n))
+	default:
+		e.buf = append(e.buf, 0xd3)
+		e.buf = appendUint64(e.buf, uint64(n))
+	}
+}
+
+func (e *msgpackEncoder) encodeUint(n uint64) {
+	switch {
+	case n <= 0x7f:
+		e.buf = append(e.buf, byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xcc, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, 0xcd)
+		e.buf = appendUint16(e.buf, uint16(n))
+	case n <= math.MaxUint32:
+		e.buf = append(e.buf, 0xce)
+		e.buf = appendUint32(e.buf, uint32(n))
+	default:
+		e.buf = append(e.buf, 0xcf)
+		e.buf = appendUint64(e.buf, n)
+	}
+}
+
+func (e *msgpackEncoder) encodeStringHeader(n int) {
+	switch {
+	case n < 32:
+		e.buf = append(e.buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, 0xda)
+		e.buf = appendUint16(e.buf, uint16(n))
+	default:
+		e.buf = append(e.buf, 0xdb)
+		e.buf = appendUint32(e.buf, uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) encodeBytes(b []byte) {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, 0xc5)
+		e.buf = appendUint16(e.buf, uint16(n))
+	default:
+		e.buf = append(e.buf, 0xc6)
+		e.buf = appendUint32(e.buf, uint32(n))
+	}
+	e.buf = append(e.buf, b...)
+}
+
+func (e *msgpackEncoder) encodeArrayHeader(n int) {
+	switch {
+	case n < 16:
+		e.buf = append(e.buf, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, 0xdc)
+		e.buf = appendUint16(e.buf, uint16(n))
+	default:
+		e.buf = append(e.buf, 0xdd)
+		e.buf = appendUint32(e.buf, uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) encodeMapHeader(n int) {
+	switch {
+	case n < 16:
+		e.buf = append(e.buf, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, 0xde)
+		e.buf = appendUint16(e.buf, uint16(n))
+	default:
+		e.buf = append(e.buf, 0xdf)
+		e.buf = appendUint32(e.buf, uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) encodeArray(v reflect.Value) error {
+	e.encodeArrayHeader(v.Len())
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeMap(v reflect.Value) error {
+	keys := v.MapKeys()
+	// 字符串key排序后输出, 保证编码结果稳定
+	if v.Type().Key().Kind() == reflect.String {
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	}
+	e.encodeMapHeader(len(keys))
+	for _, key := range keys {
+		if err := e.encode(key); err != nil {
+			return err
+		}
+		if err := e.encode(v.MapIndex(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeStruct(v reflect.Value) error {
+	fields := msgpackFields(v.Type())
+	values := make([]reflect.Value, 0, len(fields))
+	names := make([]string, 0, len(fields))
+	for _, f := range fields {
+		fv := v.FieldByIndex(f.index)
+		if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		values = append(values, fv)
+		names = append(names, f.name)
+	}
+	e.encodeMapHeader(len(values))
+	for i, fv := range values {
+		e.encodeStringHeader(len(names[i]))
+		e.buf = append(e.buf, names[i]...)
+		if err := e.encode(fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 按 timestamp 扩展类型的最短格式编码
+func (e *msgpackEncoder) encodeTime(t time.Time) {
+	sec, nsec := t.Unix(), int64(t.Nanosecond())
+	typ := msgpackExtTimestamp
+	switch {
+	case sec>>34 == 0 && nsec == 0 && sec <= math.MaxUint32:
+		e.buf = append(e.buf, 0xd6, byte(typ))
+		e.buf = appendUint32(e.buf, uint32(sec))
+	case sec>>34 == 0:
+		e.buf = append(e.buf, 0xd7, byte(typ))
+		e.buf = appendUint64(e.buf, uint64(nsec)<<34|uint64(sec))
+	default:
+		e.buf = append(e.buf, 0xc7, 12, byte(typ))
+		e.buf = appendUint32(e.buf, uint32(nsec))
+		e.buf = appendUint64(e.buf, uint64(sec))
+	}
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+func appendUint16(b []byte, n uint16) []byte {
+	return append(b, byte(n>>8), byte(n))
+}
+
+func appendUint32(b []byte, n uint32) []byte {
+	return append(b, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func appendUint64(b []byte, n uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(n>>32)), uint32(n))
+}
+
+/// -------------------------------- 解码 --------------------------------
+
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *msgpackDecoder) readByte() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, ErrMsgpackShortData
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *msgpackDecoder) readN(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, ErrMsgpackShortData
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *msgpackDecoder) readUint(size int) (uint64, error) {
+	b, err := d.readN(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// 读取长度字段, size为长度字段本身的字节数
+func (d *msgpackDecoder) readLen(size int) (int, error) {
+	n, err := d.readUint(size)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(d.data)) {
+		return 0, ErrMsgpackShortData
+	}
+	return int(n), nil
+}
+
+// 解码为不带类型信息的Go值: nil, bool, int64, uint64, float32/64, string, []byte,
+// []interface{}, map[string]interface{} (key非字符串时为 map[interface{}]interface{}), time.Time
+func (d *msgpackDecoder) decodeInterface() (interface{}, error) {
+	c, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.decodeGenericMap(int(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return d.decodeGenericArray(int(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		b, err := d.readN(int(c & 0x1f))
+		return string(b), err
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := d.readUint(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if n <= math.MaxInt64 {
+			return int64(n), nil
+		}
+		return n, nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		n, err := d.readUint(size)
+		return signExtend(n, size), err
+	case 0xca:
+		n, err := d.readUint(4)
+		return math.Float32frombits(uint32(n)), err
+	case 0xcb:
+		n, err := d.readUint(8)
+		return math.Float64frombits(n), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.readLen(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.readN(n)
+		return string(b), err
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.readLen(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.readN(n)
+		return append([]byte(nil), b...), err
+	case 0xdc, 0xdd:
+		n, err := d.readLen(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeGenericArray(n)
+	case 0xde, 0xdf:
+		n, err := d.readLen(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeGenericMap(n)
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xc7, 0xc8, 0xc9:
+		typ, data, err := d.readExt(c)
+		if err != nil {
+			return nil, err
+		}
+		if typ == msgpackExtTimestamp {
+			return decodeTimestamp(data)
+		}
+		return nil, fmt.Errorf("msgpack: unsupported extension type %d", typ)
+	}
+	return nil, fmt.Errorf("msgpack: invalid code 0x%x", c)
+}
+
+func (d *msgpackDecoder) decodeGenericArray(n int) (interface{}, error) {
+	arr := make([]interface{}, n)
+	for i := range arr {
+		item, err := d.decodeInterface()
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = item
+	}
+	return arr, nil
+}
+
+func (d *msgpackDecoder) decodeGenericMap(n int) (interface{}, error) {
+	keys := make([]interface{}, n)
+	values := make([]interface{}, n)
+	allString := true
+	for i := 0; i < n; i++ {
+		key, err := d.decodeInterface()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := key.(string); !ok {
+			allString = false
+		}
+		if keys[i] = key; key != nil && !reflect.TypeOf(key).Comparable() {
+			return nil, fmt.Errorf("msgpack: unhashable map key %T", key)
+		}
+		if values[i], err = d.decodeInterface(); err != nil {
+			return nil, err
+		}
+	}
+	if allString {
+		m := make(map[string]interface{}, n)
+		for i, key := range keys {
+			m[key.(string)] = values[i]
+		}
+		return m, nil
+	}
+	m := make(map[interface{}]interface{}, n)
+	for i, key := range keys {
+		m[key] = values[i]
+	}
+	return m, nil
+}
+
+// 读取扩展类型, 返回类型编号与数据
+func (d *msgpackDecoder) readExt(c byte) (int8, []byte, error) {
+	var n int
+	var err error
+	switch c {
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		n = 1 << (c - 0xd4)
+	default:
+		if n, err = d.readLen(1 << (c - 0xc7)); err != nil {
+			return 0, nil, err
+		}
+	}
+	typ, err := d.readByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	data, err := d.readN(n)
+	return int8(typ), data, err
+}
+
+func decodeTimestamp(b []byte) (time.Time, error) {
+	switch len(b) {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(b)), 0), nil
+	case 8:
+		n := binary.BigEndian.Uint64(b)
+		return time.Unix(int64(n&(1<<34-1)), int64(n>>34)), nil
+	case 12:
+		nsec := binary.BigEndian.Uint32(b)
+		return time.Unix(int64(binary.BigEndian.Uint64(b[4:])), int64(nsec)), nil
+	}
+	return time.Time{}, fmt.Errorf("msgpack: invalid timestamp length %d", len(b))
+}
+
+func signExtend(n uint64, size int) int64 {
+	switch size {
+	case 1:
+		return int64(int8(n))
+	case 2:
+		return int64(int16(n))
+	case 4:
+		return int64(int32(n))
+	}
+	return int64(n)
+}
+
+// 按目标类型解码
+func (d *msgpackDecoder) decode(v reflect.Value) error {
+	if d.pos < len(d.data) && d.data[d.pos] == 0xc0 {
+		d.pos++
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decode(v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() == 0 {
+			item, err := d.decodeInterface()
+			if err != nil {
+				return err
+			}
+			if item != nil {
+				v.Set(reflect.ValueOf(item))
+			}
+			return nil
+		}
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return d.decodeTime(v)
+		}
+		return d.decodeStruct(v)
+	case reflect.Map:
+		return d.decodeMap(v)
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 && d.pos < len(d.data) && isMsgpackBinOrStr(d.data[d.pos]) {
+			item, err := d.decodeInterface()
+			if err != nil {
+				return err
+			}
+			return setBytes(v, item)
+		}
+		return d.decodeArray(v)
+	}
+	item, err := d.decodeInterface()
+	if err != nil {
+		return err
+	}
+	return setScalar(v, item)
+}
+
+func (d *msgpackDecoder) readMapLen() (int, error) {
+	c, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case c&0xf0 == 0x80:
+		return int(c & 0x0f), nil
+	case c == 0xde, c == 0xdf:
+		return d.readLen(2 << (c - 0xde))
+	}
+	return 0, fmt.Errorf("msgpack: expected map, got code 0x%x", c)
+}
+
+func (d *msgpackDecoder) readArrayLen() (int, error) {
+	c, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case c&0xf0 == 0x90:
+		return int(c & 0x0f), nil
+	case c == 0xdc, c == 0xdd:
+		return d.readLen(2 << (c - 0xdc))
+	}
+	return 0, fmt.Errorf("msgpack: expected array, got code 0x%x", c)
+}
+
+func (d *msgpackDecoder) decodeStruct(v reflect.Value) error {
+	n, err := d.readMapLen()
+	if err != nil {
+		return err
+	}
+	fields := msgpackFields(v.Type())
+	for i := 0; i < n; i++ {
+		var name string
+		if err = d.decode(reflect.ValueOf(&name).Elem()); err != nil {
+			return err
+		}
+		var target reflect.Value
+		for _, f := range fields {
+			if f.name == name {
+				target = fieldByIndexAlloc(v, f.index)
+				break
+			}
+		}
+		if !target.IsValid() {
+			// 未知字段直接跳过
+			if _, err = d.decodeInterface(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = d.decode(target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *msgpackDecoder) decodeMap(v reflect.Value) error {
+	n, err := d.readMapLen()
+	if err != nil {
+		return err
+	}
+	t := v.Type()
+	if v.IsNil() {
+		v.Set(reflect.MakeMapWithSize(t, n))
+	}
+	for i := 0; i < n; i++ {
+		key := reflect.New(t.Key()).Elem()
+		if err = d.decode(key); err != nil {
+			return err
+		}
+		val := reflect.New(t.Elem()).Elem()
+		if err = d.decode(val); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, val)
+	}
+	return nil
+}
+
+func (d *msgpackDecoder) decodeArray(v reflect.Value) error {
+	n, err := d.readArrayLen()
+	if err != nil {
+		return err
+	}
+	if v.Kind() == reflect.Slice {
+		v.Set(reflect.MakeSlice(v.Type(), n, n))
+	} else if n > v.Len() {
+		return fmt.Errorf("msgpack: array of length %d overflows %s", n, v.Type())
+	}
+	for i := 0; i < n; i++ {
+		if err = d.decode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *msgpackDecoder) decodeTime(v reflect.Value) error {
+	item, err := d.decodeInterface()
+	if err != nil {
+		return err
+	}
+	t, ok := item.(time.Time)
+	if !ok {
+		return fmt.Errorf("msgpack: cannot decode %T into time.Time", item)
+	}
+	v.Set(reflect.ValueOf(t))
+	return nil
+}
+
+func isMsgpackBinOrStr(c byte) bool {
+	return c&0xe0 == 0xa0 || (c >= 0xc4 && c <= 0xc6) || (c >= 0xd9 && c <= 0xdb)
+}
+
+// 沿着索引取字段, 遇到nil的内嵌指针时自动分配
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, idx := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(idx)
+	}
+	return v
+}
+
+func setBytes(v reflect.Value, item interface{}) error {
+	var b []byte
+	switch x := item.(type) {
+	case []byte:
+		b = x
+	case string:
+		b = []byte(x)
+	}
+	if v.Kind() == reflect.Slice {
+		v.SetBytes(b)
+		return nil
+	}
+	if len(b) > v.Len() {
+		return fmt.Errorf("msgpack: %d bytes overflows %s", len(b), v.Type())
+	}
+	reflect.Copy(v, reflect.ValueOf(b))
+	return nil
+}
+
+// 将解码得到的标量赋值给目标, 并检查数值是否溢出
+func setScalar(v reflect.Value, item interface{}) error {
+	mismatch := func() error {
+		return fmt.Errorf("msgpack: cannot decode %T into %s", item, v.Type())
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		b, ok := item.(bool)
+		if !ok {
+			return mismatch()
+		}
+		v.SetBool(b)
+	case reflect.String:
+		switch x := item.(type) {
+		case string:
+			v.SetString(x)
+		case []byte:
+			v.SetString(string(x))
+		default:
+			return mismatch()
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		switch x := item.(type) {
+		case int64:
+			n = x
+		case uint64:
+			return fmt.Errorf("msgpack: %d overflows %s", x, v.Type())
+		default:
+			return mismatch()
+		}
+		if v.OverflowInt(n) {
+			return fmt.Errorf("msgpack: %d overflows %s", n, v.Type())
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var n uint64
+		switch x := item.(type) {
+		case uint64:
+			n = x
+		case int64:
+			if x < 0 {
+				return fmt.Errorf("msgpack: %d overflows %s", x, v.Type())
+			}
+			n = uint64(x)
+		default:
+			return mismatch()
+		}
+		if v.OverflowUint(n) {
+			return fmt.Errorf("msgpack: %d overflows %s", n, v.Type())
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		switch x := item.(type) {
+		case float64:
+			v.SetFloat(x)
+		case float32:
+			v.SetFloat(float64(x))
+		case int64:
+			v.SetFloat(float64(x))
+		case uint64:
+			v.SetFloat(float64(x))
+		default:
+			return mismatch()
+		}
+	default:
+		if item == nil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		iv := reflect.ValueOf(item)
+		if !iv.Type().AssignableTo(v.Type()) {
+			return mismatch()
+		}
+		v.Set(iv)
+	}
+	return nil
+}
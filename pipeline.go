@@ -0,0 +1,274 @@
+package HiHttp
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HTTP/1.1 流水线(RFC 7230 6.3.2): 在同一连接上连续发送多个请求, 按发送顺序(FIFO)匹配响应;
+// 连接中途断开时, 尚未收到响应的幂等请求会重新排队并在新连接上发送
+
+// 单个请求因连接断开而重新发送的最大次数
+const pipelineMaxAttempts = 3
+
+type pipeCall struct {
+	req       *Request
+	head      []byte        // 序列化后的请求行与报文头
+	body      []byte        // 请求体
+	exclusive bool          // 非幂等请求, 发送前需等待连接上的请求全部完成, 且发送后不再追加请求
+	attempts  int           // 已发送的次数
+	done      chan Response // 缓冲为1, 接收响应
+	abandoned int32         // 调用方已因超时或取消放弃等待
+}
+
+func (c *pipeCall) finish(res Response) {
+	select {
+	case c.done <- res:
+	default:
+	}
+}
+
+type pipeline struct {
+	h       *origin
+	depth   int
+	lock    sync.Mutex
+	cond    *sync.Cond
+	queue   []*pipeCall // 等待发送的请求
+	sent    []*pipeCall // 已发送等待响应的请求, 按发送顺序排列
+	conn    net.Conn    // 当前连接, 未建立时为nil
+	uses    int         // 当前连接已完成的请求数
+	writing bool        // 发送协程是否在运行
+	closes  int         // close 的次数, 用于发现建立连接期间的关闭
+}
+
+func newPipeline(h *origin, depth int) *pipeline {
+	p := &pipeline{h: h, depth: depth}
+	p.cond = sync.NewCond(&p.lock)
+	return p
+}
+
+// 将请求加入发送队列并等待响应
+func (p *pipeline) do(req *Request) Response {
+	// 调用方放弃等待后发送协程仍可能在写出, 报文头不使用缓冲池
+	head, body, err := req.appendHead(nil)
+	if err != nil {
+		return Response{Error: err}
+	}
+	call := &pipeCall{
+		req:       req,
+		head:      head,
+		body:      body,
+		exclusive: !isIdempotent(req.Method),
+		done:      make(chan Response, 1),
+	}
+	p.lock.Lock()
+	p.queue = append(p.queue, call)
+	p.startWriter()
+	p.cond.Broadcast()
+	p.lock.Unlock()
+
+	deadline, timeoutErr := req.attemptDeadline()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case res := <-call.done:
+		return res
+	case <-timer.C:
+		p.abandon(call)
+		return Response{Status: BAD_REQUEST, Error: timeoutErr}
+	case <-p.h.ctx.Done():
+		p.abandon(call)
+		return Response{Status: BAD_REQUEST, Error: ErrRequestCanceled}
+	}
+}
+
+// 调用方放弃等待: 未发送的请求直接移出队列; 已发送的请求其响应到达后被丢弃
+func (p *pipeline) abandon(call *pipeCall) {
+	atomic.StoreInt32(&call.abandoned, 1)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for i, c := range p.queue {
+		if c == call {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			break
+		}
+	}
+}
+
+// 调用时需持有锁
+func (p *pipeline) startWriter() {
+	if !p.writing && len(p.queue) > 0 {
+		p.writing = true
+		go p.writeLoop()
+	}
+}
+
+// 是否可以在当前连接上发送队首的请求, 调用时需持有锁
+func (p *pipeline) canSend() bool {
+	if p.conn == nil {
+		return true
+	}
+	if len(p.sent) == 0 {
+		return true
+	}
+	if len(p.sent) >= p.depth || p.queue[0].exclusive {
+		return false
+	}
+	return !p.sent[len(p.sent)-1].exclusive
+}
+
+// 发送协程: 在深度限制内依次写出队列中的请求, 队列为空时退出
+func (p *pipeline) writeLoop() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for {
+		for len(p.queue) > 0 && !p.canSend() {
+			p.cond.Wait()
+		}
+		if len(p.queue) == 0 {
+			p.writing = false
+			return
+		}
+		if p.conn == nil {
+			req, closes := p.queue[0].req, p.closes
+			p.lock.Unlock()
+			conn, err := p.h.dialConn(alpnHTTP1, req)
+			p.lock.Lock()
+			if p.closes != closes {
+				// 建立连接期间已关闭, 发起连接的请求已结束; 之后加入队列的请求重新建立连接
+				if err == nil {
+					_ = conn.Close()
+				}
+				continue
+			}
+			if err != nil {
+				// 连接失败, 排队中的请求全部失败
+				for _, call := range p.queue {
+					call.finish(Response{Error: connectError(err)})
+				}
+				p.queue = nil
+				p.writing = false
+				return
+			}
+			p.conn, p.uses = conn, 0
+			go p.readLoop(conn)
+			continue
+		}
+		conn := p.conn
+		// 复用空闲连接前检查是否已被服务端关闭
+		if len(p.sent) == 0 && p.uses > 0 && !connAlive(conn) {
+			p.drop(conn, ErrConnClosed)
+			continue
+		}
+		call := p.queue[0]
+		p.queue = p.queue[1:]
+		if atomic.LoadInt32(&call.abandoned) == 1 {
+			continue
+		}
+		call.attempts++
+		p.sent = append(p.sent, call)
+		p.cond.Broadcast()
+		p.lock.Unlock()
+		deadline, _ := call.req.phaseDeadline(0, call.req.WriteTimeout, ErrRequestTimeout)
+		_, err := writeWithRate(conn, net.Buffers{call.head, call.body}, deadline, newRateMeter(call.req.MinRate))
+		p.lock.Lock()
+		if err != nil {
+			p.drop(conn, If(err == ErrTransferTooSlow, err, ErrConnClosed).(error))
+		}
+	}
+}
+
+// 读取协程: 按发送顺序读取响应并交给对应的请求, 连接断开后退出
+func (p *pipeline) readLoop(conn net.Conn) {
+	rd := newConnReader(conn)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	defer rd.release()
+	for {
+		for len(p.sent) == 0 && p.conn == conn {
+			p.cond.Wait()
+		}
+		if p.conn != conn {
+			return
+		}
+		call := p.sent[0]
+		p.lock.Unlock()
+		res, err := rd.readResponse(call.req)
+		p.lock.Lock()
+		if p.conn != conn {
+			return
+		}
+		if err != nil {
+			p.h.printLog("pipeline read err:", err)
+			p.drop(conn, err)
+			return
+		}
+		p.sent = p.sent[1:]
+		p.uses++
+		call.finish(res)
+		if res.Close {
+			// 服务端不会再响应后续的请求
+			p.drop(conn, ErrConnClosed)
+			return
+		}
+		p.cond.Broadcast()
+	}
+}
+
+// 关闭连接, 已发送但未收到响应的幂等请求重新排到队首, 其余请求以err结束; 调用时需持有锁
+func (p *pipeline) drop(conn net.Conn, err error) {
+	if p.conn != conn {
+		return
+	}
+	_ = conn.Close()
+	p.conn = nil
+	var requeue []*pipeCall
+	for _, call := range p.sent {
+		if atomic.LoadInt32(&call.abandoned) == 1 {
+			continue
+		}
+		if isIdempotent(call.req.Method) && call.attempts < pipelineMaxAttempts {
+			requeue = append(requeue, call)
+			continue
+		}
+		call.finish(Response{Status: BAD_REQUEST, Error: err})
+	}
+	if len(requeue) > 0 {
+		p.h.printLog("\n△ pipeline requeue", len(requeue), "requests")
+	}
+	p.sent = nil
+	p.queue = append(requeue, p.queue...)
+	p.startWriter()
+	p.cond.Broadcast()
+}
+
+// 接管一个已建立的连接, 例: ALPN协商为HTTP/1.1的连接
+func (p *pipeline) adopt(conn net.Conn) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.conn != nil {
+		_ = conn.Close()
+		return
+	}
+	p.conn, p.uses = conn, 0
+	go p.readLoop(conn)
+	p.cond.Broadcast()
+}
+
+// 关闭当前连接, 排队与已发送的请求以 ErrRequestCanceled 结束而不再重发; 之后的请求会建立新连接
+func (p *pipeline) close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.conn != nil {
+		_ = p.conn.Close()
+		p.conn = nil
+	}
+	for _, call := range append(p.sent, p.queue...) {
+		call.finish(Response{Status: BAD_REQUEST, Error: ErrRequestCanceled})
+	}
+	p.sent, p.queue = nil, nil
+	p.closes++
+	p.cond.Broadcast()
+}
@@ -0,0 +1,283 @@
+package HiHttp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 批量读取请求的测试服务: 每个连接上先读取 batch 个请求(或等待超时)再依次响应,
+// 只有真正流水线发送的请求才能在一个批次内被读到
+type batchServer struct {
+	listener net.Listener
+	batch    int
+	conns    int32
+	maxBatch int32 // 单个批次内读到的最多请求数
+	methods  []string
+	lock     sync.Mutex
+	respond  func(req *http.Request, conn, n int) (raw string, close bool)
+}
+
+func newBatchServer(t *testing.T, batch int, respond func(req *http.Request, conn, n int) (string, bool)) *batchServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &batchServer{listener: listener, batch: batch, respond: respond}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, int(atomic.AddInt32(&s.conns, 1)))
+		}
+	}()
+	return s
+}
+
+func (s *batchServer) serve(conn net.Conn, id int) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for n := 0; ; {
+		var reqs []*http.Request
+		for len(reqs) < s.batch {
+			if len(reqs) > 0 {
+				_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			} else {
+				_ = conn.SetReadDeadline(time.Time{})
+			}
+			req, err := http.ReadRequest(reader)
+			if err != nil {
+				break
+			}
+			reqs = append(reqs, req)
+		}
+		if len(reqs) == 0 {
+			return
+		}
+		for {
+			max := atomic.LoadInt32(&s.maxBatch)
+			if int32(len(reqs)) <= max || atomic.CompareAndSwapInt32(&s.maxBatch, max, int32(len(reqs))) {
+				break
+			}
+		}
+		for _, req := range reqs {
+			s.lock.Lock()
+			s.methods = append(s.methods, req.Method)
+			s.lock.Unlock()
+			raw, close := s.respond(req, id, n)
+			n++
+			if raw == "" {
+				return
+			}
+			if _, err := conn.Write([]byte(raw)); err != nil || close {
+				return
+			}
+		}
+	}
+}
+
+func (s *batchServer) URL() string {
+	return "http://" + s.listener.Addr().String()
+}
+
+func (s *batchServer) Close() {
+	_ = s.listener.Close()
+}
+
+func echoPath(req *http.Request) string {
+	return fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(req.URL.Path), req.URL.Path)
+}
+
+// 并发发送多个请求
+func getAll(client HttpClient, paths []string) ([]string, []error) {
+	results := make([]string, len(paths))
+	errs := make([]error, len(paths))
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			results[i], errs[i] = client.Get(path)
+		}(i, path)
+	}
+	wg.Wait()
+	return results, errs
+}
+
+// 测试多个请求在同一连接上流水线发送, 并按顺序匹配响应
+func TestHttpClient_Pipeline(t *testing.T) {
+	server := newBatchServer(t, 3, func(req *http.Request, _, _ int) (string, bool) {
+		return echoPath(req), false
+	})
+	defer server.Close()
+	client, err := HiHttp(context.Background(), server.URL(), Options{Pipeline: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	paths := []string{"/a", "/bb", "/ccc"}
+	results, errs := getAll(client, paths)
+	for i, path := range paths {
+		if errs[i] != nil || results[i] != path {
+			t.Fatalf("request %d: %q, %v", i, results[i], errs[i])
+		}
+	}
+	if conns := atomic.LoadInt32(&server.conns); conns != 1 {
+		t.Fatalf("server accepted %d connections, want 1", conns)
+	}
+	if batch := atomic.LoadInt32(&server.maxBatch); batch != 3 {
+		t.Fatalf("server read %d pipelined requests, want 3", batch)
+	}
+}
+
+// 测试流水线深度限制以及非幂等请求不参与流水线
+func TestHttpClient_Pipeline_Depth(t *testing.T) {
+	server := newBatchServer(t, 8, func(req *http.Request, _, _ int) (string, bool) {
+		return echoPath(req), false
+	})
+	defer server.Close()
+	client, err := HiHttp(context.Background(), server.URL(), Options{Pipeline: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	paths := []string{"/1", "/2", "/3", "/4", "/5"}
+	results, errs := getAll(client, paths)
+	for i, path := range paths {
+		if errs[i] != nil || results[i] != path {
+			t.Fatalf("request %d: %q, %v", i, results[i], errs[i])
+		}
+	}
+	if batch := atomic.LoadInt32(&server.maxBatch); batch != 2 {
+		t.Fatalf("server read %d pipelined requests, want 2", batch)
+	}
+
+	atomic.StoreInt32(&server.maxBatch, 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path := fmt.Sprintf("/post%d", i)
+			if result, err := client.Post(path, strings.NewReader("x")); err != nil || result != path {
+				t.Errorf("post %d: %q, %v", i, result, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if batch := atomic.LoadInt32(&server.maxBatch); batch != 1 {
+		t.Fatalf("POST requests were pipelined, batch %d", batch)
+	}
+}
+
+// 测试服务端中途关闭连接时, 未收到响应的请求在新连接上重新发送
+func TestHttpClient_Pipeline_Recover(t *testing.T) {
+	server := newBatchServer(t, 3, func(req *http.Request, conn, n int) (string, bool) {
+		if conn == 1 && n > 0 {
+			return "", true
+		}
+		if conn == 2 && n == 0 {
+			return "HTTP/1.1 200 OK\r\nContent-Length: " + fmt.Sprint(len(req.URL.Path)) + "\r\nConnection: close\r\n\r\n" + req.URL.Path, true
+		}
+		return echoPath(req), false
+	})
+	defer server.Close()
+	client, err := HiHttp(context.Background(), server.URL(), Options{Pipeline: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	paths := []string{"/x", "/y", "/z"}
+	results, errs := getAll(client, paths)
+	for i, path := range paths {
+		if errs[i] != nil || results[i] != path {
+			t.Fatalf("request %d: %q, %v", i, results[i], errs[i])
+		}
+	}
+	if conns := atomic.LoadInt32(&server.conns); conns != 3 {
+		t.Fatalf("server accepted %d connections, want 3", conns)
+	}
+	if _, err = HiHttp(context.Background(), server.URL(), Options{Pipeline: 2, Version: HTTP10}); err == nil {
+		t.Fatal("expected pipelining to require HTTP/1.1")
+	}
+}
+
+// 测试关闭客户端时未完成的请求以取消结束, 而不是在新连接上重新发送
+func TestHttpClient_Pipeline_End(t *testing.T) {
+	release := make(chan struct{})
+	server := newBatchServer(t, 1, func(req *http.Request, _, _ int) (string, bool) {
+		<-release
+		return "", true
+	})
+	defer server.Close()
+	defer close(release)
+	client, err := HiHttp(context.Background(), server.URL(), Options{Pipeline: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Get("/hang")
+		done <- err
+	}()
+	for atomic.LoadInt32(&server.conns) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	client.End()
+	select {
+	case err := <-done:
+		if err != ErrRequestCanceled {
+			t.Fatalf("expected ErrRequestCanceled, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("request still pending after End")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if conns := atomic.LoadInt32(&server.conns); conns != 1 {
+		t.Fatalf("server accepted %d connections after End, want 1", conns)
+	}
+}
+
+// 测试建立连接期间关闭客户端时, 之后建立的连接被关闭而不是泄漏
+func TestHttpClient_Pipeline_End_Dialing(t *testing.T) {
+	dialing, release := make(chan struct{}), make(chan struct{})
+	server := make(chan net.Conn, 1)
+	dialer := DialerFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		close(dialing)
+		<-release
+		c1, c2 := net.Pipe()
+		server <- c2
+		return c1, nil
+	})
+	client, err := HiHttp(context.Background(), "http://localhost:888", Options{Pipeline: 2, Dialer: dialer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Get("/")
+		done <- err
+	}()
+	<-dialing
+	client.End()
+	if err := <-done; err != ErrRequestCanceled {
+		t.Fatalf("expected ErrRequestCanceled, got %v", err)
+	}
+	close(release)
+	conn := <-server
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("connection dialed before End was not closed: %v", err)
+	}
+}
@@ -0,0 +1,1666 @@
+package HiHttp
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// QUIC v1 传输(RFC 9000 / RFC 9001), 供HTTP/3使用, 实验性:
+// TLS 1.3 握手由 crypto/tls 的QUIC接口完成; 仅支持 AES-GCM 套件, 不支持 0-RTT、Retry、密钥更新与连接迁移
+
+// Errors
+var (
+	ErrQuicHandshake   = errors.New("quic handshake failed")
+	ErrQuicClosed      = errors.New("quic connection closed")
+	ErrQuicStreamReset = errors.New("quic stream reset by peer")
+	errQuicMalformed   = errors.New("quic: malformed packet or frame")
+)
+
+const quicVersion1 = 0x00000001
+
+// RFC 9001 5.2
+var quicInitialSalt = []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a}
+
+// 默认配置
+const (
+	quicMaxDatagram  = 1200 // 发送的UDP数据报大小, 也是客户端Initial包的最小长度
+	quicConnIDLen    = 8
+	quicStreamWindow = 4 << 20 // 本端的流接收窗口
+	quicConnWindow   = 16 << 20
+	quicMaxStreams   = 100 // 允许对端同时打开的流数量
+	quicIdleTimeout  = 30 * time.Second
+	quicInitialRTT   = 100 * time.Millisecond
+	quicMaxAckDelay  = 25 * time.Millisecond
+	quicInitialCwnd  = 10 * quicMaxDatagram
+	quicMinCwnd      = 2 * quicMaxDatagram
+	quicMaxCwnd      = 16 << 20
+)
+
+// 传输错误码
+const (
+	quicNoError           = 0x0
+	quicFlowControlError  = 0x3
+	quicStreamLimitError  = 0x4
+	quicStreamStateError  = 0x5
+	quicFinalSizeError    = 0x6
+	quicProtocolViolation = 0xa
+)
+
+// 包号空间
+const (
+	quicSpaceInitial = iota
+	quicSpaceHandshake
+	quicSpaceApp
+)
+
+/// -------------------------------- 变长整数 --------------------------------
+
+func appendVarint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return append(b, 0x40|byte(v>>8), byte(v))
+	case v < 1<<30:
+		return append(b, 0x80|byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+	return append(b, 0xc0|byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func readVarint(b []byte) (uint64, []byte, error) {
+	if len(b) == 0 {
+		return 0, nil, errQuicMalformed
+	}
+	n := 1 << (b[0] >> 6)
+	if len(b) < n {
+		return 0, nil, errQuicMalformed
+	}
+	v := uint64(b[0] & 0x3f)
+	for i := 1; i < n; i++ {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, b[n:], nil
+}
+
+// 从字节流中读取变长整数
+func readVarintFrom(r io.ByteReader) (uint64, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	v := uint64(first & 0x3f)
+	for i := 1; i < 1<<(first>>6); i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		v = v<<8 | uint64(b)
+	}
+	return v, nil
+}
+
+// 顺序解析帧的游标, 出错后的读取均返回零值
+type quicReader struct {
+	b   []byte
+	err error
+}
+
+func (r *quicReader) varint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, rest, err := readVarint(r.b)
+	if err != nil {
+		r.err = err
+		return 0
+	}
+	r.b = rest
+	return v
+}
+
+func (r *quicReader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.b)) {
+		r.err = errQuicMalformed
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+/// -------------------------------- 包保护 --------------------------------
+
+type quicKeys struct {
+	aead cipher.AEAD
+	iv   []byte
+	hp   cipher.Block // 头部保护
+}
+
+func newQuicKeys(suite uint16, secret []byte) (*quicKeys, error) {
+	var h func() hash.Hash
+	var keyLen int
+	switch suite {
+	case tls.TLS_AES_128_GCM_SHA256:
+		h, keyLen = sha256.New, 16
+	case tls.TLS_AES_256_GCM_SHA384:
+		h, keyLen = sha512.New384, 32
+	default:
+		return nil, fmt.Errorf("%w: unsupported cipher suite %s", ErrQuicHandshake, tls.CipherSuiteName(suite))
+	}
+	block, err := aes.NewCipher(hkdfExpandLabel(h, secret, "quic key", keyLen))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	hp, err := aes.NewCipher(hkdfExpandLabel(h, secret, "quic hp", keyLen))
+	if err != nil {
+		return nil, err
+	}
+	return &quicKeys{aead: aead, iv: hkdfExpandLabel(h, secret, "quic iv", 12), hp: hp}, nil
+}
+
+// 由客户端选择的目标连接ID派生Initial密钥, 返回本端的加密与解密密钥
+func quicInitialKeys(dcid []byte, client bool) (seal, open *quicKeys) {
+	initial := hkdfExtract(sha256.New, dcid, quicInitialSalt)
+	clientKeys, _ := newQuicKeys(tls.TLS_AES_128_GCM_SHA256, hkdfExpandLabel(sha256.New, initial, "client in", 32))
+	serverKeys, _ := newQuicKeys(tls.TLS_AES_128_GCM_SHA256, hkdfExpandLabel(sha256.New, initial, "server in", 32))
+	if client {
+		return clientKeys, serverKeys
+	}
+	return serverKeys, clientKeys
+}
+
+func hkdfExtract(h func() hash.Hash, secret, salt []byte) []byte {
+	mac := hmac.New(h, salt)
+	mac.Write(secret)
+	return mac.Sum(nil)
+}
+
+// TLS 1.3 HKDF-Expand-Label (RFC 8446 7.1), 上下文为空
+func hkdfExpandLabel(h func() hash.Hash, secret []byte, label string, length int) []byte {
+	label = "tls13 " + label
+	info := appendUint16(nil, uint16(length))
+	info = append(info, byte(len(label)))
+	info = append(info, label...)
+	info = append(info, 0)
+	var out, t []byte
+	for i := byte(1); len(out) < length; i++ {
+		mac := hmac.New(h, secret)
+		mac.Write(t)
+		mac.Write(info)
+		mac.Write([]byte{i})
+		t = mac.Sum(nil)
+		out = append(out, t...)
+	}
+	return out[:length]
+}
+
+func (k *quicKeys) nonce(pn uint64) []byte {
+	nonce := append([]byte(nil), k.iv...)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * uint(i)))
+	}
+	return nonce
+}
+
+// 加密数据包, hdr 为明文头部, 以4字节包号结尾
+func (k *quicKeys) protect(hdr []byte, pn uint64, payload []byte) []byte {
+	pnOff := len(hdr) - 4
+	pkt := make([]byte, len(hdr), len(hdr)+len(payload)+k.aead.Overhead())
+	copy(pkt, hdr)
+	pkt = k.aead.Seal(pkt, k.nonce(pn), payload, hdr)
+	var mask [16]byte
+	k.hp.Encrypt(mask[:], pkt[pnOff+4:pnOff+20])
+	if pkt[0]&0x80 != 0 {
+		pkt[0] ^= mask[0] & 0x0f
+	} else {
+		pkt[0] ^= mask[0] & 0x1f
+	}
+	for i := 0; i < 4; i++ {
+		pkt[pnOff+i] ^= mask[1+i]
+	}
+	return pkt
+}
+
+// 解除头部保护并解密, pnOff 为包号的偏移
+func (k *quicKeys) unprotect(pkt []byte, pnOff int, largest int64) (uint64, []byte, error) {
+	if len(pkt) < pnOff+20 {
+		return 0, nil, errQuicMalformed
+	}
+	var mask [16]byte
+	k.hp.Encrypt(mask[:], pkt[pnOff+4:pnOff+20])
+	first := pkt[0]
+	if first&0x80 != 0 {
+		first ^= mask[0] & 0x0f
+	} else {
+		first ^= mask[0] & 0x1f
+	}
+	pnLen := int(first&0x03) + 1
+	hdr := append([]byte(nil), pkt[:pnOff+pnLen]...)
+	hdr[0] = first
+	var truncated uint64
+	for i := 0; i < pnLen; i++ {
+		hdr[pnOff+i] ^= mask[1+i]
+		truncated = truncated<<8 | uint64(hdr[pnOff+i])
+	}
+	pn := decodePacketNumber(largest, truncated, uint(pnLen*8))
+	payload, err := k.aead.Open(nil, k.nonce(pn), pkt[pnOff+pnLen:], hdr)
+	return pn, payload, err
+}
+
+// 由截断的包号还原完整包号 (RFC 9000 附录A.3)
+func decodePacketNumber(largest int64, truncated uint64, bits uint) uint64 {
+	expected := uint64(largest + 1)
+	win := uint64(1) << bits
+	hwin, mask := win/2, win-1
+	candidate := (expected &^ mask) | truncated
+	if candidate+hwin <= expected && candidate < (1<<62)-win {
+		return candidate + win
+	}
+	if candidate > expected+hwin && candidate >= win {
+		return candidate - win
+	}
+	return candidate
+}
+
+/// -------------------------------- 连接状态 --------------------------------
+
+// 按偏移重组乱序到达的数据
+type quicReassembler struct {
+	off     uint64 // 已连续收到的数据末尾
+	pending map[uint64][]byte
+}
+
+// 放入一段数据, 返回新的连续数据
+func (r *quicReassembler) push(off uint64, data []byte) (out []byte) {
+	end := off + uint64(len(data))
+	if end <= r.off {
+		return nil
+	}
+	if off > r.off {
+		if r.pending == nil {
+			r.pending = map[uint64][]byte{}
+		}
+		if old, ok := r.pending[off]; !ok || len(old) < len(data) {
+			r.pending[off] = append([]byte(nil), data...)
+		}
+		return nil
+	}
+	out = append(out, data[r.off-off:]...)
+	r.off = end
+	for progress := true; progress; {
+		progress = false
+		for o, d := range r.pending {
+			if o > r.off {
+				continue
+			}
+			delete(r.pending, o)
+			if e := o + uint64(len(d)); e > r.off {
+				out = append(out, d[r.off-o:]...)
+				r.off = e
+			}
+			progress = true
+		}
+	}
+	return out
+}
+
+// 可重传的帧: CRYPTO数据、流数据或已编码的控制帧
+type quicFrame struct {
+	stream *quicStream
+	off    uint64
+	data   []byte
+	fin    bool
+	raw    []byte
+}
+
+type quicSentPacket struct {
+	time   time.Time
+	size   int
+	frames []quicFrame
+}
+
+type quicSpace struct {
+	seal, open  *quicKeys
+	nextPN      uint64
+	largestRecv int64
+	recvRanges  [][2]uint64 // 已收到的包号区间, 按降序排列
+	ackPending  bool
+	pingPending bool
+	sent        map[uint64]*quicSentPacket
+	crypto      []quicFrame // 待发送的CRYPTO数据
+	cryptoOff   uint64      // 下一段新CRYPTO数据的偏移
+	cryptoRecv  quicReassembler
+	discarded   bool
+}
+
+// 记录收到的包号, 重复的包返回false
+func (sp *quicSpace) record(pn uint64) bool {
+	for _, r := range sp.recvRanges {
+		if pn >= r[0] && pn <= r[1] {
+			return false
+		}
+	}
+	sp.recvRanges = append(sp.recvRanges, [2]uint64{pn, pn})
+	sort.Slice(sp.recvRanges, func(i, j int) bool { return sp.recvRanges[i][0] > sp.recvRanges[j][0] })
+	merged := sp.recvRanges[:1]
+	for _, r := range sp.recvRanges[1:] {
+		last := &merged[len(merged)-1]
+		if r[1]+1 >= last[0] {
+			last[0] = r[0]
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	if len(merged) > 32 {
+		merged = merged[:32]
+	}
+	sp.recvRanges = merged
+	if int64(pn) > sp.largestRecv {
+		sp.largestRecv = int64(pn)
+	}
+	return true
+}
+
+func (sp *quicSpace) appendAck(b []byte) []byte {
+	r := sp.recvRanges
+	b = append(b, 0x02)
+	b = appendVarint(b, r[0][1])
+	b = appendVarint(b, 0)
+	b = appendVarint(b, uint64(len(r)-1))
+	b = appendVarint(b, r[0][1]-r[0][0])
+	for i := 1; i < len(r); i++ {
+		b = appendVarint(b, r[i-1][0]-r[i][1]-2)
+		b = appendVarint(b, r[i][1]-r[i][0])
+	}
+	return b
+}
+
+// 对端的传输参数
+type quicParams struct {
+	maxIdle                       time.Duration
+	maxData                       uint64
+	bidiLocal, bidiRemote, uni    uint64
+	maxStreamsBidi, maxStreamsUni uint64
+	origDCID                      []byte
+	hasOrigDCID                   bool
+}
+
+type quicConn struct {
+	isClient bool
+	tls      *tls.QUICConn
+	send     func([]byte) error
+	onClosed func()
+
+	scid, dcid, origDCID []byte
+	gotPeerCID           bool // 客户端已切换到服务端选择的连接ID
+
+	lock        sync.Mutex
+	cond        *sync.Cond
+	recv        chan []byte
+	wake        chan struct{}
+	done        chan struct{}
+	err         error
+	established bool // 握手已完成
+
+	spaces     [3]*quicSpace
+	control    [][]byte // 待发送的控制帧
+	retransmit []quicFrame
+	sendQueue  []*quicStream
+
+	streams         map[uint64]*quicStream
+	accepted        [2][]*quicStream // 对端打开等待接受的流, 下标0为双向流, 1为单向流
+	nextLocal       [2]uint64        // 本端已打开的流数量
+	peerOpened      [2]uint64        // 对端已打开的流数量
+	localMaxStreams [2]uint64        // 允许对端打开的流数量
+	peer            quicParams
+
+	sentData, recvTotal, consumed, recvMaxData uint64
+
+	// 丢包恢复与拥塞控制
+	bytesInFlight, cwnd int
+	srtt, rttvar        time.Duration
+	hasRTT              bool
+	ptoCount            uint
+	lastSent, lastRecv  time.Time
+	recoveryStart       time.Time
+	idleTimeout         time.Duration
+}
+
+func newQuicConn(client bool, config *tls.Config) *quicConn {
+	c := &quicConn{
+		isClient:        client,
+		scid:            randomBytes(quicConnIDLen),
+		recv:            make(chan []byte, 256),
+		wake:            make(chan struct{}, 1),
+		done:            make(chan struct{}),
+		streams:         map[uint64]*quicStream{},
+		localMaxStreams: [2]uint64{quicMaxStreams, quicMaxStreams},
+		recvMaxData:     quicConnWindow,
+		cwnd:            quicInitialCwnd,
+		srtt:            quicInitialRTT,
+		rttvar:          quicInitialRTT / 2,
+		lastRecv:        time.Now(),
+		idleTimeout:     quicIdleTimeout,
+	}
+	c.cond = sync.NewCond(&c.lock)
+	for i := range c.spaces {
+		c.spaces[i] = &quicSpace{largestRecv: -1, sent: map[uint64]*quicSentPacket{}}
+	}
+	config = config.Clone()
+	config.MinVersion = tls.VersionTLS13
+	if client {
+		c.tls = tls.QUICClient(&tls.QUICConfig{TLSConfig: config})
+	} else {
+		c.tls = tls.QUICServer(&tls.QUICConfig{TLSConfig: config})
+	}
+	return c
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return b
+}
+
+// 建立QUIC连接并完成握手
+func dialQuic(addr string, config *tls.Config, timeout time.Duration) (*quicConn, error) {
+	conn, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := newQuicConn(true, config)
+	c.send = func(b []byte) error {
+		_, err := conn.Write(b)
+		return err
+	}
+	c.onClosed = func() { _ = conn.Close() }
+	c.origDCID = randomBytes(quicConnIDLen)
+	c.dcid = c.origDCID
+	c.spaces[quicSpaceInitial].seal, c.spaces[quicSpaceInitial].open = quicInitialKeys(c.origDCID, true)
+	if err = c.start(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	go func() {
+		for {
+			buf := make([]byte, 1500)
+			n, err := conn.Read(buf)
+			if err != nil {
+				c.lock.Lock()
+				c.setErr(fmt.Errorf("%w: %v", ErrQuicClosed, err))
+				c.lock.Unlock()
+				return
+			}
+			select {
+			case c.recv <- buf[:n]:
+			case <-c.done:
+				return
+			}
+		}
+	}()
+	if err = c.waitEstablished(timeout); err != nil {
+		c.close(quicNoError, "")
+		return nil, err
+	}
+	return c, nil
+}
+
+// 启动TLS握手与事件循环
+func (c *quicConn) start() error {
+	c.tls.SetTransportParameters(c.localParams())
+	if err := c.tls.Start(context.Background()); err != nil {
+		return fmt.Errorf("%w: %v", ErrQuicHandshake, err)
+	}
+	c.lock.Lock()
+	err := c.handleTLSEvents()
+	c.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	go c.loop()
+	c.signal()
+	return nil
+}
+
+func (c *quicConn) waitEstablished(timeout time.Duration) error {
+	timer := time.AfterFunc(timeout, func() {
+		c.lock.Lock()
+		c.cond.Broadcast()
+		c.lock.Unlock()
+	})
+	defer timer.Stop()
+	deadline := time.Now().Add(timeout)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for !c.established && c.err == nil && time.Now().Before(deadline) {
+		c.cond.Wait()
+	}
+	switch {
+	case c.established:
+		return nil
+	case c.err != nil:
+		return fmt.Errorf("%w: %v", ErrQuicHandshake, c.err)
+	}
+	return fmt.Errorf("%w: timeout", ErrQuicHandshake)
+}
+
+func (c *quicConn) localParams() []byte {
+	param := func(b []byte, id, val uint64) []byte {
+		b = appendVarint(b, id)
+		b = appendVarint(b, uint64(len(appendVarint(nil, val))))
+		return appendVarint(b, val)
+	}
+	bytesParam := func(b []byte, id uint64, val []byte) []byte {
+		b = appendVarint(b, id)
+		b = appendVarint(b, uint64(len(val)))
+		return append(b, val...)
+	}
+	var p []byte
+	if !c.isClient {
+		p = bytesParam(p, 0x00, c.origDCID)
+	}
+	p = param(p, 0x01, uint64(quicIdleTimeout/time.Millisecond))
+	p = param(p, 0x03, 1452)
+	p = param(p, 0x04, quicConnWindow)
+	p = param(p, 0x05, quicStreamWindow)
+	p = param(p, 0x06, quicStreamWindow)
+	p = param(p, 0x07, quicStreamWindow)
+	p = param(p, 0x08, quicMaxStreams)
+	p = param(p, 0x09, quicMaxStreams)
+	return bytesParam(p, 0x0f, c.scid)
+}
+
+func (c *quicConn) parsePeerParams(data []byte) error {
+	r := &quicReader{b: data}
+	for len(r.b) > 0 && r.err == nil {
+		id := r.varint()
+		val := r.bytes(r.varint())
+		v, _, _ := readVarint(val)
+		switch id {
+		case 0x00:
+			c.peer.origDCID, c.peer.hasOrigDCID = append([]byte(nil), val...), true
+		case 0x01:
+			c.peer.maxIdle = time.Duration(v) * time.Millisecond
+		case 0x04:
+			c.peer.maxData = v
+		case 0x05:
+			c.peer.bidiLocal = v
+		case 0x06:
+			c.peer.bidiRemote = v
+		case 0x07:
+			c.peer.uni = v
+		case 0x08:
+			c.peer.maxStreamsBidi = v
+		case 0x09:
+			c.peer.maxStreamsUni = v
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if c.isClient && (!c.peer.hasOrigDCID || string(c.peer.origDCID) != string(c.origDCID)) {
+		return fmt.Errorf("%w: original_destination_connection_id mismatch", ErrQuicHandshake)
+	}
+	if c.peer.maxIdle > 0 && c.peer.maxIdle < c.idleTimeout {
+		c.idleTimeout = c.peer.maxIdle
+	}
+	return nil
+}
+
+// 处理TLS事件: 安装密钥、发送握手数据, 调用时需持有锁; 握手错误由 Start 与 HandleData 返回
+func (c *quicConn) handleTLSEvents() error {
+	for {
+		e := c.tls.NextEvent()
+		switch e.Kind {
+		case tls.QUICNoEvent:
+			return nil
+		case tls.QUICSetReadSecret, tls.QUICSetWriteSecret:
+			sp := c.levelSpace(e.Level)
+			if sp == nil {
+				continue
+			}
+			keys, err := newQuicKeys(e.Suite, e.Data)
+			if err != nil {
+				return err
+			}
+			if e.Kind == tls.QUICSetReadSecret {
+				sp.open = keys
+			} else {
+				sp.seal = keys
+			}
+		case tls.QUICWriteData:
+			if sp := c.levelSpace(e.Level); sp != nil {
+				data := append([]byte(nil), e.Data...)
+				sp.crypto = append(sp.crypto, quicFrame{off: sp.cryptoOff, data: data})
+				sp.cryptoOff += uint64(len(data))
+			}
+		case tls.QUICTransportParameters:
+			if err := c.parsePeerParams(e.Data); err != nil {
+				return err
+			}
+		case tls.QUICHandshakeDone:
+			c.established = true
+			if !c.isClient {
+				// 服务端握手完成即确认, 通知客户端
+				c.control = append(c.control, []byte{0x1e})
+				c.discard(quicSpaceHandshake)
+			}
+			c.cond.Broadcast()
+		}
+	}
+}
+
+func (c *quicConn) levelSpace(level tls.QUICEncryptionLevel) *quicSpace {
+	switch level {
+	case tls.QUICEncryptionLevelInitial:
+		return c.spaces[quicSpaceInitial]
+	case tls.QUICEncryptionLevelHandshake:
+		return c.spaces[quicSpaceHandshake]
+	case tls.QUICEncryptionLevelApplication:
+		return c.spaces[quicSpaceApp]
+	}
+	return nil
+}
+
+// 丢弃一个包号空间的密钥与未确认的包
+func (c *quicConn) discard(space int) {
+	sp := c.spaces[space]
+	if sp.discarded {
+		return
+	}
+	sp.discarded = true
+	sp.crypto = nil
+	for pn, p := range sp.sent {
+		c.bytesInFlight -= p.size
+		delete(sp.sent, pn)
+	}
+}
+
+// 连接是否可以发送新的请求, 空闲超过一半超时时间的连接可能已被对端关闭
+func (c *quicConn) usable() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err == nil && time.Since(c.lastRecv) < c.idleTimeout/2
+}
+
+func (c *quicConn) connectionState() tls.ConnectionState {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.tls.ConnectionState()
+}
+
+// 唤醒事件循环发送数据
+func (c *quicConn) signal() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// 连接失效, 调用时需持有锁
+func (c *quicConn) setErr(err error) {
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+	_ = c.tls.Close()
+	c.cond.Broadcast()
+	if c.onClosed != nil {
+		go c.onClosed()
+	}
+}
+
+// 发送 CONNECTION_CLOSE 并关闭连接
+func (c *quicConn) close(code uint64, reason string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err != nil {
+		return
+	}
+	for space := quicSpaceApp; space >= quicSpaceInitial; space-- {
+		sp := c.spaces[space]
+		if sp.seal == nil || sp.discarded || (space == quicSpaceApp && !c.established) {
+			continue
+		}
+		var payload []byte
+		if space == quicSpaceApp {
+			payload = appendVarint(append(payload, 0x1d), code)
+		} else {
+			// 握手阶段只能发送传输层的关闭帧
+			payload = appendVarint(append(payload, 0x1c), quicNoError)
+			payload = appendVarint(payload, 0)
+		}
+		payload = appendVarint(payload, uint64(len(reason)))
+		payload = append(payload, reason...)
+		_ = c.send(c.sealPacket(space, payload))
+		break
+	}
+	c.setErr(ErrQuicClosed)
+}
+
+/// -------------------------------- 事件循环 --------------------------------
+
+func (c *quicConn) loop() {
+	for {
+		c.lock.Lock()
+		wait := c.nextTimeout()
+		c.lock.Unlock()
+		timer := time.NewTimer(wait)
+		select {
+		case data := <-c.recv:
+			c.lock.Lock()
+			c.handleDatagram(data)
+			// 一次处理所有已到达的数据报, 减少ACK数量
+			for more := true; more; {
+				select {
+				case data = <-c.recv:
+					c.handleDatagram(data)
+				default:
+					more = false
+				}
+			}
+			c.flush()
+			c.lock.Unlock()
+		case <-c.wake:
+			c.lock.Lock()
+			c.flush()
+			c.lock.Unlock()
+		case <-timer.C:
+			c.lock.Lock()
+			c.onTimeout()
+			c.flush()
+			c.lock.Unlock()
+		case <-c.done:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+func (c *quicConn) pto() time.Duration {
+	return (c.srtt + 4*c.rttvar + quicMaxAckDelay) << c.ptoCount
+}
+
+// 距离下一个超时(重传或空闲)的时间, 调用时需持有锁
+func (c *quicConn) nextTimeout() time.Duration {
+	wait := time.Until(c.lastRecv.Add(c.idleTimeout))
+	if c.bytesInFlight > 0 {
+		if d := time.Until(c.lastSent.Add(c.pto())); d < wait {
+			wait = d
+		}
+	}
+	if wait < time.Millisecond {
+		wait = time.Millisecond
+	}
+	return wait
+}
+
+func (c *quicConn) onTimeout() {
+	if c.err != nil {
+		return
+	}
+	now := time.Now()
+	if now.Sub(c.lastRecv) >= c.idleTimeout {
+		c.setErr(fmt.Errorf("%w: idle timeout", ErrQuicClosed))
+		return
+	}
+	if c.bytesInFlight <= 0 || now.Before(c.lastSent.Add(c.pto())) {
+		return
+	}
+	// 探测超时: 未确认的包全部视为丢失并重传
+	c.ptoCount++
+	for _, sp := range c.spaces {
+		requeued := false
+		for pn, p := range sp.sent {
+			c.lost(sp, p)
+			delete(sp.sent, pn)
+			requeued = true
+		}
+		if requeued && sp.seal != nil && !sp.discarded {
+			sp.pingPending = true
+		}
+	}
+	c.congested(now)
+}
+
+// 检测到丢包, 拥塞窗口减半; 同一恢复期内(恢复期开始前发送的包丢失)只减一次
+func (c *quicConn) congested(now time.Time) {
+	if !c.recoveryStart.IsZero() && now.Sub(c.recoveryStart) < c.srtt {
+		return
+	}
+	c.recoveryStart = now
+	if c.cwnd /= 2; c.cwnd < quicMinCwnd {
+		c.cwnd = quicMinCwnd
+	}
+}
+
+// 将丢失的包中的帧重新排队
+func (c *quicConn) lost(sp *quicSpace, p *quicSentPacket) {
+	c.bytesInFlight -= p.size
+	for _, f := range p.frames {
+		switch {
+		case f.raw != nil:
+			c.control = append(c.control, f.raw)
+		case f.stream != nil:
+			if f.stream.sendErr == nil {
+				c.retransmit = append(c.retransmit, f)
+			}
+		case !sp.discarded:
+			sp.crypto = append([]quicFrame{f}, sp.crypto...)
+		}
+	}
+}
+
+/// -------------------------------- 接收 --------------------------------
+
+func (c *quicConn) handleDatagram(data []byte) {
+	for len(data) > 0 && c.err == nil {
+		var space, pnOff int
+		var pkt, scid []byte
+		if data[0]&0x80 != 0 {
+			if len(data) < 7 || binary.BigEndian.Uint32(data[1:5]) != quicVersion1 {
+				return
+			}
+			switch (data[0] >> 4) & 0x03 {
+			case 0x00:
+				space = quicSpaceInitial
+			case 0x02:
+				space = quicSpaceHandshake
+			default:
+				// 不支持 0-RTT 与 Retry
+				return
+			}
+			r := &quicReader{b: data[5:]}
+			r.bytes(uint64(data[5]) + 1)
+			if r.err == nil && len(r.b) > 0 {
+				if b := r.bytes(uint64(r.b[0]) + 1); r.err == nil {
+					scid = b[1:]
+				}
+			}
+			if space == quicSpaceInitial {
+				r.bytes(r.varint())
+			}
+			length := r.varint()
+			if r.err != nil || length > uint64(len(r.b)) {
+				return
+			}
+			pnOff = len(data) - len(r.b)
+			pkt, data = data[:pnOff+int(length)], data[pnOff+int(length):]
+		} else {
+			space, pnOff = quicSpaceApp, 1+len(c.scid)
+			pkt, data = data, nil
+		}
+		sp := c.spaces[space]
+		if sp.open == nil || sp.discarded {
+			continue
+		}
+		pn, payload, err := sp.open.unprotect(pkt, pnOff, sp.largestRecv)
+		if err != nil || !sp.record(pn) {
+			continue
+		}
+		c.lastRecv = time.Now()
+		if c.isClient && !c.gotPeerCID && space == quicSpaceInitial {
+			// 第一个成功解密的Initial包之后使用服务端选择的连接ID(RFC 9000 7.2), 伪造或损坏的包不会改变它
+			c.dcid, c.gotPeerCID = append([]byte(nil), scid...), true
+		}
+		if space == quicSpaceHandshake && !c.isClient {
+			// 服务端收到Handshake包后不再需要Initial密钥
+			c.discard(quicSpaceInitial)
+		}
+		if err = c.handleFrames(sp, space, payload); err != nil {
+			c.closeWithError(err)
+			return
+		}
+	}
+}
+
+// 协议错误: 以传输错误码关闭连接
+func (c *quicConn) closeWithError(err error) {
+	code := uint64(quicProtocolViolation)
+	var qe *quicTransportError
+	if errors.As(err, &qe) {
+		code = qe.code
+	}
+	for space := quicSpaceApp; space >= quicSpaceInitial; space-- {
+		sp := c.spaces[space]
+		if sp.seal == nil || sp.discarded {
+			continue
+		}
+		payload := appendVarint(append([]byte(nil), 0x1c), code)
+		payload = append(appendVarint(payload, 0), 0)
+		_ = c.send(c.sealPacket(space, payload))
+		break
+	}
+	c.setErr(fmt.Errorf("%w: %v", ErrQuicClosed, err))
+}
+
+type quicTransportError struct {
+	code   uint64
+	reason string
+}
+
+func (e *quicTransportError) Error() string {
+	return fmt.Sprintf("quic transport error %#x: %s", e.code, e.reason)
+}
+
+func (c *quicConn) handleFrames(sp *quicSpace, space int, payload []byte) error {
+	r := &quicReader{b: payload}
+	eliciting := false
+	for len(r.b) > 0 && r.err == nil {
+		typ := r.varint()
+		switch typ {
+		case 0x00, 0x02, 0x03, 0x1c, 0x1d:
+		default:
+			eliciting = true
+		}
+		// 握手阶段只允许少数几种帧
+		if space != quicSpaceApp && typ > 0x03 && typ != 0x06 && typ != 0x1c {
+			return &quicTransportError{quicProtocolViolation, "frame not allowed in handshake"}
+		}
+		switch {
+		case typ == 0x00, typ == 0x01:
+			// PADDING, PING
+		case typ == 0x02 || typ == 0x03:
+			largest, _, count, first := r.varint(), r.varint(), r.varint(), r.varint()
+			if r.err != nil || first > largest {
+				return errQuicMalformed
+			}
+			lo := largest - first
+			ranges := [][2]uint64{{lo, largest}}
+			for i := uint64(0); i < count && r.err == nil; i++ {
+				gap, length := r.varint(), r.varint()
+				if lo < gap+2 || lo-gap-2 < length {
+					return errQuicMalformed
+				}
+				hi := lo - gap - 2
+				lo = hi - length
+				ranges = append(ranges, [2]uint64{lo, hi})
+			}
+			if typ == 0x03 {
+				r.varint()
+				r.varint()
+				r.varint()
+			}
+			if r.err == nil {
+				c.onAck(sp, largest, ranges)
+			}
+		case typ == 0x04:
+			id, code, final := r.varint(), r.varint(), r.varint()
+			if r.err == nil {
+				if err := c.onResetStream(id, code, final); err != nil {
+					return err
+				}
+			}
+		case typ == 0x05:
+			id, code := r.varint(), r.varint()
+			if r.err == nil {
+				c.onStopSending(id, code)
+			}
+		case typ == 0x06:
+			off := r.varint()
+			data := r.bytes(r.varint())
+			if r.err == nil {
+				if err := c.onCrypto(sp, space, off, data); err != nil {
+					return err
+				}
+			}
+		case typ == 0x07:
+			r.bytes(r.varint())
+		case typ >= 0x08 && typ <= 0x0f:
+			id := r.varint()
+			var off uint64
+			if typ&0x04 != 0 {
+				off = r.varint()
+			}
+			var data []byte
+			if typ&0x02 != 0 {
+				data = r.bytes(r.varint())
+			} else {
+				data, r.b = r.b, nil
+			}
+			if r.err == nil {
+				if err := c.onStream(id, off, data, typ&0x01 != 0); err != nil {
+					return err
+				}
+			}
+		case typ == 0x10:
+			if v := r.varint(); v > c.peer.maxData {
+				c.peer.maxData = v
+				c.requeueBlocked()
+			}
+		case typ == 0x11:
+			id, max := r.varint(), r.varint()
+			if s := c.streams[id]; s != nil && max > s.sendMax {
+				s.sendMax = max
+				c.queueStream(s)
+			}
+		case typ == 0x12, typ == 0x13:
+			if v, uni := r.varint(), typ == 0x13; uni && v > c.peer.maxStreamsUni {
+				c.peer.maxStreamsUni = v
+			} else if !uni && v > c.peer.maxStreamsBidi {
+				c.peer.maxStreamsBidi = v
+			}
+			c.cond.Broadcast()
+		case typ == 0x14, typ == 0x16, typ == 0x17, typ == 0x19:
+			r.varint()
+		case typ == 0x15:
+			r.varint()
+			r.varint()
+		case typ == 0x18:
+			r.varint()
+			r.varint()
+			n := r.bytes(1)
+			if r.err == nil {
+				r.bytes(uint64(n[0]))
+				r.bytes(16)
+			}
+		case typ == 0x1a:
+			if data := r.bytes(8); r.err == nil {
+				c.control = append(c.control, append([]byte{0x1b}, data...))
+			}
+		case typ == 0x1b:
+			r.bytes(8)
+		case typ == 0x1c || typ == 0x1d:
+			code := r.varint()
+			if typ == 0x1c {
+				r.varint()
+			}
+			reason := r.bytes(r.varint())
+			if r.err == nil {
+				c.setErr(fmt.Errorf("%w by peer: code %#x %s", ErrQuicClosed, code, reason))
+				return nil
+			}
+		case typ == 0x1e:
+			if !c.isClient {
+				return &quicTransportError{quicProtocolViolation, "HANDSHAKE_DONE from client"}
+			}
+			c.discard(quicSpaceHandshake)
+		default:
+			return &quicTransportError{0x7, fmt.Sprintf("unknown frame type %#x", typ)}
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if eliciting {
+		sp.ackPending = true
+	}
+	return nil
+}
+
+func (c *quicConn) onAck(sp *quicSpace, largest uint64, ranges [][2]uint64) {
+	now := time.Now()
+	acked := false
+	for pn, p := range sp.sent {
+		for _, r := range ranges {
+			if pn < r[0] || pn > r[1] {
+				continue
+			}
+			delete(sp.sent, pn)
+			c.bytesInFlight -= p.size
+			acked = true
+			if p.time.After(c.recoveryStart) && c.cwnd < quicMaxCwnd {
+				c.cwnd += p.size
+			}
+			if pn == largest {
+				c.updateRTT(now.Sub(p.time))
+			}
+			break
+		}
+	}
+	if !acked {
+		return
+	}
+	c.ptoCount = 0
+	// 比最大确认包号小3以上仍未确认的包视为丢失
+	for pn, p := range sp.sent {
+		if pn+3 <= largest {
+			if p.time.After(c.recoveryStart) {
+				c.congested(now)
+			}
+			c.lost(sp, p)
+			delete(sp.sent, pn)
+		}
+	}
+}
+
+func (c *quicConn) updateRTT(sample time.Duration) {
+	if !c.hasRTT {
+		c.srtt, c.rttvar, c.hasRTT = sample, sample/2, true
+		return
+	}
+	diff := c.srtt - sample
+	if diff < 0 {
+		diff = -diff
+	}
+	c.rttvar = (3*c.rttvar + diff) / 4
+	c.srtt = (7*c.srtt + sample) / 8
+}
+
+func (c *quicConn) onCrypto(sp *quicSpace, space int, off uint64, data []byte) error {
+	data = sp.cryptoRecv.push(off, data)
+	if len(data) == 0 {
+		return nil
+	}
+	level := []tls.QUICEncryptionLevel{tls.QUICEncryptionLevelInitial, tls.QUICEncryptionLevelHandshake,
+		tls.QUICEncryptionLevelApplication}[space]
+	if err := c.tls.HandleData(level, data); err != nil {
+		return fmt.Errorf("%w: %v", ErrQuicHandshake, err)
+	}
+	return c.handleTLSEvents()
+}
+
+/// -------------------------------- 发送 --------------------------------
+
+// 构造并发送所有待发送的数据, 调用时需持有锁
+func (c *quicConn) flush() {
+	if c.err != nil {
+		return
+	}
+	for space, sp := range c.spaces {
+		for sp.seal != nil && !sp.discarded {
+			pkt := c.buildPacket(space, sp)
+			if pkt == nil {
+				break
+			}
+			if err := c.send(pkt); err != nil {
+				c.setErr(fmt.Errorf("%w: %v", ErrQuicClosed, err))
+				return
+			}
+			if c.isClient && space == quicSpaceHandshake {
+				// 客户端发送Handshake包后不再需要Initial密钥
+				c.discard(quicSpaceInitial)
+			}
+		}
+	}
+}
+
+// 加密一个包, 长包头用于Initial与Handshake空间
+func (c *quicConn) sealPacket(space int, payload []byte) []byte {
+	sp := c.spaces[space]
+	pn := sp.nextPN
+	sp.nextPN++
+	var hdr []byte
+	if space == quicSpaceApp {
+		hdr = append([]byte{0x40 | 0x03}, c.dcid...)
+	} else {
+		typ := byte(0x00)
+		if space == quicSpaceHandshake {
+			typ = 0x02
+		}
+		hdr = append([]byte{0xc0 | typ<<4 | 0x03}, 0, 0, 0, 1)
+		hdr = append(append(hdr, byte(len(c.dcid))), c.dcid...)
+		hdr = append(append(hdr, byte(len(c.scid))), c.scid...)
+		if space == quicSpaceInitial {
+			hdr = append(hdr, 0) // 空token
+		}
+		// 长度字段固定使用2字节变长整数
+		length := uint64(4 + len(payload) + sp.seal.aead.Overhead())
+		hdr = append(hdr, 0x40|byte(length>>8), byte(length))
+	}
+	hdr = appendUint32(hdr, uint32(pn))
+	return sp.seal.protect(hdr, pn, payload)
+}
+
+func (c *quicConn) headerLen(space int) int {
+	if space == quicSpaceApp {
+		return 1 + len(c.dcid) + 4
+	}
+	return 1 + 4 + 1 + len(c.dcid) + 1 + len(c.scid) + 1 + 2 + 4
+}
+
+func (c *quicConn) buildPacket(space int, sp *quicSpace) []byte {
+	budget := quicMaxDatagram - c.headerLen(space) - sp.seal.aead.Overhead()
+	var payload []byte
+	var frames []quicFrame
+	eliciting := false
+	if sp.ackPending && len(sp.recvRanges) > 0 {
+		payload = sp.appendAck(payload)
+		sp.ackPending = false
+	}
+	if c.bytesInFlight < c.cwnd || sp.pingPending {
+		// CRYPTO
+		for len(sp.crypto) > 0 && budget-len(payload) > 32 {
+			f := sp.crypto[0]
+			n := len(f.data)
+			if room := budget - len(payload) - 20; n > room {
+				n = room
+			}
+			payload = appendVarint(append(payload, 0x06), f.off)
+			payload = appendVarint(payload, uint64(n))
+			payload = append(payload, f.data[:n]...)
+			frames = append(frames, quicFrame{off: f.off, data: f.data[:n]})
+			if n < len(f.data) {
+				sp.crypto[0] = quicFrame{off: f.off + uint64(n), data: f.data[n:]}
+			} else {
+				sp.crypto = sp.crypto[1:]
+			}
+		}
+		if space == quicSpaceApp && c.established {
+			payload, frames = c.appendAppFrames(payload, frames, budget)
+		}
+		if sp.pingPending {
+			if len(frames) == 0 {
+				payload = append(payload, 0x01)
+				eliciting = true
+			}
+			sp.pingPending = false
+		}
+	}
+	if len(payload) == 0 {
+		return nil
+	}
+	eliciting = eliciting || len(frames) > 0
+	if c.isClient && space == quicSpaceInitial {
+		// 客户端的Initial包需要填充到最小长度
+		payload = append(payload, make([]byte, budget-len(payload))...)
+	}
+	pn := sp.nextPN
+	pkt := c.sealPacket(space, payload)
+	if eliciting {
+		sp.sent[pn] = &quicSentPacket{time: time.Now(), size: len(pkt), frames: frames}
+		c.bytesInFlight += len(pkt)
+		c.lastSent = time.Now()
+	}
+	return pkt
+}
+
+// 追加控制帧、重传的流数据与新的流数据
+func (c *quicConn) appendAppFrames(payload []byte, frames []quicFrame, budget int) ([]byte, []quicFrame) {
+	for len(c.control) > 0 && budget-len(payload) >= len(c.control[0]) {
+		raw := c.control[0]
+		c.control = c.control[1:]
+		payload = append(payload, raw...)
+		frames = append(frames, quicFrame{raw: raw})
+	}
+	for len(c.retransmit) > 0 && budget-len(payload) > 32 {
+		f := c.retransmit[0]
+		if f.stream.sendErr != nil {
+			c.retransmit = c.retransmit[1:]
+			continue
+		}
+		n := len(f.data)
+		if room := budget - len(payload) - 25; n > room {
+			n = room
+		}
+		fin := f.fin && n == len(f.data)
+		payload = appendStreamFrame(payload, f.stream.id, f.off, f.data[:n], fin)
+		frames = append(frames, quicFrame{stream: f.stream, off: f.off, data: f.data[:n], fin: fin})
+		if n < len(f.data) {
+			c.retransmit[0] = quicFrame{stream: f.stream, off: f.off + uint64(n), data: f.data[n:], fin: f.fin}
+		} else {
+			c.retransmit = c.retransmit[1:]
+		}
+	}
+	for len(c.sendQueue) > 0 && budget-len(payload) > 32 {
+		s := c.sendQueue[0]
+		n := len(s.sendBuf)
+		if limit := s.sendMax - s.sendOff; uint64(n) > limit {
+			n = int(limit)
+		}
+		if limit := c.peer.maxData - c.sentData; uint64(n) > limit {
+			n = int(limit)
+		}
+		if room := budget - len(payload) - 25; n > room {
+			n = room
+		}
+		fin := s.finQueued && !s.finSent && n == len(s.sendBuf)
+		if s.sendErr != nil || (n == 0 && !fin) {
+			// 已重置、没有数据或受流量控制限制, 窗口更新后会重新排队
+			s.queued = false
+			c.sendQueue = c.sendQueue[1:]
+			continue
+		}
+		data := s.sendBuf[:n]
+		payload = appendStreamFrame(payload, s.id, s.sendOff, data, fin)
+		frames = append(frames, quicFrame{stream: s, off: s.sendOff, data: data, fin: fin})
+		s.sendBuf = s.sendBuf[n:]
+		s.sendOff += uint64(n)
+		c.sentData += uint64(n)
+		if fin {
+			s.finSent = true
+			c.maybeDelete(s)
+		}
+		// 轮流发送各个流的数据
+		c.sendQueue = c.sendQueue[1:]
+		if len(s.sendBuf) > 0 || (s.finQueued && !s.finSent) {
+			c.sendQueue = append(c.sendQueue, s)
+		} else {
+			s.queued = false
+		}
+	}
+	return payload, frames
+}
+
+func appendStreamFrame(b []byte, id, off uint64, data []byte, fin bool) []byte {
+	typ := byte(0x08 | 0x04 | 0x02)
+	if fin {
+		typ |= 0x01
+	}
+	b = appendVarint(append(b, typ), id)
+	b = appendVarint(b, off)
+	b = appendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+/// -------------------------------- 流 --------------------------------
+
+type quicStream struct {
+	c  *quicConn
+	id uint64
+	// 发送方向
+	sendBuf            []byte // 尚未发送的数据
+	sendOff            uint64 // sendBuf[0] 的偏移
+	sendMax            uint64 // 对端允许的发送上限
+	finQueued, finSent bool
+	queued             bool // 是否在发送队列中
+	sendErr            error
+	// 接收方向
+	reasm       quicReassembler
+	readBuf     []byte
+	readOff     uint64 // 已被读取的数据量
+	recvHighest uint64
+	recvMax     uint64 // 本端允许的接收上限
+	finalSize   int64  // 未知时为-1
+	recvErr     error
+}
+
+func (c *quicConn) newStream(id uint64) *quicStream {
+	s := &quicStream{c: c, id: id, finalSize: -1, recvMax: quicStreamWindow}
+	local := (id&0x01 == 0) == c.isClient
+	switch {
+	case id&0x02 != 0 && local:
+		s.sendMax = c.peer.uni
+		s.finalSize = 0 // 本端的单向流不会收到数据
+	case id&0x02 != 0:
+		s.finQueued, s.finSent = true, true
+	case local:
+		s.sendMax = c.peer.bidiRemote
+	default:
+		s.sendMax = c.peer.bidiLocal
+	}
+	c.streams[id] = s
+	return s
+}
+
+// 打开一个本端发起的流, 超出对端的流数量限制时等待
+func (c *quicConn) openStream(bidi bool) (*quicStream, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	kind := 0
+	if !bidi {
+		kind = 1
+	}
+	for c.err == nil {
+		limit := c.peer.maxStreamsBidi
+		if !bidi {
+			limit = c.peer.maxStreamsUni
+		}
+		if c.nextLocal[kind] < limit {
+			break
+		}
+		c.cond.Wait()
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	id := c.nextLocal[kind]<<2 | uint64(kind)<<1
+	if !c.isClient {
+		id |= 0x01
+	}
+	c.nextLocal[kind]++
+	return c.newStream(id), nil
+}
+
+// 等待对端打开的流
+func (c *quicConn) acceptStream(bidi bool) (*quicStream, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	kind := 0
+	if !bidi {
+		kind = 1
+	}
+	for len(c.accepted[kind]) == 0 && c.err == nil {
+		c.cond.Wait()
+	}
+	if len(c.accepted[kind]) == 0 {
+		return nil, c.err
+	}
+	s := c.accepted[kind][0]
+	c.accepted[kind] = c.accepted[kind][1:]
+	return s, nil
+}
+
+// 获取接收数据的流, 对端新打开的流会被创建; 已结束的流返回nil
+func (c *quicConn) streamForRecv(id uint64) (*quicStream, error) {
+	if s, ok := c.streams[id]; ok {
+		return s, nil
+	}
+	kind := int(id>>1) & 0x01
+	if local := (id&0x01 == 0) == c.isClient; local {
+		if kind == 1 || id>>2 >= c.nextLocal[kind] {
+			return nil, &quicTransportError{quicStreamStateError, "frame for unopened local stream"}
+		}
+		return nil, nil
+	}
+	idx := id >> 2
+	if idx < c.peerOpened[kind] {
+		return nil, nil
+	}
+	if idx >= c.localMaxStreams[kind] {
+		return nil, &quicTransportError{quicStreamLimitError, "stream limit exceeded"}
+	}
+	for c.peerOpened[kind] <= idx {
+		s := c.newStream(c.peerOpened[kind]<<2 | id&0x03)
+		c.peerOpened[kind]++
+		c.accepted[kind] = append(c.accepted[kind], s)
+	}
+	c.cond.Broadcast()
+	return c.streams[id], nil
+}
+
+func (c *quicConn) onStream(id, off uint64, data []byte, fin bool) error {
+	s, err := c.streamForRecv(id)
+	if err != nil || s == nil {
+		return err
+	}
+	end := off + uint64(len(data))
+	if end > s.recvMax {
+		return &quicTransportError{quicFlowControlError, "stream flow control"}
+	}
+	if (s.finalSize >= 0 && end > uint64(s.finalSize)) || (fin && end < s.recvHighest) {
+		return &quicTransportError{quicFinalSizeError, "final size"}
+	}
+	if fin {
+		s.finalSize = int64(end)
+	}
+	if end > s.recvHighest {
+		c.recvTotal += end - s.recvHighest
+		s.recvHighest = end
+		if c.recvTotal > c.recvMaxData {
+			return &quicTransportError{quicFlowControlError, "connection flow control"}
+		}
+	}
+	if s.recvErr == nil {
+		s.readBuf = append(s.readBuf, s.reasm.push(off, data)...)
+	}
+	c.cond.Broadcast()
+	return nil
+}
+
+func (c *quicConn) onResetStream(id, code, final uint64) error {
+	s, err := c.streamForRecv(id)
+	if err != nil || s == nil {
+		return err
+	}
+	if s.recvErr == nil {
+		s.recvErr = fmt.Errorf("%w: code %#x", ErrQuicStreamReset, code)
+	}
+	if final > s.recvHighest {
+		c.recvTotal += final - s.recvHighest
+		s.recvHighest = final
+	}
+	c.cond.Broadcast()
+	c.maybeDelete(s)
+	return nil
+}
+
+// 对端不再接收数据, 以相同的错误码重置发送方向
+func (c *quicConn) onStopSending(id, code uint64) {
+	s := c.streams[id]
+	if s == nil || s.sendErr != nil || s.finSent && len(s.sendBuf) == 0 {
+		return
+	}
+	s.sendErr = fmt.Errorf("%w: stop sending, code %#x", ErrQuicStreamReset, code)
+	s.sendBuf = nil
+	c.control = append(c.control, appendResetStream(nil, s.id, code, s.sendOff))
+	c.cond.Broadcast()
+	c.maybeDelete(s)
+}
+
+func appendResetStream(b []byte, id, code, final uint64) []byte {
+	b = appendVarint(append(b, 0x04), id)
+	b = appendVarint(b, code)
+	return appendVarint(b, final)
+}
+
+// 加入发送队列, 调用时需持有锁
+func (c *quicConn) queueStream(s *quicStream) {
+	if !s.queued {
+		s.queued = true
+		c.sendQueue = append(c.sendQueue, s)
+	}
+	c.signal()
+}
+
+// 连接级窗口增大后重新排队受限的流
+func (c *quicConn) requeueBlocked() {
+	for _, s := range c.streams {
+		if len(s.sendBuf) > 0 {
+			c.queueStream(s)
+		}
+	}
+}
+
+// 两个方向都结束后移除流, 对端发起的流结束后允许对端再打开新的流
+func (c *quicConn) maybeDelete(s *quicStream) {
+	recvDone := s.recvErr != nil || (s.finalSize >= 0 && s.readOff == uint64(s.finalSize))
+	sendDone := s.sendErr != nil || (s.finSent && len(s.sendBuf) == 0)
+	if !recvDone || !sendDone || c.streams[s.id] != s {
+		return
+	}
+	delete(c.streams, s.id)
+	if local := (s.id&0x01 == 0) == c.isClient; !local {
+		kind := int(s.id>>1) & 0x01
+		c.localMaxStreams[kind]++
+		c.control = append(c.control, appendVarint([]byte{byte(0x12 + kind)}, c.localMaxStreams[kind]))
+		c.signal()
+	}
+}
+
+func (s *quicStream) Read(p []byte) (int, error) {
+	c := s.c
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for len(s.readBuf) == 0 {
+		switch {
+		case s.recvErr != nil:
+			return 0, s.recvErr
+		case s.finalSize >= 0 && s.readOff == uint64(s.finalSize):
+			c.maybeDelete(s)
+			return 0, io.EOF
+		case c.err != nil:
+			return 0, c.err
+		}
+		c.cond.Wait()
+	}
+	n := copy(p, s.readBuf)
+	s.readBuf = s.readBuf[n:]
+	s.readOff += uint64(n)
+	c.consumed += uint64(n)
+	// 已读取的数据超过半个窗口时归还接收窗口
+	if s.finalSize < 0 && s.recvMax-s.readOff < quicStreamWindow/2 {
+		s.recvMax = s.readOff + quicStreamWindow
+		c.control = append(c.control, appendVarint(appendVarint([]byte{0x11}, s.id), s.recvMax))
+		c.signal()
+	}
+	if c.recvMaxData-c.consumed < quicConnWindow/2 {
+		c.recvMaxData = c.consumed + quicConnWindow
+		c.control = append(c.control, appendVarint([]byte{0x10}, c.recvMaxData))
+		c.signal()
+	}
+	return n, nil
+}
+
+func (s *quicStream) Write(p []byte) (int, error) {
+	c := s.c
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	switch {
+	case s.sendErr != nil:
+		return 0, s.sendErr
+	case c.err != nil:
+		return 0, c.err
+	case s.finQueued:
+		return 0, io.ErrClosedPipe
+	}
+	s.sendBuf = append(s.sendBuf, p...)
+	c.queueStream(s)
+	return len(p), nil
+}
+
+// 结束发送方向
+func (s *quicStream) CloseWrite() error {
+	c := s.c
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if s.sendErr != nil || s.finQueued {
+		return s.sendErr
+	}
+	s.finQueued = true
+	c.queueStream(s)
+	return nil
+}
+
+// 中止流的两个方向: 发送 RESET_STREAM 与 STOP_SENDING, 之后的读写返回err
+func (s *quicStream) abort(code uint64, err error) {
+	c := s.c
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if s.sendErr == nil && !(s.finSent && len(s.sendBuf) == 0) {
+		s.sendErr = err
+		s.sendBuf = nil
+		c.control = append(c.control, appendResetStream(nil, s.id, code, s.sendOff))
+	}
+	if s.recvErr == nil && !(s.finalSize >= 0 && s.readOff == uint64(s.finalSize)) {
+		s.recvErr = err
+		c.control = append(c.control, appendVarint(appendVarint([]byte{0x05}, s.id), code))
+	}
+	c.cond.Broadcast()
+	c.maybeDelete(s)
+	c.signal()
+}
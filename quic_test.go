@@ -0,0 +1,310 @@
+package HiHttp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 测试Initial密钥的派生 (RFC 9001 附录A.1)
+func TestQuic_InitialKeys(t *testing.T) {
+	dcid, _ := hex.DecodeString("8394c8f03e515708")
+	client, server := quicInitialKeys(dcid, true)
+	for _, c := range []struct {
+		keys   *quicKeys
+		iv     string
+		sample string
+		mask   string
+	}{
+		// 头部保护的掩码取自 RFC 9001 附录A.2 / A.3
+		{client, "fa044b2f42a3fd3b46fb255c", "d1b1c98dd7689fb8ec11d242b123dc9b", "437b9aec36"},
+		{server, "0ac1493ca1905853b0bba03e", "2cd0991cd25b0aac406a5816b6394100", "2ec0d8356a"},
+	} {
+		if iv := hex.EncodeToString(c.keys.iv); iv != c.iv {
+			t.Fatalf("iv = %s, want %s", iv, c.iv)
+		}
+		sample, _ := hex.DecodeString(c.sample)
+		var mask [16]byte
+		c.keys.hp.Encrypt(mask[:], sample)
+		if got := hex.EncodeToString(mask[:5]); got != c.mask {
+			t.Fatalf("mask = %s, want %s", got, c.mask)
+		}
+	}
+	// 客户端的加密密钥即服务端的解密密钥
+	if _, open := quicInitialKeys(dcid, false); !bytes.Equal(open.iv, client.iv) {
+		t.Fatal("server open keys differ from client seal keys")
+	}
+}
+
+// 测试客户端只在Initial包解密成功后才切换到服务端选择的连接ID
+func TestQuic_PeerConnID(t *testing.T) {
+	c := newQuicConn(true, &tls.Config{})
+	c.origDCID = randomBytes(quicConnIDLen)
+	c.dcid = c.origDCID
+	c.spaces[quicSpaceInitial].seal, c.spaces[quicSpaceInitial].open = quicInitialKeys(c.origDCID, true)
+	// 以服务端身份封装一个 PING 与填充
+	serverPacket := func(dcid []byte) ([]byte, []byte) {
+		s := newQuicConn(false, &tls.Config{})
+		s.dcid = c.scid
+		s.spaces[quicSpaceInitial].seal, _ = quicInitialKeys(dcid, false)
+		return s.sealPacket(quicSpaceInitial, append([]byte{0x01}, make([]byte, 20)...)), s.scid
+	}
+	forged, _ := serverPacket(randomBytes(quicConnIDLen))
+	c.handleDatagram(forged)
+	if c.gotPeerCID || !bytes.Equal(c.dcid, c.origDCID) {
+		t.Fatalf("undecryptable packet switched the connection id to %x", c.dcid)
+	}
+	valid, scid := serverPacket(c.origDCID)
+	c.handleDatagram(valid)
+	if !c.gotPeerCID || !bytes.Equal(c.dcid, scid) {
+		t.Fatalf("dcid = %x, want %x", c.dcid, scid)
+	}
+}
+
+// 测试变长整数编码 (RFC 9000 附录A.1)
+func TestQuic_Varint(t *testing.T) {
+	for _, c := range []struct {
+		hex string
+		v   uint64
+	}{
+		{"c2197c5eff14e88c", 151288809941952652},
+		{"9d7f3e7d", 494878333},
+		{"7bbd", 15293},
+		{"25", 37},
+	} {
+		b, _ := hex.DecodeString(c.hex)
+		v, rest, err := readVarint(b)
+		if err != nil || v != c.v || len(rest) != 0 {
+			t.Fatalf("%s: got %d, %v", c.hex, v, err)
+		}
+		if enc := hex.EncodeToString(appendVarint(nil, c.v)); enc != c.hex {
+			t.Fatalf("encode %d = %s, want %s", c.v, enc, c.hex)
+		}
+	}
+	if _, _, err := readVarint([]byte{0x40}); err == nil {
+		t.Fatal("expected error for truncated varint")
+	}
+}
+
+// 按固定间隔丢弃收发的数据报, 模拟有丢包的网络
+type lossyPacketConn struct {
+	net.PacketConn
+	every         int32
+	reads, writes int32
+}
+
+func (c *lossyPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil || atomic.AddInt32(&c.reads, 1)%c.every != 0 {
+			return n, addr, err
+		}
+	}
+}
+
+func (c *lossyPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if atomic.AddInt32(&c.writes, 1)%c.every == 0 {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+// 启动QUIC服务端, 证书取自一个HTTPS测试服务
+func newQuicListener(t *testing.T, every int32) (*quicListener, *tls.Config) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(server.Close)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conn net.PacketConn = pc
+	if every > 0 {
+		conn = &lossyPacketConn{PacketConn: pc, every: every}
+	}
+	config := server.TLS.Clone()
+	config.NextProtos = alpnHTTP3
+	l := quicListen(conn, config)
+	t.Cleanup(func() { _ = l.Close() })
+	client := server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	client.ServerName = "example.com"
+	client.NextProtos = alpnHTTP3
+	return l, client
+}
+
+// 测试在丢包的回环网络上通过多个双向流传输数据, 以及流量控制窗口的更新
+func TestQuic_Streams(t *testing.T) {
+	const size = 6 << 20 // 超过流与连接的初始接收窗口
+	l, config := newQuicListener(t, 10)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		for {
+			s, err := c.acceptStream(true)
+			if err != nil {
+				return
+			}
+			go func() {
+				// 回显收到的数据长度
+				data, _ := ioutil.ReadAll(s)
+				_, _ = s.Write(bytes.Repeat([]byte{'z'}, len(data)))
+				_ = s.CloseWrite()
+			}()
+		}
+	}()
+	c, err := dialQuic(l.pc.LocalAddr().String(), config, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close(quicNoError, "")
+	var wg sync.WaitGroup
+	for _, n := range []int{0, 1, 1500, size} {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			s, err := c.openStream(true)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_, _ = s.Write(bytes.Repeat([]byte{'a'}, n))
+			_ = s.CloseWrite()
+			data, err := ioutil.ReadAll(s)
+			if err != nil || len(data) != n {
+				t.Errorf("stream of %d bytes: got %d, %v", n, len(data), err)
+			}
+		}(n)
+	}
+	wg.Wait()
+}
+
+/// -------------------------------- 服务端 --------------------------------
+
+// QUIC服务端, 用于在本地回环地址上测试HTTP/3与QUIC
+type quicListener struct {
+	pc     net.PacketConn
+	config *tls.Config
+	lock   sync.Mutex
+	conns  map[string]*quicConn
+	accept chan *quicConn
+	done   chan struct{}
+}
+
+func quicListen(pc net.PacketConn, config *tls.Config) *quicListener {
+	l := &quicListener{
+		pc:     pc,
+		config: config,
+		conns:  map[string]*quicConn{},
+		accept: make(chan *quicConn, 16),
+		done:   make(chan struct{}),
+	}
+	go l.serve()
+	return l
+}
+
+func (l *quicListener) serve() {
+	for {
+		buf := make([]byte, 1500)
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		data := buf[:n]
+		var dcid []byte
+		if data[0]&0x80 != 0 {
+			if n < 7 || int(data[5]) > n-6 {
+				continue
+			}
+			dcid = data[6 : 6+data[5]]
+		} else if n > quicConnIDLen {
+			dcid = data[1 : 1+quicConnIDLen]
+		}
+		l.lock.Lock()
+		c := l.conns[string(dcid)]
+		if c == nil && data[0]&0xf0 == 0xc0 && n >= quicMaxDatagram {
+			c = l.newConn(addr, data, dcid)
+		}
+		l.lock.Unlock()
+		if c != nil {
+			select {
+			case c.recv <- data:
+			default:
+			}
+		}
+	}
+}
+
+// 收到客户端的第一个Initial包时创建连接, 调用时需持有锁
+func (l *quicListener) newConn(addr net.Addr, data, dcid []byte) *quicConn {
+	scidOff := 6 + len(dcid)
+	if scidOff >= len(data) || scidOff+1+int(data[scidOff]) > len(data) {
+		return nil
+	}
+	c := newQuicConn(false, l.config)
+	c.origDCID = append([]byte(nil), dcid...)
+	c.dcid = append([]byte(nil), data[scidOff+1:scidOff+1+int(data[scidOff])]...)
+	c.spaces[quicSpaceInitial].seal, c.spaces[quicSpaceInitial].open = quicInitialKeys(c.origDCID, false)
+	c.send = func(b []byte) error {
+		_, err := l.pc.WriteTo(b, addr)
+		return err
+	}
+	c.onClosed = func() {
+		l.lock.Lock()
+		delete(l.conns, string(c.origDCID))
+		delete(l.conns, string(c.scid))
+		l.lock.Unlock()
+	}
+	if err := c.start(); err != nil {
+		return nil
+	}
+	l.conns[string(c.origDCID)] = c
+	l.conns[string(c.scid)] = c
+	go func() {
+		if c.waitEstablished(10*time.Second) != nil {
+			c.close(quicNoError, "")
+			return
+		}
+		select {
+		case l.accept <- c:
+		case <-l.done:
+		}
+	}()
+	return c
+}
+
+// 等待完成握手的连接
+func (l *quicListener) Accept() (*quicConn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.done:
+		return nil, ErrQuicClosed
+	}
+}
+
+func (l *quicListener) Close() error {
+	l.lock.Lock()
+	select {
+	case <-l.done:
+	default:
+		close(l.done)
+	}
+	conns := make([]*quicConn, 0, len(l.conns))
+	for _, c := range l.conns {
+		conns = append(conns, c)
+	}
+	l.lock.Unlock()
+	for _, c := range conns {
+		c.close(quicNoError, "")
+	}
+	return l.pc.Close()
+}
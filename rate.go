@@ -0,0 +1,138 @@
+package HiHttp
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// 最低传输速率: 宽限期过后, 每个统计窗口内上传或下载的字节数都不得低于下限,
+// 用于及时中止一字节一字节"滴"出数据的慢速对端(slow-loris)
+
+// Errors
+var (
+	ErrTransferTooSlow = errors.New("transfer rate below the configured minimum")
+)
+
+// 未设置窗口时的默认统计窗口
+const defRateWindow = time.Second
+
+// 最低传输速率, Bytes 为0时不限制; 上传从开始发送请求起计时, 下载从请求发送完毕起计时
+type MinRate struct {
+	Bytes  int64         // 每个窗口内最少传输的字节数
+	Window time.Duration // 统计窗口, 为0时为1秒
+	Grace  time.Duration // 宽限期, 期间不统计, 例: 留给服务端处理请求的时间
+}
+
+// 统计一个方向上的传输速率
+type rateMeter struct {
+	min    int64
+	window time.Duration
+	start  time.Time // 当前窗口的开始时间, 宽限期内在未来
+	n      int64     // 当前窗口内已传输的字节数
+}
+
+// 开始统计, 未设置最低速率时返回nil
+func newRateMeter(rate MinRate) *rateMeter {
+	if rate.Bytes <= 0 {
+		return nil
+	}
+	m := &rateMeter{min: rate.Bytes, window: rate.Window}
+	if m.window <= 0 {
+		m.window = defRateWindow
+	}
+	m.start = time.Now().Add(rate.Grace)
+	return m
+}
+
+// 记录now之前传输的n个字节, 并结算已结束的窗口, 某个窗口未达到下限时返回 ErrTransferTooSlow
+func (m *rateMeter) add(n int, now time.Time) error {
+	if m == nil {
+		return nil
+	}
+	if !now.Before(m.start) {
+		m.n += int64(n)
+	}
+	for !now.Before(m.start.Add(m.window)) {
+		if m.n < m.min {
+			return ErrTransferTooSlow
+		}
+		m.start = m.start.Add(m.window)
+		m.n = 0
+	}
+	return nil
+}
+
+// 当前窗口的结束时间
+func (m *rateMeter) windowEnd() time.Time {
+	return m.start.Add(m.window)
+}
+
+// 在deadline前读取, 开启速率统计时在每个窗口结束时检查速率, 达标则继续等待
+func readWithRate(conn net.Conn, b []byte, deadline time.Time, m *rateMeter) (int, error) {
+	for {
+		d, limited := deadline, false
+		if m != nil && m.windowEnd().Before(deadline) {
+			d, limited = m.windowEnd(), true
+		}
+		if err := conn.SetReadDeadline(d); err != nil {
+			return 0, err
+		}
+		n, err := conn.Read(b)
+		if rateErr := m.add(n, time.Now()); rateErr != nil {
+			return n, rateErr
+		}
+		if n == 0 && limited && isTimeoutErr(err) {
+			continue
+		}
+		return n, err
+	}
+}
+
+// 在deadline前写出全部数据, 未开启速率统计时通过 writev 一次写出多段数据;
+// 开启速率统计时分块写出, 每块不超过当前窗口还需的字节数, 因此写入在窗口结束时超时即说明该窗口未达到下限
+func writeWithRate(conn net.Conn, bufs net.Buffers, deadline time.Time, m *rateMeter) (int64, error) {
+	if m == nil {
+		if err := conn.SetWriteDeadline(deadline); err != nil {
+			return 0, err
+		}
+		return bufs.WriteTo(conn)
+	}
+	var written int64
+	for _, data := range bufs {
+		for len(data) > 0 {
+			if err := m.add(0, time.Now()); err != nil {
+				return written, err
+			}
+			// 当前窗口已达标时, 本块可以延续到下一个窗口结束
+			need, end := m.min-m.n, m.windowEnd()
+			if need <= 0 {
+				need, end = m.min, end.Add(m.window)
+			}
+			chunk := data
+			if int64(len(chunk)) > need {
+				chunk = chunk[:need]
+			}
+			d, limited := deadline, false
+			if end.Before(deadline) {
+				d, limited = end, true
+			}
+			if err := conn.SetWriteDeadline(d); err != nil {
+				return written, err
+			}
+			n, err := conn.Write(chunk)
+			written += int64(n)
+			data = data[n:]
+			if rateErr := m.add(n, time.Now()); rateErr != nil {
+				return written, rateErr
+			}
+			if err != nil {
+				if limited && isTimeoutErr(err) {
+					return written, ErrTransferTooSlow
+				}
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,99 @@
+package HiHttp
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试速率统计的宽限期与窗口结算
+func TestRateMeter(t *testing.T) {
+	m := newRateMeter(MinRate{Bytes: 10, Window: time.Second, Grace: time.Second})
+	start := m.start.Add(-time.Second)
+	// 宽限期内的字节不计入
+	if err := m.add(100, start.Add(500*time.Millisecond)); err != nil || m.n != 0 {
+		t.Fatalf("grace: n = %d, %v", m.n, err)
+	}
+	if err := m.add(10, start.Add(1500*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	// 第一个窗口达标, 第二个窗口只有5个字节
+	if err := m.add(0, start.Add(2*time.Second)); err != nil || m.n != 0 {
+		t.Fatalf("first window: n = %d, %v", m.n, err)
+	}
+	if err := m.add(5, start.Add(2500*time.Millisecond)); err != nil || m.n != 5 {
+		t.Fatalf("second window: n = %d, %v", m.n, err)
+	}
+	if err := m.add(0, start.Add(3*time.Second)); err != ErrTransferTooSlow {
+		t.Fatalf("expected ErrTransferTooSlow, got %v", err)
+	}
+	if newRateMeter(MinRate{}) != nil {
+		t.Fatal("expected nil meter without a minimum rate")
+	}
+}
+
+// 测试下载速率低于下限时中止请求, 达到下限的慢速响应可以正常读完
+func TestHttpClient_MinRate_Download(t *testing.T) {
+	server := newDripServer(t, 40, 50*time.Millisecond)
+	for _, opts := range []Options{{}, {Pipeline: 2}} {
+		opts.MinRate = MinRate{Bytes: 200, Window: 200 * time.Millisecond}
+		client, err := HiHttp(context.Background(), server.URL, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		_, err = client.Get("/")
+		client.End()
+		if err != ErrTransferTooSlow {
+			t.Fatalf("pipeline %d: expected ErrTransferTooSlow, got %v", opts.Pipeline, err)
+		}
+		// 流水线会在新连接上重发幂等请求
+		if elapsed := time.Since(start); elapsed > 1500*time.Millisecond {
+			t.Fatalf("pipeline %d: slow transfer aborted after %v", opts.Pipeline, elapsed)
+		}
+	}
+
+	client, err := HiHttp(context.Background(), newDripServer(t, 6, 40*time.Millisecond).URL, Options{
+		MinRate: MinRate{Bytes: 1, Window: 200 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	if result, err := client.Get("/"); err != nil || result != "xxxxxx" {
+		t.Fatalf("slow but acceptable body: %q, %v", result, err)
+	}
+}
+
+// 测试服务端不读取请求体时上传速率低于下限
+func TestHttpClient_MinRate_Upload(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	client, err := HiHttp(context.Background(), "http://"+listener.Addr().String(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	client.SetMinRate(MinRate{Bytes: 1 << 20, Window: 200 * time.Millisecond})
+	start := time.Now()
+	if _, err := client.Post("/", strings.NewReader(strings.Repeat("u", 8<<20))); err != ErrTransferTooSlow {
+		t.Fatalf("expected ErrTransferTooSlow, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("stalled upload aborted after %v", elapsed)
+	}
+}
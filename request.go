@@ -1,56 +1,163 @@
 package HiHttp
 
 import (
+	"bytes"
 	"io"
-	"io/ioutil"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 var (
-	_HttpVerBytes = []byte("HTTP/1.1")
-	_BrBytes      = []byte("\r\n")
+	_BrBytes = []byte("\r\n")
 )
 
 type Headers map[string]string
 
+// 忽略大小写获取Header值
+func (h Headers) Get(key string) string {
+	if val, ok := h[key]; ok {
+		return val
+	}
+	for k, val := range h {
+		if strings.EqualFold(k, key) {
+			return val
+		}
+	}
+	return ""
+}
+
+// 设置Header值, 会替换掉仅大小写不同的同名Header
+func (h Headers) Set(key, value string) {
+	for k := range h {
+		if k != key && strings.EqualFold(k, key) {
+			delete(h, k)
+		}
+	}
+	h[key] = value
+}
+
+// 忽略大小写删除Header
+func (h Headers) Del(key string) {
+	for k := range h {
+		if strings.EqualFold(k, key) {
+			delete(h, k)
+		}
+	}
+}
+
 type Request struct {
-	Done        bool      // 标记请求是否已结束
 	Retry       uint16    // 失败重试次数
+	Version     string    // 协议版本, HTTP10 或 HTTP11(默认)
 	Method, Url string    // 请求方法、请求路径
 	Headers     Headers   // 请求头信息
 	Body        io.Reader // 请求体
 	// 连接超时、写入超时、读取超时
 	Timeout, WriteTimeout, ReadTimeout time.Duration
+	// 分阶段超时
+	Timeouts Timeouts
+	// 整个请求(含重试)的截止时间, 由 Timeouts.Total 得出, 为零值时不限制
+	Deadline time.Time
+	// 最低传输速率
+	MinRate MinRate
+
+	body       []byte        // 已读取的请求体
+	bodyReader *bytes.Reader // 替换 Body 的读取器, Body 被再次替换后需重新读取
+}
+
+// 计算一个阶段的截止时间: 当前时间加上阶段超时(为0时使用def), 且不晚于请求的截止时间;
+// 同时返回到期时应报告的错误, 受请求截止时间限制时为 ErrTotalTimeout
+func (req *Request) phaseDeadline(phase, def time.Duration, phaseErr error) (time.Time, error) {
+	if phase <= 0 {
+		phase = def
+	}
+	deadline := time.Now().Add(phase)
+	if !req.Deadline.IsZero() && !deadline.Before(req.Deadline) {
+		return req.Deadline, ErrTotalTimeout
+	}
+	return deadline, phaseErr
+}
+
+// 单次尝试的截止时间, 由 Timeout 与请求的截止时间决定
+func (req *Request) attemptDeadline() (time.Time, error) {
+	return req.phaseDeadline(0, req.Timeout, ErrRequestTimeout)
+}
+
+// 复制请求, 副本拥有独立的Header
+func (req *Request) clone() *Request {
+	r := *req
+	r.Headers = make(Headers, len(req.Headers)+1)
+	for key, val := range req.Headers {
+		r.Headers[key] = val
+	}
+	return &r
 }
 
 // 生成请求报文
 func (req *Request) GetRequestData() (reqBytes []byte, err error) {
-	reqBytes = append(reqBytes, []byte(req.Method)...)
-	// 空格: 0x20
-	reqBytes = append(reqBytes, 0x20)
-	reqBytes = append(reqBytes, []byte(req.Url)...)
-	reqBytes = append(reqBytes, 0x20)
-	reqBytes = append(reqBytes, _HttpVerBytes...)
-	reqBytes = append(reqBytes, _BrBytes...)
-	var bodyBytes []byte
+	head, body, err := req.appendHead(nil)
+	if err != nil {
+		return nil, err
+	}
+	return append(head, body...), nil
+}
+
+// 请求报文头的缓冲池
+var headPool = sync.Pool{New: func() interface{} {
+	buf := make([]byte, 0, 1024)
+	return &buf
+}}
+
+// 缓冲池中保留的报文头缓冲区的最大容量, 例外的大报文头用完即丢弃
+const maxPooledHead = 64 << 10
+
+// 将请求行与报文头追加到dst, 同时返回请求体;
+// 两者分开返回, 以便一次 writev 写出, 不必将请求体复制到报文头之后
+func (req *Request) appendHead(dst []byte) (head, body []byte, err error) {
+	body, err = req.readBody()
+	if err != nil {
+		return dst, nil, err
+	}
 	if req.Body != nil {
-		bodyBytes, err = ioutil.ReadAll(req.Body)
-		if err != nil {
-			return
-		}
-		req.Headers["Content-Length"] = strconv.Itoa(len(bodyBytes))
+		req.Headers["Content-Length"] = strconv.Itoa(len(body))
+	} else {
+		delete(req.Headers, "Content-Length")
 	}
+	dst = append(dst, req.Method...)
+	dst = append(dst, ' ')
+	dst = append(dst, req.Url...)
+	dst = append(dst, ' ')
+	dst = append(dst, If(req.Version == "", HTTP11, req.Version).(string)...)
+	dst = append(dst, _BrBytes...)
 	for key, val := range req.Headers {
-		reqBytes = append(reqBytes, []byte(key)...)
-		// : + 空格: 0x3A, 0x20
-		reqBytes = append(reqBytes, 0x3A, 0x20)
-		reqBytes = append(reqBytes, []byte(val)...)
-		reqBytes = append(reqBytes, _BrBytes...)
+		dst = append(dst, key...)
+		dst = append(dst, ": "...)
+		dst = append(dst, val...)
+		dst = append(dst, _BrBytes...)
+	}
+	return append(dst, _BrBytes...), body, nil
+}
+
+// 读取请求体, 并替换为可重复读取的请求体, 以便重试或认证质询后重新发送;
+// 读取后的数据会被缓存, 重发时不再复制
+func (req *Request) readBody() ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	if r, ok := req.Body.(*bytes.Reader); ok && r == req.bodyReader {
+		return req.body, nil
+	}
+	var buf bytes.Buffer
+	// 长度已知时一次分配
+	if l, ok := req.Body.(interface{ Len() int }); ok {
+		buf.Grow(l.Len() + bytes.MinRead)
 	}
-	reqBytes = append(reqBytes, _BrBytes...)
-	if len(bodyBytes) > 0 {
-		reqBytes = append(reqBytes, bodyBytes...)
+	if _, err := buf.ReadFrom(req.Body); err != nil {
+		return nil, err
 	}
-	return
+	req.body = buf.Bytes()
+	req.bodyReader = bytes.NewReader(req.body)
+	req.Body = req.bodyReader
+	return req.body, nil
 }
@@ -0,0 +1,363 @@
+package HiHttp
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// 简单的DNS存根解析器(RFC 1035): 通过UDP向递归DNS服务器查询A与AAAA记录, 响应被截断时改用TCP;
+// 与系统解析器不同, 它能得到记录的TTL, 因此缓存可以按TTL过期; 不处理 /etc/hosts 与搜索域
+
+// Errors
+var (
+	ErrDNSMalformed = errors.New("malformed dns message")
+	ErrDNSName      = errors.New("invalid dns name")
+)
+
+const (
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+	dnsClassIN  = 1
+
+	dnsFlagRD    = 0x0100 // 期望递归
+	dnsFlagTC    = 0x0200 // 响应被截断
+	dnsFlagQR    = 0x8000 // 响应
+	dnsRcodeMask = 0x000f
+
+	dnsRcodeNameError = 3
+
+	dnsMaxUDPSize = 1232 // 避免IP分片的UDP报文大小
+	dnsMaxLabel   = 63   // 标签的最大长度
+	dnsMaxName    = 255  // 编码后域名的最大长度
+	dnsTypeCNAME  = 5
+)
+
+type dnsResolver struct {
+	servers []string
+}
+
+// DNSResolver 创建向指定服务器(host 或 host:port, 默认端口53)查询的解析器, 依次尝试各个服务器;
+// 未指定时使用 /etc/resolv.conf 中的 nameserver
+func DNSResolver(servers ...string) Resolver {
+	if len(servers) == 0 {
+		servers = systemNameservers()
+	}
+	r := &dnsResolver{}
+	for _, server := range servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+		}
+		r.servers = append(r.servers, server)
+	}
+	return r
+}
+
+// 读取 /etc/resolv.conf 中的 nameserver, 没有时使用本机
+func systemNameservers() []string {
+	servers := []string{}
+	if f, err := os.Open("/etc/resolv.conf"); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				servers = append(servers, fields[1])
+			}
+		}
+		_ = f.Close()
+	}
+	if len(servers) == 0 {
+		servers = append(servers, "127.0.0.1")
+	}
+	return servers
+}
+
+// 并行查询A与AAAA记录, 任一查询得到地址即成功, TTL取所有记录中最小的
+func (r *dnsResolver) LookupHost(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	type answer struct {
+		ips []net.IP
+		ttl time.Duration
+		err error
+	}
+	answers := make(chan answer, 2)
+	for _, qtype := range []uint16{dnsTypeAAAA, dnsTypeA} {
+		go func(qtype uint16) {
+			ips, ttl, err := r.query(ctx, host, qtype)
+			answers <- answer{ips, ttl, err}
+		}(qtype)
+	}
+	var (
+		ips []net.IP
+		ttl time.Duration
+		err error
+	)
+	for i := 0; i < 2; i++ {
+		a := <-answers
+		if a.err != nil {
+			err = a.err
+			continue
+		}
+		ips = append(ips, a.ips...)
+		if len(a.ips) > 0 && (ttl == 0 || a.ttl < ttl) {
+			ttl = a.ttl
+		}
+	}
+	if len(ips) > 0 {
+		return ips, ttl, nil
+	}
+	if err == nil || err == ErrNoAddress {
+		err = fmt.Errorf("%w: %s", ErrNoAddress, host)
+	}
+	return nil, 0, err
+}
+
+// 依次向各个服务器查询一种记录
+func (r *dnsResolver) query(ctx context.Context, host string, qtype uint16) (ips []net.IP, ttl time.Duration, err error) {
+	query, id, err := appendDNSQuery(nil, host, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, server := range r.servers {
+		var msg []byte
+		if msg, err = exchangeDNS(ctx, "udp", server, query); err != nil {
+			continue
+		}
+		if binary.BigEndian.Uint16(msg[2:])&dnsFlagTC != 0 {
+			if msg, err = exchangeDNS(ctx, "tcp", server, query); err != nil {
+				continue
+			}
+		}
+		ips, ttl, err = parseDNSAnswer(msg, id, qtype, host)
+		if err == nil || errors.Is(err, ErrNoAddress) {
+			return
+		}
+	}
+	return nil, 0, err
+}
+
+// 发送查询并读取一个响应, TCP报文带两字节的长度前缀
+func exchangeDNS(ctx context.Context, network, server string, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+	if network == "udp" {
+		if _, err = conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, dnsMaxUDPSize)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return nil, err
+			}
+			// 忽略ID不匹配的报文, 例: 之前超时的查询迟到的响应
+			if n >= 12 && buf[0] == query[0] && buf[1] == query[1] {
+				return buf[:n], nil
+			}
+		}
+	}
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	if _, err = conn.Write(msg); err != nil {
+		return nil, err
+	}
+	var size [2]byte
+	if _, err = io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	msg = make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err = io.ReadFull(conn, msg); err != nil {
+		return nil, err
+	}
+	if len(msg) < 12 {
+		return nil, ErrDNSMalformed
+	}
+	return msg, nil
+}
+
+// 生成查询报文, 返回报文与随机的ID; 标签为空或超过63字节、域名编码后超过255字节时返回 ErrDNSName
+func appendDNSQuery(dst []byte, host string, qtype uint16) ([]byte, uint16, error) {
+	name := strings.TrimSuffix(host, ".")
+	if len(name)+2 > dnsMaxName {
+		return nil, 0, fmt.Errorf("%w: %s", ErrDNSName, host)
+	}
+	id := binary.BigEndian.Uint16(randomBytes(2))
+	dst = append(dst, byte(id>>8), byte(id), dnsFlagRD>>8, 0, 0, 1, 0, 0, 0, 0, 0, 0)
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > dnsMaxLabel {
+			return nil, 0, fmt.Errorf("%w: %s", ErrDNSName, host)
+		}
+		dst = append(dst, byte(len(label)))
+		dst = append(dst, label...)
+	}
+	return append(dst, 0, byte(qtype>>8), byte(qtype), 0, dnsClassIN), id, nil
+}
+
+// 解析响应中与查询类型相同的记录, TTL取其中最小的; 只接受属于查询的域名或其别名(CNAME)链上的记录,
+// 别名记录本身被跳过, 其目标的地址记录同在回答中
+func parseDNSAnswer(msg []byte, id, qtype uint16, host string) (ips []net.IP, ttl time.Duration, err error) {
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg) != id {
+		return nil, 0, ErrDNSMalformed
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&dnsFlagQR == 0 {
+		return nil, 0, ErrDNSMalformed
+	}
+	switch rcode := flags & dnsRcodeMask; rcode {
+	case 0:
+	case dnsRcodeNameError:
+		return nil, 0, ErrNoAddress
+	default:
+		return nil, 0, fmt.Errorf("dns server failure, rcode %d", rcode)
+	}
+	qdCount, anCount := binary.BigEndian.Uint16(msg[4:]), binary.BigEndian.Uint16(msg[6:])
+	off := 12
+	for i := 0; i < int(qdCount); i++ {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, 0, err
+		}
+		off += 4
+	}
+	type record struct {
+		owner, target string // target 为别名记录的目标
+		ip            net.IP
+		ttl           time.Duration
+	}
+	var records []record
+	for i := 0; i < int(anCount); i++ {
+		var owner string
+		if owner, off, err = readDNSName(msg, off); err != nil {
+			return nil, 0, err
+		}
+		if off+10 > len(msg) {
+			return nil, 0, ErrDNSMalformed
+		}
+		typ, class := binary.BigEndian.Uint16(msg[off:]), binary.BigEndian.Uint16(msg[off+2:])
+		recTTL := time.Duration(binary.BigEndian.Uint32(msg[off+4:])) * time.Second
+		size := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+size > len(msg) {
+			return nil, 0, ErrDNSMalformed
+		}
+		data := msg[off : off+size]
+		off += size
+		switch {
+		case class != dnsClassIN:
+		case typ == dnsTypeCNAME:
+			target, _, err := readDNSName(msg, off-size)
+			if err != nil {
+				return nil, 0, err
+			}
+			records = append(records, record{owner: owner, target: target})
+		case typ == qtype && (typ == dnsTypeA && size == net.IPv4len || typ == dnsTypeAAAA && size == net.IPv6len):
+			records = append(records, record{owner: owner, ip: net.IP(append([]byte(nil), data...)), ttl: recTTL})
+		}
+	}
+	// 从查询的域名出发沿别名链收集有效的名字, 别名记录的顺序不限
+	names := map[string]bool{strings.ToLower(strings.TrimSuffix(host, ".")): true}
+	for grown := true; grown; {
+		grown = false
+		for _, rec := range records {
+			if rec.target != "" && names[rec.owner] && !names[rec.target] {
+				names[rec.target], grown = true, true
+			}
+		}
+	}
+	for _, rec := range records {
+		if rec.ip == nil || !names[rec.owner] {
+			continue
+		}
+		ips = append(ips, rec.ip)
+		if len(ips) == 1 || rec.ttl < ttl {
+			ttl = rec.ttl
+		}
+	}
+	if len(ips) == 0 {
+		return nil, 0, ErrNoAddress
+	}
+	return ips, ttl, nil
+}
+
+// 读取一个域名(小写, 不带结尾的点), 返回其后的偏移; 压缩指针只能指向之前的位置, 以免循环
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var name []byte
+	next := -1
+	for ptr := off; ; {
+		if ptr >= len(msg) {
+			return "", 0, ErrDNSMalformed
+		}
+		n := int(msg[ptr])
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = ptr + 1
+			}
+			return strings.ToLower(string(name)), next, nil
+		case n&0xc0 == 0xc0:
+			if ptr+1 >= len(msg) {
+				return "", 0, ErrDNSMalformed
+			}
+			target := int(binary.BigEndian.Uint16(msg[ptr:]) & 0x3fff)
+			if target >= ptr {
+				return "", 0, ErrDNSMalformed
+			}
+			if next < 0 {
+				next = ptr + 2
+			}
+			ptr = target
+			continue
+		case n&0xc0 != 0 || ptr+1+n > len(msg):
+			return "", 0, ErrDNSMalformed
+		}
+		if len(name) > 0 {
+			name = append(name, '.')
+		}
+		name = append(name, msg[ptr+1:ptr+1+n]...)
+		if len(name) > dnsMaxName {
+			return "", 0, ErrDNSMalformed
+		}
+		ptr += 1 + n
+	}
+}
+
+// 跳过一个域名, 返回其后的偏移; 压缩指针(RFC 1035 4.1.4)占两个字节且结束域名
+func skipDNSName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, ErrDNSMalformed
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			return off + 1, nil
+		case n&0xc0 == 0xc0:
+			return off + 2, nil
+		case n&0xc0 != 0:
+			return 0, ErrDNSMalformed
+		}
+		off += 1 + n
+	}
+}
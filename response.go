@@ -1,5 +1,37 @@
 package HiHttp
 
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors
+var (
+	ErrMalformedChunk = errors.New("malformed chunked encoding")
+	ErrHeaderTooLarge = errors.New("response header too large")
+)
+
+// 响应报文头的最大长度
+const maxHeaderBytes = 1 << 20
+
+// 按 Content-Length 预先分配报文体的最大长度
+const maxPreallocBody = 16 << 20
+
+// 单个分块的最大长度, 超过时视为异常的分块
+const maxChunkSize = 1 << 31
+
+// 连接缓冲读取器的大小
+const readBufSize = 16 << 10
+
 type Response struct {
 	Version       string
 	Status        int
@@ -8,4 +40,317 @@ type Response struct {
 	Error         error
 	ContentLength uint64
 	Body          string
+	Close         bool     // 响应结束后连接将被关闭, 不能再复用
+	TLS           *TLSInfo // 响应所在连接的TLS信息, 非HTTPS时为nil
+}
+
+// 解析响应的状态行
+func parseStatusLine(line string) (res Response) {
+	statusInfos := strings.Split(line, " ")
+	res.Version = statusInfos[0]
+	if len(statusInfos) < 2 || !strings.HasPrefix(res.Version, "HTTP/") {
+		res.Status = BAD_REQUEST
+		res.Error = ErrRequestFail
+		return
+	}
+	status, err := strconv.Atoi(statusInfos[1])
+	if err != nil {
+		res.Status = BAD_REQUEST
+		res.Error = err
+		return
+	}
+	res.Status = status
+	res.Description = strings.Join(statusInfos[2:], " ")
+	res.Headers = make(Headers, 16)
+	return
+}
+
+// 解析一行报文头, 同名Header按 RFC 7230 以逗号合并
+func (res *Response) addHeader(line []byte) {
+	idx := bytes.IndexByte(line, ':')
+	if idx <= 0 {
+		return
+	}
+	key, val := string(line[:idx]), string(bytes.TrimSpace(line[idx+1:]))
+	if old, ok := res.Headers[key]; ok {
+		val = old + ", " + val
+	}
+	res.Headers[key] = val
+}
+
+// 报文头接收完整后确定报文体长度与连接是否保持
+func (res *Response) finishHead(req *Request) {
+	cl, err := parseContentLength(res.Headers.Get("Content-Length"))
+	if err != nil {
+		res.Status = BAD_REQUEST
+		res.Error = err
+		return
+	}
+	res.ContentLength = cl
+	res.Close = !keepAlive(res.Version, res.Headers.Get("Connection")) ||
+		!keepAlive(req.Version, req.Headers.Get("Connection"))
+}
+
+// 根据协议版本与 Connection 头判断连接是否保持:
+// HTTP/1.1 默认保持, 除非声明 close; HTTP/1.0 仅在声明 keep-alive 时保持
+func keepAlive(version, connection string) bool {
+	var hasClose, hasKeepAlive bool
+	for _, token := range strings.Split(connection, ",") {
+		switch strings.ToLower(strings.TrimSpace(token)) {
+		case "close":
+			hasClose = true
+		case "keep-alive":
+			hasKeepAlive = true
+		}
+	}
+	if hasClose {
+		return false
+	}
+	if version == HTTP10 {
+		return hasKeepAlive
+	}
+	return true
+}
+
+// 解析 Keep-Alive: timeout=5, max=100, 未声明的参数返回 0 / -1
+func parseKeepAlive(value string) (timeout time.Duration, max int) {
+	max = -1
+	for _, param := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || n < 0 {
+			continue
+		}
+		switch strings.ToLower(kv[0]) {
+		case "timeout":
+			timeout = time.Duration(n) * time.Second
+		case "max":
+			max = n
+		}
+	}
+	return
+}
+
+// 读取一行(含行尾), 超过缓冲区的长行会被拼接, 总长度超过limit时返回 ErrHeaderTooLarge;
+// 未超过缓冲区时返回的切片指向缓冲区, 下次读取前有效
+func readLine(br *bufio.Reader, limit int) ([]byte, error) {
+	line, err := br.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return line, err
+	}
+	long := append([]byte(nil), line...)
+	for err == bufio.ErrBufferFull {
+		if len(long) > limit {
+			return nil, ErrHeaderTooLarge
+		}
+		line, err = br.ReadSlice('\n')
+		long = append(long, line...)
+	}
+	return long, err
+}
+
+// 逐行读取并解析响应的状态行与报文头, 不缓存整个报文头
+func readResponseHead(br *bufio.Reader, req *Request) (res Response, err error) {
+	size := 0
+	for {
+		line, err := readLine(br, maxHeaderBytes-size)
+		if err != nil {
+			if err == io.EOF && size > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return Response{}, err
+		}
+		if size += len(line); size > maxHeaderBytes {
+			return Response{}, ErrHeaderTooLarge
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		switch {
+		case len(line) == 0 && res.Version == "":
+			// 忽略响应前多余的空行
+		case len(line) == 0 && res.Status/100 == 1 && res.Status != 101:
+			// 跳过临时响应(例: 100 Continue, 103 Early Hints), 继续读取最终响应; 累计长度仍受 maxHeaderBytes 限制
+			res = Response{}
+		case len(line) == 0:
+			// 空行表示报文头结束
+			if res.finishHead(req); res.Error != nil {
+				return res, res.Error
+			}
+			return res, nil
+		case res.Version == "":
+			if res = parseStatusLine(string(line)); res.Error != nil {
+				return res, res.Error
+			}
+		default:
+			res.addHeader(line)
+		}
+	}
+}
+
+// 根据报文头确定的报文体边界(RFC 7230 3.3.3)读取报文体, 读取结束后缓冲区中剩余的数据属于下一个响应
+func readResponseBody(br *bufio.Reader, req *Request, res *Response) (err error) {
+	var body []byte
+	switch {
+	case req.Method == "HEAD" || res.Status/100 == 1 || res.Status == 204 || res.Status == 304:
+	case strings.Contains(strings.ToLower(res.Headers.Get("Transfer-Encoding")), "chunked"):
+		body, err = readChunked(br)
+	case res.Headers.Get("Content-Length") != "":
+		if res.ContentLength <= maxPreallocBody {
+			// 按 Content-Length 一次分配, 直接读入
+			body = make([]byte, res.ContentLength)
+			_, err = io.ReadFull(br, body)
+			break
+		}
+		// 声明的长度过大时不预先分配, 以免异常的响应耗尽内存
+		var buf bytes.Buffer
+		_, err = io.CopyN(&buf, br, int64(res.ContentLength))
+		body = buf.Bytes()
+	default:
+		// 报文体以连接关闭为结束
+		res.Close = true
+		body, err = ioutil.ReadAll(br)
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	// 报文体切片不再被使用, 直接作为字符串而不复制
+	res.Body = bytesToString(body)
+	res.ContentLength = uint64(len(body))
+	return nil
+}
+
+// 从缓冲读取器中读取分块传输的报文体(含trailer); 分块的声明长度不可信, 按实际收到的数据增长缓冲区
+func readChunked(br *bufio.Reader) ([]byte, error) {
+	var body []byte
+	for {
+		line, err := readLine(br, maxHeaderBytes)
+		if err != nil {
+			return nil, err
+		}
+		sizeStr := bytes.TrimRight(line, "\r\n")
+		// 忽略分块扩展, 例: 1a;name=value
+		if idx := bytes.IndexByte(sizeStr, ';'); idx >= 0 {
+			sizeStr = sizeStr[:idx]
+		}
+		size, err := strconv.ParseUint(string(bytes.TrimSpace(sizeStr)), 16, 63)
+		if err != nil || size > maxChunkSize {
+			return nil, ErrMalformedChunk
+		}
+		if size == 0 {
+			// 跳过trailer直到空行
+			for {
+				if line, err = readLine(br, maxHeaderBytes); err != nil {
+					return nil, err
+				}
+				if len(bytes.TrimRight(line, "\r\n")) == 0 {
+					return body, nil
+				}
+			}
+		}
+		// 与 Content-Length 相同, 总长度不超过 maxPreallocBody 时按分块声明的长度分配, 否则每次最多读取 readBufSize;
+		// 容量不足时至少加倍, 数据直接读入而不经过中间缓冲
+		for size > 0 {
+			n := int(size)
+			if uint64(len(body))+size > maxPreallocBody && size > readBufSize {
+				n = readBufSize
+			}
+			if cap(body)-len(body) < n {
+				grown := make([]byte, len(body), 2*cap(body))
+				if cap(grown) < len(body)+n {
+					grown = make([]byte, len(body), len(body)+n)
+				}
+				copy(grown, body)
+				body = grown
+			}
+			if _, err = io.ReadFull(br, body[len(body):len(body)+n]); err != nil {
+				return nil, err
+			}
+			body, size = body[:len(body)+n], size-uint64(n)
+		}
+		var crlf [2]byte
+		if _, err = io.ReadFull(br, crlf[:]); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(crlf[:], _BrBytes) {
+			return nil, ErrMalformedChunk
+		}
+	}
+}
+
+// 连接上的响应读取方, 在连接的生命周期内复用同一个缓冲读取器(取自缓冲池);
+// 报文头需在 Header 超时内接收完整, 之后每次读取受 Idle 超时限制, 下载速率从开始读取响应起统计
+type connReader struct {
+	conn       net.Conn
+	br         *bufio.Reader
+	req        *Request
+	header     time.Time // 报文头的截止时间
+	headerErr  error
+	inBody     bool       // 报文头已接收完整
+	meter      *rateMeter // 下载速率统计
+	timeoutErr error      // 最近一次读取超时时应报告的错误
+	n          int        // 当前响应已从连接读取的字节数
+	tls        *TLSInfo   // 连接的TLS信息
+}
+
+// 连接缓冲读取器的缓冲池
+var readerPool sync.Pool
+
+func newConnReader(conn net.Conn) *connReader {
+	r := &connReader{conn: conn, tls: connTLSInfo(conn)}
+	if br, ok := readerPool.Get().(*bufio.Reader); ok {
+		br.Reset(r)
+		r.br = br
+	} else {
+		r.br = bufio.NewReaderSize(r, readBufSize)
+	}
+	return r
+}
+
+// 归还缓冲读取器, 连接关闭且不再读取时调用
+func (r *connReader) release() {
+	if r.br != nil {
+		r.br.Reset(nil)
+		readerPool.Put(r.br)
+		r.br = nil
+	}
+}
+
+func (r *connReader) Read(b []byte) (int, error) {
+	deadline, timeoutErr := r.header, r.headerErr
+	if r.inBody {
+		deadline, timeoutErr = r.req.phaseDeadline(r.req.Timeouts.Idle, r.req.ReadTimeout, ErrIdleTimeout)
+	}
+	r.timeoutErr = timeoutErr
+	n, err := readWithRate(r.conn, b, deadline, r.meter)
+	r.n += n
+	return n, err
+}
+
+// 读取req的响应, 超时以所处阶段的错误报告; 未读到任何数据连接就被关闭时返回 ErrConnClosed
+func (r *connReader) readResponse(req *Request) (Response, error) {
+	r.req, r.inBody, r.n = req, false, 0
+	r.header, r.headerErr = req.phaseDeadline(req.Timeouts.Header, req.ReadTimeout, ErrHeaderTimeout)
+	r.meter = newRateMeter(req.MinRate)
+	res, err := readResponseHead(r.br, req)
+	if err == nil {
+		r.inBody = true
+		err = readResponseBody(r.br, req, &res)
+	}
+	switch {
+	case err == nil:
+		res.TLS = r.tls
+		return res, nil
+	case res.Error != nil:
+		return res, err
+	case isTimeoutErr(err):
+		err = phaseError(r.timeoutErr, err)
+	case r.n == 0 && r.br.Buffered() == 0 && isConnClosedErr(err):
+		err = fmt.Errorf("%w: %v", ErrConnClosed, err)
+	}
+	return Response{Status: BAD_REQUEST, Error: err}, err
 }
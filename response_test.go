@@ -0,0 +1,165 @@
+package HiHttp
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// 原始TCP测试服务, handler 的参数n为该请求在所在连接上的序号(从0开始),
+// 返回原始响应报文以及写完后是否关闭连接, 报文为空时不响应直接关闭连接
+type rawServer struct {
+	listener net.Listener
+	conns    int32 // 已接受的连接数
+}
+
+func newRawServer(t *testing.T, handler func(req *http.Request, n int) (raw string, close bool)) *rawServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &rawServer{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&s.conns, 1)
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for n := 0; ; n++ {
+					req, err := http.ReadRequest(reader)
+					if err != nil {
+						return
+					}
+					_, _ = io.Copy(ioutil.Discard, req.Body)
+					raw, close := handler(req, n)
+					if raw == "" {
+						return
+					}
+					if _, err = conn.Write([]byte(raw)); err != nil || close {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *rawServer) URL() string {
+	return "http://" + s.listener.Addr().String()
+}
+
+func (s *rawServer) Close() {
+	_ = s.listener.Close()
+}
+
+// 测试HTTP/1.0请求以及以连接关闭为结束的响应体
+func TestHttpClient_HTTP10_Close_Delimited(t *testing.T) {
+	server := newRawServer(t, func(req *http.Request, _ int) (string, bool) {
+		return "HTTP/1.0 200 OK\r\nContent-Type: text/plain\r\n\r\n" + req.Proto + " body\r\nwith\r\n\r\nlines", true
+	})
+	defer server.Close()
+	client, err := HiHttp(context.Background(), server.URL(), Options{Version: HTTP10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	for i := 0; i < 2; i++ {
+		result, err := client.Get("/")
+		if err != nil || result != "HTTP/1.0 body\r\nwith\r\n\r\nlines" {
+			t.Fatalf("request %d: %q, %v", i, result, err)
+		}
+	}
+	if conns := atomic.LoadInt32(&server.conns); conns != 2 {
+		t.Fatalf("server accepted %d connections, want 2", conns)
+	}
+	if err = client.SetVersion("HTTP/2"); err == nil {
+		t.Fatal("expected unsupported version error")
+	}
+}
+
+// 测试服务端声明 Connection: close 或 Keep-Alive max 时重新建立连接
+func TestHttpClient_Connection_Directives(t *testing.T) {
+	var count int32
+	server := newRawServer(t, func(req *http.Request, _ int) (string, bool) {
+		switch atomic.AddInt32(&count, 1) {
+		case 1:
+			return "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nConnection: close\r\n\r\nfirst", true
+		case 2:
+			return "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nKeep-Alive: timeout=30, max=1\r\n\r\n" +
+				"3\r\nsec\r\n3;ext=1\r\nond\r\n0\r\nX-Trailer: 1\r\n\r\n", false
+		case 3:
+			return "HTTP/1.0 200 OK\r\nConnection: keep-alive\r\nContent-Length: 5\r\n\r\nthird", false
+		}
+		return "HTTP/1.1 204 No Content\r\n\r\n", false
+	})
+	defer server.Close()
+	client, err := HiHttp(context.Background(), server.URL(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	for i, want := range []string{"first", "second", "third", ""} {
+		if result, err := client.Get("/"); err != nil || result != want {
+			t.Fatalf("request %d: %q, %v", i, result, err)
+		}
+	}
+	if conns := atomic.LoadInt32(&server.conns); conns != 3 {
+		t.Fatalf("server accepted %d connections, want 3", conns)
+	}
+}
+
+// 测试跳过临时的1xx响应, 返回最终响应
+func TestReadResponseHead_Interim(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader("HTTP/1.1 100 Continue\r\n\r\n" +
+		"HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n" +
+		"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+	req := &Request{Method: "GET", Headers: Headers{}}
+	res, err := readResponseHead(br, req)
+	if err != nil || res.Status != 200 || res.Headers.Get("Link") != "" {
+		t.Fatalf("%+v, %v", res, err)
+	}
+	if err = readResponseBody(br, req, &res); err != nil || res.Body != "ok" {
+		t.Fatalf("body %q, %v", res.Body, err)
+	}
+	// 101 是最终响应
+	res, err = readResponseHead(bufio.NewReader(strings.NewReader("HTTP/1.1 101 Switching Protocols\r\nUpgrade: h2c\r\n\r\n")), req)
+	if err != nil || res.Status != 101 {
+		t.Fatalf("%+v, %v", res, err)
+	}
+}
+
+// 测试分块传输的解码
+func TestReadChunked(t *testing.T) {
+	chunked := func(s string) *bufio.Reader { return bufio.NewReader(strings.NewReader(s)) }
+	body, err := readChunked(chunked("4\r\nWiki\r\n6\r\npedia \r\nE\r\nin \r\n\r\nchunks.\r\n0\r\n\r\n"))
+	if err != nil || string(body) != "Wikipedia in \r\n\r\nchunks." {
+		t.Fatalf("got %q, %v", body, err)
+	}
+	if _, err = readChunked(chunked("4\r\nWiki\r\n0\r\n")); err == nil {
+		t.Fatal("incomplete trailer reported as complete")
+	}
+	if _, err = readChunked(chunked("zz\r\n")); err != ErrMalformedChunk {
+		t.Fatalf("expected ErrMalformedChunk, got %v", err)
+	}
+	// 超过上限以及溢出的分块长度不会预先分配内存
+	for _, size := range []string{"7fffffffffffffff", "ffffffffffffffffff", "80000001"} {
+		if _, err = readChunked(chunked(size + "\r\nWiki\r\n0\r\n\r\n")); err != ErrMalformedChunk {
+			t.Fatalf("%s: expected ErrMalformedChunk, got %v", size, err)
+		}
+	}
+	// 声明的长度大于实际数据时按收到的数据结束
+	if _, err = readChunked(chunked("7fffffff\r\nWiki")); err != io.EOF && err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected EOF for a truncated chunk, got %v", err)
+	}
+}
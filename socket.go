@@ -0,0 +1,117 @@
+package HiHttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Errors
+var (
+	ErrUnsupportedSockOpt = errors.New("socket option not supported on this platform")
+	ErrInvalidSockOpt     = errors.New("invalid socket option")
+)
+
+// TCP连接的套接字选项, 作用于客户端建立的每个HTTP/1.x与HTTP/2连接; 设置了 Options.Dialer 时不生效,
+// 也不作用于 unix socket 与 HTTP3 的UDP连接; 标注为Linux的选项在其他平台上创建客户端时返回 ErrUnsupportedSockOpt
+type SocketOptions struct {
+	// 连接空闲多久后开始发送保活探测, 为0时使用Go的默认值(15s), 为负时关闭保活
+	KeepAlive time.Duration
+	// 保活探测的间隔, 为0时与 KeepAlive 相同 (Linux)
+	KeepAliveInterval time.Duration
+	// 连续多少次探测无响应后断开连接, 为0时使用系统默认值 (Linux)
+	KeepAliveCount int
+	// 开启Nagle算法, 即关闭 TCP_NODELAY; Go默认关闭Nagle算法, 小的写入会立即发送
+	Nagle bool
+	// 发送与接收缓冲区的大小(SO_SNDBUF / SO_RCVBUF), 为0时使用系统默认值; Linux上在连接前设置, 接收缓冲区因此可以影响窗口缩放
+	SendBuffer, RecvBuffer int
+	// 绑定的本地地址, IP或 "ip:port", 只连接与其地址族相同的服务端地址
+	LocalAddr string
+	// 绑定的网卡名称(SO_BINDTODEVICE), 需要 CAP_NET_RAW 权限 (Linux)
+	Interface string
+	// 连接的防火墙标记(SO_MARK), 用于策略路由, 需要 CAP_NET_ADMIN 权限 (Linux)
+	Mark int
+	// 报文的DSCP值(0~63), 写入IPv4的TOS或IPv6的Traffic Class的高6位 (Linux)
+	DSCP int
+	// 开启TCP Fast Open(TCP_FASTOPEN_CONNECT), 请求数据随SYN发送, 需要内核4.11及以上与服务端支持 (Linux)
+	FastOpen bool
+}
+
+// 按套接字选项连接TCP地址的系统连接建立器; unix socket 不应用套接字选项
+type netDialer struct {
+	tcp   net.Dialer
+	unix  net.Dialer
+	opts  SocketOptions
+	local net.IP
+}
+
+func newNetDialer(opts SocketOptions) (*netDialer, error) {
+	if opts.DSCP < 0 || opts.DSCP > 63 {
+		return nil, fmt.Errorf("%w: dscp %d out of range 0-63", ErrInvalidSockOpt, opts.DSCP)
+	}
+	if opts.SendBuffer < 0 || opts.RecvBuffer < 0 || opts.KeepAliveInterval < 0 || opts.KeepAliveCount < 0 {
+		return nil, fmt.Errorf("%w: negative buffer size or keepalive setting", ErrInvalidSockOpt)
+	}
+	if err := checkSocketOptions(opts); err != nil {
+		return nil, err
+	}
+	d := &netDialer{opts: opts}
+	d.tcp.KeepAlive = opts.KeepAlive
+	if opts.LocalAddr != "" {
+		host, port := opts.LocalAddr, "0"
+		if h, p, err := net.SplitHostPort(opts.LocalAddr); err == nil {
+			host, port = h, p
+		}
+		addr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(strings.Trim(host, "[]"), port))
+		if err != nil || addr.IP == nil {
+			return nil, fmt.Errorf("%w: local address %q", ErrInvalidAddress, opts.LocalAddr)
+		}
+		d.tcp.LocalAddr, d.local = addr, addr.IP
+	}
+	d.tcp.Control = controlSocket(opts)
+	return d, nil
+}
+
+func (d *netDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if network == "unix" {
+		return d.unix.DialContext(ctx, network, addr)
+	}
+	conn, err := d.tcp.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	// 连接建立后Go会重新设置 TCP_NODELAY 与保活周期, 因此这些选项在连接后设置
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return conn, nil
+	}
+	if d.opts.Nagle {
+		err = tcpConn.SetNoDelay(false)
+	}
+	if err == nil {
+		err = tuneConn(tcpConn, d.opts)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// 绑定了本地地址时, 只保留与其地址族相同的服务端地址
+func (d *netDialer) filterFamily(ips []net.IP) []net.IP {
+	if d.local == nil || d.local.IsUnspecified() {
+		return ips
+	}
+	v4 := d.local.To4() != nil
+	matched := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if (ip.To4() != nil) == v4 {
+			matched = append(matched, ip)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,94 @@
+package HiHttp
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// 内核4.11起支持, syscall 包中没有定义
+const tcpFastOpenConnect = 0x1e
+
+// Linux支持全部选项
+func checkSocketOptions(opts SocketOptions) error {
+	return nil
+}
+
+// 在连接前设置的选项
+func controlSocket(opts SocketOptions) func(network, address string, c syscall.RawConn) error {
+	if opts.SendBuffer == 0 && opts.RecvBuffer == 0 && opts.Interface == "" && opts.Mark == 0 && opts.DSCP == 0 && !opts.FastOpen {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		setInt := func(fd, level, name, value int, what string) {
+			if err == nil {
+				if e := syscall.SetsockoptInt(fd, level, name, value); e != nil {
+					err = os.NewSyscallError("setsockopt "+what, e)
+				}
+			}
+		}
+		ctrlErr := c.Control(func(s uintptr) {
+			fd := int(s)
+			if opts.SendBuffer > 0 {
+				setInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, opts.SendBuffer, "SO_SNDBUF")
+			}
+			if opts.RecvBuffer > 0 {
+				setInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, opts.RecvBuffer, "SO_RCVBUF")
+			}
+			if opts.Interface != "" && err == nil {
+				if e := syscall.SetsockoptString(fd, syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, opts.Interface); e != nil {
+					err = os.NewSyscallError("setsockopt SO_BINDTODEVICE", e)
+				}
+			}
+			if opts.Mark != 0 {
+				setInt(fd, syscall.SOL_SOCKET, syscall.SO_MARK, opts.Mark, "SO_MARK")
+			}
+			if opts.DSCP != 0 {
+				if network == "tcp6" {
+					setInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, opts.DSCP<<2, "IPV6_TCLASS")
+				} else {
+					setInt(fd, syscall.IPPROTO_IP, syscall.IP_TOS, opts.DSCP<<2, "IP_TOS")
+				}
+			}
+			if opts.FastOpen {
+				setInt(fd, syscall.IPPROTO_TCP, tcpFastOpenConnect, 1, "TCP_FASTOPEN_CONNECT")
+			}
+		})
+		if ctrlErr != nil {
+			return ctrlErr
+		}
+		return err
+	}
+}
+
+// 在连接后设置保活探测的间隔与次数, 覆盖Go按 KeepAlive 设置的间隔
+func tuneConn(conn *net.TCPConn, opts SocketOptions) error {
+	if opts.KeepAlive < 0 || (opts.KeepAliveInterval == 0 && opts.KeepAliveCount == 0) {
+		return nil
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = raw.Control(func(s uintptr) {
+		fd := int(s)
+		if opts.KeepAliveInterval > 0 {
+			secs := int((opts.KeepAliveInterval + 999e6) / 1e9)
+			if e := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, secs); e != nil {
+				sockErr = os.NewSyscallError("setsockopt TCP_KEEPINTVL", e)
+				return
+			}
+		}
+		if opts.KeepAliveCount > 0 {
+			if e := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, opts.KeepAliveCount); e != nil {
+				sockErr = os.NewSyscallError("setsockopt TCP_KEEPCNT", e)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
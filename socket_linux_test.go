@@ -0,0 +1,115 @@
+package HiHttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 测试套接字选项在连接上生效
+func TestNetDialer_Options(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	opts := SocketOptions{
+		KeepAlive:         30 * time.Second,
+		KeepAliveInterval: 5 * time.Second,
+		KeepAliveCount:    3,
+		Nagle:             true,
+		SendBuffer:        64 << 10,
+		RecvBuffer:        64 << 10,
+		Mark:              42,
+		DSCP:              46,
+		FastOpen:          true,
+	}
+	d, err := newNetDialer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	raw, _ := conn.(*net.TCPConn).SyscallConn()
+	checks := []struct {
+		name       string
+		level, opt int
+		want       int
+		atLeast    bool
+	}{
+		{"TCP_KEEPIDLE", syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, 30, false},
+		{"TCP_KEEPINTVL", syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, 5, false},
+		{"TCP_KEEPCNT", syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, 3, false},
+		{"TCP_NODELAY", syscall.IPPROTO_TCP, syscall.TCP_NODELAY, 0, false},
+		{"SO_MARK", syscall.SOL_SOCKET, syscall.SO_MARK, 42, false},
+		{"IP_TOS", syscall.IPPROTO_IP, syscall.IP_TOS, 46 << 2, false},
+		{"TCP_FASTOPEN_CONNECT", syscall.IPPROTO_TCP, tcpFastOpenConnect, 1, false},
+		// 内核将缓冲区大小加倍以容纳簿记开销
+		{"SO_SNDBUF", syscall.SOL_SOCKET, syscall.SO_SNDBUF, 64 << 10, true},
+		{"SO_RCVBUF", syscall.SOL_SOCKET, syscall.SO_RCVBUF, 64 << 10, true},
+	}
+	_ = raw.Control(func(fd uintptr) {
+		for _, c := range checks {
+			v, err := syscall.GetsockoptInt(int(fd), c.level, c.opt)
+			if err != nil {
+				t.Errorf("%s: %v", c.name, err)
+			} else if c.atLeast && v < c.want || !c.atLeast && v != c.want {
+				t.Errorf("%s = %d", c.name, v)
+			}
+		}
+	})
+
+	for _, bad := range []SocketOptions{{DSCP: 64}, {SendBuffer: -1}, {LocalAddr: "nope"}} {
+		if _, err := newNetDialer(bad); !errors.Is(err, ErrInvalidSockOpt) && !errors.Is(err, ErrInvalidAddress) {
+			t.Errorf("%+v: expected error, got %v", bad, err)
+		}
+	}
+}
+
+// 测试绑定本地地址与网卡, 且只连接与本地地址同一地址族的服务端地址
+func TestHttpClient_Socket_LocalAddr(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.RemoteAddr))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	client, err := HiHttp(context.Background(), "http://dual.test:"+port, Options{
+		DNS:    DNSOptions{Hosts: map[string][]string{"dual.test": {"::1", "127.0.0.1"}}},
+		Socket: SocketOptions{LocalAddr: "127.0.0.2", Interface: "lo"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	result, err := client.Get("/")
+	if err != nil {
+		if errors.Is(err, syscall.EPERM) {
+			t.Skip("binding to an interface requires CAP_NET_RAW")
+		}
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(result, "127.0.0.2:") {
+		t.Fatalf("remote addr seen by server = %s", result)
+	}
+	if _, err := client.Get("http://[::1]:" + port + "/"); !errors.Is(err, ErrNoAddress) {
+		t.Fatalf("expected ErrNoAddress for an ipv6 host, got %v", err)
+	}
+}
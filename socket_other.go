@@ -0,0 +1,47 @@
+//go:build !linux
+// +build !linux
+
+package HiHttp
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// 只支持可以通过 net 包设置的选项
+func checkSocketOptions(opts SocketOptions) error {
+	switch {
+	case opts.KeepAliveInterval != 0:
+		return fmt.Errorf("%w: KeepAliveInterval", ErrUnsupportedSockOpt)
+	case opts.KeepAliveCount != 0:
+		return fmt.Errorf("%w: KeepAliveCount", ErrUnsupportedSockOpt)
+	case opts.Interface != "":
+		return fmt.Errorf("%w: Interface", ErrUnsupportedSockOpt)
+	case opts.Mark != 0:
+		return fmt.Errorf("%w: Mark", ErrUnsupportedSockOpt)
+	case opts.DSCP != 0:
+		return fmt.Errorf("%w: DSCP", ErrUnsupportedSockOpt)
+	case opts.FastOpen:
+		return fmt.Errorf("%w: FastOpen", ErrUnsupportedSockOpt)
+	}
+	return nil
+}
+
+// 无法在连接前设置选项
+func controlSocket(opts SocketOptions) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
+
+// 缓冲区大小在连接后设置, 接收缓冲区不再影响窗口缩放
+func tuneConn(conn *net.TCPConn, opts SocketOptions) error {
+	if opts.SendBuffer > 0 {
+		if err := conn.SetWriteBuffer(opts.SendBuffer); err != nil {
+			return err
+		}
+	}
+	if opts.RecvBuffer > 0 {
+		return conn.SetReadBuffer(opts.RecvBuffer)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package HiHttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 分段输出响应体的服务端, 每段之间间隔gap
+func newDripServer(t *testing.T, chunks int, gap time.Duration) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < chunks; i++ {
+			_, _ = w.Write([]byte("x"))
+			w.(http.Flusher).Flush()
+			time.Sleep(gap)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// 测试等待响应头超时
+func TestHttpClient_Header_Timeout(t *testing.T) {
+	server := newRawServer(t, func(req *http.Request, _ int) (string, bool) {
+		time.Sleep(300 * time.Millisecond)
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", false
+	})
+	defer server.Close()
+	client, err := HiHttp(context.Background(), server.URL(), Options{Timeouts: Timeouts{Header: 50 * time.Millisecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	if _, err := client.Get("/"); !errors.Is(err, ErrHeaderTimeout) || !errors.Is(err, ErrRequestTimeout) {
+		t.Fatalf("expected ErrHeaderTimeout, got %v", err)
+	}
+}
+
+// 测试读取空闲超时在每次读到数据后重新计时: 响应体总耗时超过空闲超时仍能读完, 单次停顿过长则超时
+func TestHttpClient_Idle_Timeout(t *testing.T) {
+	client, err := HiHttp(context.Background(), newDripServer(t, 6, 40*time.Millisecond).URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	client.SetReadTimeout(150 * time.Millisecond)
+	if result, err := client.Get("/"); err != nil || result != "xxxxxx" {
+		t.Fatalf("slow body: %q, %v", result, err)
+	}
+
+	client, err = HiHttp(context.Background(), newDripServer(t, 3, 300*time.Millisecond).URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	client.SetTimeouts(Timeouts{Idle: 100 * time.Millisecond})
+	if _, err := client.Get("/"); !errors.Is(err, ErrIdleTimeout) {
+		t.Fatalf("expected ErrIdleTimeout, got %v", err)
+	}
+}
+
+// 测试服务端接受连接后不进行TLS握手时的握手超时
+func TestHttpClient_TLS_Timeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	client, err := HiHttp(context.Background(), "https://"+listener.Addr().String(), Options{
+		Timeouts: Timeouts{TLS: 100 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	start := time.Now()
+	_, err = client.Get("/")
+	if !errors.Is(err, ErrTLSTimeout) || !errors.Is(err, ErrConnectingTimeout) {
+		t.Fatalf("expected ErrTLSTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("handshake timeout took %v", elapsed)
+	}
+}
+
+// 测试总时长覆盖所有重试
+func TestHttpClient_Total_Timeout(t *testing.T) {
+	var requests int32
+	server := newRawServer(t, func(req *http.Request, _ int) (string, bool) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(150 * time.Millisecond)
+		return "HTTP/1.1 500 Internal Server Error\r\nContent-Length: 0\r\n\r\n", false
+	})
+	defer server.Close()
+	for _, opts := range []Options{
+		{Retry: 10, Timeouts: Timeouts{Total: 400 * time.Millisecond}},
+		{Retry: 10, Pipeline: 2, Timeouts: Timeouts{Total: 400 * time.Millisecond}},
+	} {
+		atomic.StoreInt32(&requests, 0)
+		client, err := HiHttp(context.Background(), server.URL(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		_, err = client.Get("/")
+		client.End()
+		if !errors.Is(err, ErrTotalTimeout) {
+			t.Fatalf("pipeline %d: expected ErrTotalTimeout, got %v", opts.Pipeline, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("pipeline %d: total timeout took %v", opts.Pipeline, elapsed)
+		}
+		if n := atomic.LoadInt32(&requests); n > 3 {
+			t.Fatalf("pipeline %d: server received %d requests, want <= 3", opts.Pipeline, n)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package HiHttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors
+var (
+	ErrTLSOptions  = errors.New("invalid tls options")
+	ErrPinMismatch = errors.New("server certificate matches no pinned key or fingerprint")
+)
+
+// TLS会话缓存的默认容量
+const DefSessionCache = 64
+
+// 检查客户端证书文件是否更新的最小间隔, 握手与会话缓存不会每次都访问文件系统
+var certCheckInterval = time.Second
+
+// HTTPS的证书配置, 在 Options.TLSConfig 的基础上生效, 作用于所有源与HTTP3
+type TLSOptions struct {
+	// 客户端证书与私钥的PEM文件(双向TLS); 每秒最多检查一次文件的修改时间, 变化后在下一次握手时重新加载,
+	// 证书轮换无需重建客户端;
+	// 新文件暂时无法加载(例: 只写入了其中一个)时继续使用之前的证书
+	CertFile, KeyFile string
+	// 信任的根证书PEM文件, 每个文件可以包含多个证书; 设置后替代系统根证书, 与 curl --cacert 相同
+	RootCAFiles []string
+	// 公钥固定: 验证得到的证书链中须有证书的 SubjectPublicKeyInfo 的SHA-256在其中, 以base64表示,
+	// 可带 "sha256//" 前缀, 与 curl --pinnedpubkey 相同; 在证书验证之外额外检查
+	PinnedSPKI []string
+	// 证书指纹固定: 验证得到的证书链中须有证书的DER编码的SHA-256在其中, 以十六进制表示, 可带冒号
+	PinnedCerts []string
+	// 不验证服务端的证书链与主机名, 任何中间人都可以冒充服务端; 仅供开发使用, 固定的公钥与指纹仍然检查, 但只匹配服务端证书本身
+	InsecureSkipVerifyDevelopmentOnly bool
+	// TLS会话缓存的容量, 缓存TLS 1.2的会话票据与TLS 1.3的PSK, 重新连接时恢复会话以省去证书交换;
+	// 为0时为 DefSessionCache, 为负时不缓存; TLSConfig 中设置了 ClientSessionCache 时使用它
+	SessionCache int
+}
+
+// 响应所在连接的TLS信息
+type TLSInfo struct {
+	Version     uint16 // 协商的TLS版本, 例: tls.VersionTLS13
+	CipherSuite uint16 // 协商的密码套件
+	Protocol    string // ALPN协商的协议, 例: "h2", 未协商时为空
+	ServerName  string // 握手时发送的SNI, 以IP访问时为空
+	// 服务端发送的证书链, 第一个为服务端证书; 恢复的会话为建立会话时的证书链
+	PeerCertificates []*x509.Certificate
+	Resumed          bool // 连接通过会话恢复建立, 握手省去了证书交换
+	// 握手时实际发出的ClientHello, 可由它计算JA3指纹; HTTP3 为nil
+	ClientHello *ClientHelloSpec
+}
+
+func newTLSInfo(cs tls.ConnectionState) *TLSInfo {
+	return &TLSInfo{
+		Version:          cs.Version,
+		CipherSuite:      cs.CipherSuite,
+		Protocol:         cs.NegotiatedProtocol,
+		ServerName:       cs.ServerName,
+		PeerCertificates: cs.PeerCertificates,
+		Resumed:          cs.DidResume,
+	}
+}
+
+// TLS版本的名称, 例: "TLS 1.3"
+func (i *TLSInfo) VersionName() string {
+	switch i.Version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04X", i.Version)
+}
+
+// 密码套件的名称, 例: "TLS_AES_128_GCM_SHA256"
+func (i *TLSInfo) CipherSuiteName() string {
+	return tls.CipherSuiteName(i.CipherSuite)
+}
+
+// 连接的TLS信息, 非TLS连接为nil
+func connTLSInfo(conn net.Conn) *TLSInfo {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		info := newTLSInfo(tlsConn.ConnectionState())
+		info.ClientHello = sentClientHello(tlsConn)
+		return info
+	}
+	return nil
+}
+
+// 在自定义TLS配置的基础上应用证书配置, 得到各个源复制使用的基础配置
+func newTLSConfig(base *tls.Config, opts TLSOptions) (*tls.Config, error) {
+	config := &tls.Config{}
+	if base != nil {
+		config = base.Clone()
+	}
+	var reloader *certReloader
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		if opts.CertFile == "" || opts.KeyFile == "" {
+			return nil, fmt.Errorf("%w: client certificate requires both CertFile and KeyFile", ErrTLSOptions)
+		}
+		reloader = &certReloader{certFile: opts.CertFile, keyFile: opts.KeyFile}
+		if _, err := reloader.get(); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrTLSOptions, err)
+		}
+		config.Certificates = nil
+		config.GetClientCertificate = reloader.getClientCertificate
+	}
+	if len(opts.RootCAFiles) > 0 {
+		pool := x509.NewCertPool()
+		for _, file := range opts.RootCAFiles {
+			pem, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrTLSOptions, err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("%w: no certificates in %s", ErrTLSOptions, file)
+			}
+		}
+		config.RootCAs = pool
+	}
+	pins, err := parsePins(opts.PinnedSPKI, opts.PinnedCerts)
+	if err != nil {
+		return nil, err
+	}
+	if pins != nil {
+		verify := config.VerifyConnection
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			if verify != nil {
+				if err := verify(cs); err != nil {
+					return err
+				}
+			}
+			return pins.verify(cs)
+		}
+	}
+	if opts.InsecureSkipVerifyDevelopmentOnly {
+		config.InsecureSkipVerify = true
+	}
+	if config.ClientSessionCache == nil && opts.SessionCache >= 0 {
+		size := opts.SessionCache
+		if size == 0 {
+			size = DefSessionCache
+		}
+		config.ClientSessionCache = tls.NewLRUClientSessionCache(size)
+	}
+	if config.ClientSessionCache != nil && reloader != nil {
+		config.ClientSessionCache = &certSessionCache{ClientSessionCache: config.ClientSessionCache, reloader: reloader}
+	}
+	return config, nil
+}
+
+// 客户端证书, 按文件的修改时间热加载
+type certReloader struct {
+	certFile, keyFile string
+	lock              sync.Mutex
+	cert              *tls.Certificate
+	certMod, keyMod   time.Time
+	checked           time.Time // 上次检查文件的时间
+	gen               uint64    // 加载的次数, 即当前证书的版本
+}
+
+func (r *certReloader) get() (*tls.Certificate, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.reload()
+}
+
+// 距上次检查超过 certCheckInterval 时检查文件并在修改后重新加载, 调用时需持有锁
+func (r *certReloader) reload() (*tls.Certificate, error) {
+	if r.cert != nil && time.Since(r.checked) < certCheckInterval {
+		return r.cert, nil
+	}
+	r.checked = time.Now()
+	certInfo, err := os.Stat(r.certFile)
+	var keyInfo os.FileInfo
+	if err == nil {
+		keyInfo, err = os.Stat(r.keyFile)
+	}
+	if err == nil && r.cert != nil && certInfo.ModTime().Equal(r.certMod) && keyInfo.ModTime().Equal(r.keyMod) {
+		return r.cert, nil
+	}
+	var cert tls.Certificate
+	if err == nil {
+		cert, err = tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	}
+	if err != nil {
+		// 轮换过程中文件可能暂时缺失或不匹配, 继续使用之前的证书, 下次握手时再尝试
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		return nil, err
+	}
+	r.cert, r.certMod, r.keyMod = &cert, certInfo.ModTime(), keyInfo.ModTime()
+	r.gen++
+	return r.cert, nil
+}
+
+// 当前证书的版本, 与握手使用同样的检查间隔
+func (r *certReloader) generation() uint64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	_, _ = r.reload()
+	return r.gen
+}
+
+func (r *certReloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.get()
+}
+
+// 恢复的会话沿用建立会话时的客户端证书身份, 且恢复时服务端不再请求证书,
+// 因此会话按证书的版本缓存: 证书轮换后不再恢复之前的会话, 旧的会话随LRU淘汰
+type certSessionCache struct {
+	tls.ClientSessionCache
+	reloader *certReloader
+}
+
+func (c *certSessionCache) Get(key string) (*tls.ClientSessionState, bool) {
+	return c.ClientSessionCache.Get(c.key(key))
+}
+
+func (c *certSessionCache) Put(key string, cs *tls.ClientSessionState) {
+	c.ClientSessionCache.Put(c.key(key), cs)
+}
+
+func (c *certSessionCache) key(key string) string {
+	return strconv.FormatUint(c.reloader.generation(), 10) + "|" + key
+}
+
+// 固定的公钥与证书指纹, 均为SHA-256
+type certPins struct {
+	spki  [][]byte
+	certs [][]byte
+}
+
+func parsePins(spki, certs []string) (*certPins, error) {
+	if len(spki) == 0 && len(certs) == 0 {
+		return nil, nil
+	}
+	pins := &certPins{}
+	for _, pin := range spki {
+		sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(pin), "sha256//"))
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("%w: invalid spki pin %q", ErrTLSOptions, pin)
+		}
+		pins.spki = append(pins.spki, sum)
+	}
+	for _, pin := range certs {
+		sum, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(pin), ":", ""))
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("%w: invalid certificate fingerprint %q", ErrTLSOptions, pin)
+		}
+		pins.certs = append(pins.certs, sum)
+	}
+	return pins, nil
+}
+
+// 检查证书是否匹配: 验证证书时只匹配验证得到的证书链(包括本地信任的根证书), 服务端附带的其它证书不算;
+// 跳过验证时只匹配服务端证书本身
+func (p *certPins) verify(cs tls.ConnectionState) error {
+	var chain []*x509.Certificate
+	if len(cs.VerifiedChains) > 0 {
+		for _, verified := range cs.VerifiedChains {
+			chain = append(chain, verified...)
+		}
+	} else if len(cs.PeerCertificates) > 0 {
+		chain = cs.PeerCertificates[:1]
+	}
+	for _, cert := range chain {
+		spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		der := sha256.Sum256(cert.Raw)
+		for _, pin := range p.spki {
+			if bytes.Equal(pin, spki[:]) {
+				return nil
+			}
+		}
+		for _, pin := range p.certs {
+			if bytes.Equal(pin, der[:]) {
+				return nil
+			}
+		}
+	}
+	return ErrPinMismatch
+}
@@ -0,0 +1,375 @@
+package HiHttp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 测试用的证书颁发机构
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "HiHttp Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// 签发证书, 返回证书与PEM编码的证书和私钥; 服务端证书对127.0.0.1与api.test有效
+func (ca *testCA) issue(t *testing.T, cn string, client bool) (tls.Certificate, []byte, []byte) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		DNSNames:     []string{"api.test"},
+	}
+	if client {
+		tmpl.ExtKeyUsage, tmpl.IPAddresses, tmpl.DNSNames = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, nil, nil
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert.Leaf, _ = x509.ParseCertificate(der)
+	return cert, certPEM, keyPEM
+}
+
+// 启动使用CA签发的证书的HTTPS服务, clientCA 不为空时要求客户端证书; 响应客户端证书的CN
+func newTLSServer(t *testing.T, ca *testCA, clientCA *testCA) *httptest.Server {
+	cert, _, _ := ca.issue(t, "server", false)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+		}
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	if clientCA != nil {
+		pool := x509.NewCertPool()
+		pool.AddCert(clientCA.cert)
+		server.TLS.ClientAuth, server.TLS.ClientCAs = tls.RequireAndVerifyClientCert, pool
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func writeFile(t *testing.T, path string, data []byte, mod time.Time) {
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Chtimes(path, mod, mod)
+}
+
+// 测试根证书文件、双向TLS以及客户端证书的热加载
+func TestHttpClient_TLS_ClientCert_Reload(t *testing.T) {
+	// 每次握手都检查证书文件
+	defer func(interval time.Duration) { certCheckInterval = interval }(certCheckInterval)
+	certCheckInterval = 0
+	ca := newTestCA(t)
+	server := newTLSServer(t, ca, ca)
+	dir := t.TempDir()
+	caFile, certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	writeFile(t, caFile, ca.pem, time.Now())
+	_, certPEM, keyPEM := ca.issue(t, "client-1", true)
+	writeFile(t, certFile, certPEM, time.Now())
+	writeFile(t, keyFile, keyPEM, time.Now())
+
+	get := func(opts TLSOptions) (string, error) {
+		client, err := HiHttp(context.Background(), server.URL, Options{TLS: opts})
+		if err != nil {
+			return "", err
+		}
+		defer client.End()
+		return client.Get("/")
+	}
+	// 系统根证书不信任测试CA
+	if _, err := get(TLSOptions{}); err == nil {
+		t.Fatal("expected unknown authority error")
+	}
+	// 服务端要求客户端证书
+	if _, err := get(TLSOptions{RootCAFiles: []string{caFile}}); err == nil {
+		t.Fatal("expected handshake failure without a client certificate")
+	}
+
+	client, err := HiHttp(context.Background(), server.URL, Options{TLS: TLSOptions{
+		RootCAFiles: []string{caFile},
+		CertFile:    certFile,
+		KeyFile:     keyFile,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	expect := func(cn string) {
+		t.Helper()
+		// 关闭连接, 下次请求重新握手
+		client.End()
+		if result, err := client.Get("/"); err != nil || result != cn {
+			t.Fatalf("got %q, %v, want %s", result, err, cn)
+		}
+	}
+	expect("client-1")
+	// 轮换证书
+	_, certPEM, keyPEM = ca.issue(t, "client-2", true)
+	later := time.Now().Add(time.Hour)
+	writeFile(t, certFile, certPEM, later)
+	writeFile(t, keyFile, keyPEM, later)
+	expect("client-2")
+	// 只替换了证书, 与私钥不匹配时继续使用之前的证书
+	_, certPEM, _ = ca.issue(t, "client-3", true)
+	writeFile(t, certFile, certPEM, later.Add(time.Hour))
+	expect("client-2")
+
+	if _, err := HiHttp(context.Background(), server.URL, Options{TLS: TLSOptions{CertFile: certFile}}); !errors.Is(err, ErrTLSOptions) {
+		t.Fatalf("expected ErrTLSOptions, got %v", err)
+	}
+	if _, err := HiHttp(context.Background(), server.URL, Options{TLS: TLSOptions{RootCAFiles: []string{keyFile}}}); !errors.Is(err, ErrTLSOptions) {
+		t.Fatalf("expected ErrTLSOptions, got %v", err)
+	}
+}
+
+// 测试公钥与证书指纹固定, 以及跳过证书验证
+func TestHttpClient_TLS_Pinning(t *testing.T) {
+	ca := newTestCA(t)
+	server := newTLSServer(t, ca, nil)
+	leaf := server.TLS.Certificates[0].Leaf
+	spki := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	caSum := sha256.Sum256(ca.cert.Raw)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	wrong := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	cases := []struct {
+		name string
+		opts Options
+		err  error
+	}{
+		{"insecure", Options{TLS: TLSOptions{InsecureSkipVerifyDevelopmentOnly: true}}, nil},
+		{"insecure with spki pin", Options{TLS: TLSOptions{InsecureSkipVerifyDevelopmentOnly: true,
+			PinnedSPKI: []string{"sha256//" + base64.StdEncoding.EncodeToString(spki[:])}}}, nil},
+		{"insecure with wrong pin", Options{TLS: TLSOptions{InsecureSkipVerifyDevelopmentOnly: true,
+			PinnedSPKI: []string{wrong}}}, ErrPinMismatch},
+		{"ca fingerprint", Options{TLSConfig: &tls.Config{RootCAs: pool}, TLS: TLSOptions{
+			PinnedCerts: []string{hex.EncodeToString(caSum[:])}}}, nil},
+		{"wrong pin with valid chain", Options{TLSConfig: &tls.Config{RootCAs: pool}, TLS: TLSOptions{
+			PinnedSPKI: []string{wrong}}}, ErrPinMismatch},
+	}
+	for _, c := range cases {
+		client, err := HiHttp(context.Background(), server.URL, c.opts)
+		if err != nil {
+			t.Fatal(c.name, err)
+		}
+		_, err = client.Get("/")
+		client.End()
+		if c.err == nil && err != nil || c.err != nil && !errors.Is(err, c.err) {
+			t.Fatalf("%s: got %v, want %v", c.name, err, c.err)
+		}
+	}
+	// 服务端在证书链中附带被固定的证书, 但它不在验证得到的链中, 也不是服务端证书本身
+	pinned, _, _ := newTestCA(t).issue(t, "pinned", false)
+	extra := newTLSServer(t, ca, nil)
+	extra.TLS.Certificates[0].Certificate = append(extra.TLS.Certificates[0].Certificate, pinned.Certificate[0])
+	pinnedSum := sha256.Sum256(pinned.Leaf.RawSubjectPublicKeyInfo)
+	pin := []string{"sha256//" + base64.StdEncoding.EncodeToString(pinnedSum[:])}
+	for _, opts := range []Options{
+		{TLSConfig: &tls.Config{RootCAs: pool}, TLS: TLSOptions{PinnedSPKI: pin}},
+		{TLS: TLSOptions{InsecureSkipVerifyDevelopmentOnly: true, PinnedSPKI: pin}},
+	} {
+		client, err := HiHttp(context.Background(), extra.URL, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.Get("/")
+		client.End()
+		if !errors.Is(err, ErrPinMismatch) {
+			t.Fatalf("extra chain entry: expected ErrPinMismatch, got %v", err)
+		}
+	}
+	for _, pins := range []TLSOptions{{PinnedSPKI: []string{"abc"}}, {PinnedCerts: []string{"zz"}}} {
+		if _, err := HiHttp(context.Background(), server.URL, Options{TLS: pins}); !errors.Is(err, ErrTLSOptions) {
+			t.Fatalf("%+v: expected ErrTLSOptions, got %v", pins, err)
+		}
+	}
+}
+
+// 测试重新连接时恢复TLS会话: 第二次握手为简短握手, 客户端与服务端都报告会话已恢复
+func TestHttpClient_TLS_Session_Resumption(t *testing.T) {
+	ca := newTestCA(t)
+	cert, _, _ := ca.issue(t, "server", false)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, r.TLS.DidResume)
+		}))
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}, MaxVersion: version}
+		server.StartTLS()
+		for _, size := range []int{0, -1} {
+			client, err := HiHttp(context.Background(), server.URL, Options{TLSConfig: &tls.Config{RootCAs: pool}, TLS: TLSOptions{SessionCache: size}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 3; i++ {
+				res, err := client.Do("GET", "/", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resumed := i > 0 && size >= 0
+				if res.TLS == nil || res.TLS.Resumed != resumed || res.Body != fmt.Sprint(resumed) {
+					t.Fatalf("tls %x cache %d request %d: client resumed %+v, server resumed %s", version, size, i, res.TLS, res.Body)
+				}
+				client.End()
+			}
+		}
+		server.Close()
+	}
+	// 非HTTPS的响应没有TLS信息
+	client, err := HiHttp(context.Background(), "http://localhost:888", Options{Dialer: devDialer(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	if res, err := client.Do("get", "/hello", nil); err != nil || res.TLS != nil || res.Status != 200 {
+		t.Fatalf("%+v, %v", res, err)
+	}
+}
+
+// 测试响应中的TLS版本、密码套件、ALPN协议、SNI与证书链
+func TestHttpClient_TLS_Info(t *testing.T) {
+	ca := newTestCA(t)
+	cert, _, _ := ca.issue(t, "server", false)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"h2", "http/1.1"}}
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	for version, alpn := range map[string]string{HTTP11: "http/1.1", HTTP2: "h2"} {
+		client, err := HiHttp(context.Background(), "https://api.test:"+port, Options{
+			Version:   version,
+			TLSConfig: &tls.Config{RootCAs: pool},
+			DNS:       DNSOptions{Hosts: map[string][]string{"api.test": {"127.0.0.1"}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := client.Do("GET", "/", nil)
+		client.End()
+		if err != nil || res.Status != 200 {
+			t.Fatalf("%s: %d, %v", version, res.Status, err)
+		}
+		info := res.TLS
+		if info == nil || info.Version != tls.VersionTLS13 || info.VersionName() != "TLS 1.3" ||
+			info.CipherSuiteName() != tls.CipherSuiteName(info.CipherSuite) || info.Protocol != alpn ||
+			info.ServerName != "api.test" {
+			t.Fatalf("%s: %+v", version, info)
+		}
+		if len(info.PeerCertificates) != 1 || info.PeerCertificates[0].Subject.CommonName != "server" {
+			t.Fatalf("%s: unexpected chain %v", version, info.PeerCertificates)
+		}
+	}
+}
+
+// 测试报告的ClientHello与服务端收到的一致, 并随 TLSConfig 变化
+func TestHttpClient_TLS_ClientHello(t *testing.T) {
+	ca := newTestCA(t)
+	cert, _, _ := ca.issue(t, "server", false)
+	var received *tls.ClientHelloInfo
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			received = info
+			return nil, nil
+		},
+	}
+	server.StartTLS()
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	get := func(config *tls.Config) *TLSInfo {
+		t.Helper()
+		config.RootCAs = pool
+		client, err := HiHttp(context.Background(), "https://api.test:"+port, Options{
+			TLSConfig: config,
+			TLS:       TLSOptions{SessionCache: -1},
+			DNS:       DNSOptions{Hosts: map[string][]string{"api.test": {"127.0.0.1"}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.End()
+		res, err := client.Do("GET", "/", nil)
+		if err != nil || res.TLS == nil || res.TLS.ClientHello == nil {
+			t.Fatalf("%+v, %v", res.TLS, err)
+		}
+		hello := res.TLS.ClientHello
+		if !reflect.DeepEqual(hello.CipherSuites, received.CipherSuites) ||
+			!reflect.DeepEqual(hello.SupportedVersions, received.SupportedVersions) || hello.ServerName != "api.test" {
+			t.Fatalf("reported %+v, server received %+v", hello, received)
+		}
+		return res.TLS
+	}
+
+	base := get(&tls.Config{})
+	if base.Version != tls.VersionTLS13 || base.ClientHello.JA3Hash() == "" {
+		t.Fatalf("base: %+v", base)
+	}
+	tls12 := get(&tls.Config{MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305}})
+	if tls12.Version != tls.VersionTLS12 || tls12.CipherSuite != tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305 ||
+		tls12.ClientHello.JA3Hash() == base.ClientHello.JA3Hash() {
+		t.Fatalf("tls12: %+v", tls12)
+	}
+}
@@ -0,0 +1,76 @@
+package HiHttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 在临时目录的 unix socket 上启动HTTP服务, 返回 socket 路径
+func newUnixServer(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "hi.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			w.Header().Set("Transfer-Encoding", "chunked")
+		}
+		_, _ = w.Write([]byte(r.Method + " " + r.Host + " " + r.URL.RequestURI()))
+	})}
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() { _ = server.Close() })
+	return path
+}
+
+// 测试通过 http+unix:// 地址与 UnixSocket 选项访问 unix socket 上的服务
+func TestHttpClient_Unix_Socket(t *testing.T) {
+	path := newUnixServer(t)
+	for _, opts := range []Options{{}, {Pipeline: 2}} {
+		client, err := HiHttp(context.Background(), "http+unix://"+url.PathEscape(path)+"/v1", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if result, err := client.Get("/info?all=1"); err != nil || result != "GET localhost /v1/info?all=1" {
+				t.Fatalf("pipeline %d: %q, %v", opts.Pipeline, result, err)
+			}
+		}
+		if result, err := client.Post("/create", strings.NewReader("{}")); err != nil || result != "POST localhost /v1/create" {
+			t.Fatalf("pipeline %d: post %q, %v", opts.Pipeline, result, err)
+		}
+		client.End()
+	}
+
+	// 所有请求经由 socket 发送, 地址中的主机作为 Host 请求头
+	client, err := HiHttp(context.Background(), "http://docker/v1.41", Options{UnixSocket: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	if result, err := client.Get("/containers/json"); err != nil || result != "GET docker /v1.41/containers/json" {
+		t.Fatalf("socket option: %q, %v", result, err)
+	}
+
+	// 未设置基础地址的客户端访问绝对的 http+unix 地址
+	client, err = HiHttp(context.Background(), "", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.End()
+	if result, err := client.Get("http+unix://" + url.PathEscape(path) + "/ping"); err != nil || result != "GET localhost /ping" {
+		t.Fatalf("absolute: %q, %v", result, err)
+	}
+	if _, err := client.Get("http+unix://" + url.PathEscape(path+".missing") + "/ping"); !errors.Is(err, ErrConnectingTimeout) {
+		t.Fatalf("expected ErrConnectingTimeout, got %v", err)
+	}
+	if _, err := HiHttp(context.Background(), "http+unix:///ping", Options{}); !errors.Is(err, ErrInvalidUrl) {
+		t.Fatalf("expected ErrInvalidUrl, got %v", err)
+	}
+}
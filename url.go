@@ -0,0 +1,189 @@
+package HiHttp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Errors
+var ErrInvalidUrl = errors.New("invalid url")
+
+// 解析后的基础地址
+type baseURL struct {
+	scheme   string        // http / https
+	host     string        // 用于拨号的 host:port, IPv6 地址带方括号
+	hostname string        // 不含端口的主机名
+	header   string        // Host 请求头, 默认端口时省略端口
+	path     string        // 已转义的基础路径, 不以"/"结尾
+	user     *url.Userinfo // 地址中携带的用户信息
+	socket   string        // unix socket 路径, 为空时通过TCP连接
+}
+
+// 解析客户端的基础地址, 未指定协议时默认为http, 例: "localhost:888" -> "http://localhost:888"
+func parseBaseUrl(rawUrl string) (*baseURL, error) {
+	rawUrl = strings.TrimSpace(rawUrl)
+	if !strings.Contains(rawUrl, "://") {
+		rawUrl = "http://" + rawUrl
+	}
+	if idx := strings.Index(rawUrl, "://"); strings.HasSuffix(strings.ToLower(rawUrl[:idx]), unixSuffix) {
+		return parseUnixUrl(rawUrl, idx)
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUrl, err)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return nil, fmt.Errorf("%w: unsupported scheme %q", ErrInvalidUrl, u.Scheme)
+	}
+	hostname := u.Hostname()
+	if hostname == "" {
+		return nil, fmt.Errorf("%w: missing host in %q", ErrInvalidUrl, rawUrl)
+	}
+	defPort := If(scheme == "https", "443", "80").(string)
+	port := u.Port()
+	if port == "" {
+		port = defPort
+	} else if strings.Trim(port, "0123456789") != "" {
+		return nil, fmt.Errorf("%w: invalid port %q", ErrInvalidUrl, port)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("%w: base url must not contain query or fragment", ErrInvalidUrl)
+	}
+	base := &baseURL{
+		scheme:   scheme,
+		host:     net.JoinHostPort(hostname, port),
+		hostname: hostname,
+		header:   u.Host,
+		path:     strings.TrimSuffix(u.EscapedPath(), "/"),
+		user:     u.User,
+	}
+	if port == defPort {
+		base.header = If(strings.Contains(hostname, ":"), "["+hostname+"]", hostname).(string)
+	}
+	return base, nil
+}
+
+// unix socket 地址的协议后缀, 例: http+unix://%2Fvar%2Frun%2Fdocker.sock/v1.41/info
+const unixSuffix = "+unix"
+
+// 解析 unix socket 地址: 主机部分为转义后的 socket 路径, Host 请求头与TLS的主机名为 localhost
+func parseUnixUrl(rawUrl string, idx int) (*baseURL, error) {
+	scheme := strings.TrimSuffix(strings.ToLower(rawUrl[:idx]), unixSuffix)
+	rest := rawUrl[idx+3:]
+	end := strings.IndexAny(rest, "/?#")
+	if end < 0 {
+		end = len(rest)
+	}
+	socket, err := url.PathUnescape(rest[:end])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUrl, err)
+	}
+	if socket == "" {
+		return nil, fmt.Errorf("%w: missing socket path in %q", ErrInvalidUrl, rawUrl)
+	}
+	base, err := parseBaseUrl(scheme + "://localhost" + rest[end:])
+	if err != nil {
+		return nil, err
+	}
+	base.host, base.socket = socket, socket
+	return base, nil
+}
+
+// 是否为支持的协议
+func supportedScheme(scheme string) bool {
+	scheme = strings.TrimSuffix(scheme, unixSuffix)
+	return scheme == "http" || scheme == "https"
+}
+
+// 将请求地址转换为请求行中的 path?query 部分:
+// 绝对地址仅取其路径与查询参数; 相对路径拼接在基础路径之后; 路径中的非法字符会被转义
+func (b *baseURL) requestUri(rawUrl string) (string, error) {
+	// 片段不会发送给服务端
+	if idx := strings.IndexByte(rawUrl, '#'); idx >= 0 {
+		rawUrl = rawUrl[:idx]
+	}
+	rawPath, rawQuery := rawUrl, ""
+	if idx := strings.IndexByte(rawUrl, '?'); idx >= 0 {
+		rawPath, rawQuery = rawUrl[:idx], rawUrl[idx+1:]
+	}
+	if prefix, ok := splitOrigin(rawPath); ok {
+		scheme := strings.ToLower(prefix[:strings.Index(prefix, "://")])
+		if !supportedScheme(scheme) {
+			return "", fmt.Errorf("%w: unsupported scheme %q", ErrInvalidUrl, scheme)
+		}
+		// 去掉 scheme://authority
+		if rawPath = rawPath[len(prefix):]; rawPath == "" {
+			rawPath = "/"
+		}
+	} else {
+		rawPath = b.path + "/" + strings.TrimPrefix(rawPath, "/")
+	}
+	uri := escapeInvalid(rawPath, "!$&'()*+,;=:@/")
+	if rawQuery != "" {
+		uri += "?" + escapeInvalid(rawQuery, "!$&'()*+,;=:@/?")
+	}
+	return uri, nil
+}
+
+// 取出绝对地址的 scheme://authority 部分, 相对路径返回false;
+// scheme 不合法(例: "/a/http://b")时按相对路径处理
+func splitOrigin(rawUrl string) (string, bool) {
+	if end := strings.IndexAny(rawUrl, "?#"); end >= 0 {
+		rawUrl = rawUrl[:end]
+	}
+	idx := strings.Index(rawUrl, "://")
+	if idx <= 0 || !isScheme(rawUrl[:idx]) {
+		return "", false
+	}
+	authority := rawUrl[idx+3:]
+	if slash := strings.IndexByte(authority, '/'); slash >= 0 {
+		authority = authority[:slash]
+	}
+	return rawUrl[:idx+3] + authority, true
+}
+
+// RFC 3986 3.1: scheme = ALPHA *( ALPHA / DIGIT / "+" / "-" / "." )
+func isScheme(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && ('0' <= c && c <= '9' || c == '+' || c == '-' || c == '.')) {
+			return false
+		}
+	}
+	return true
+}
+
+// 转义除未保留字符与allowed以外的字符; 已转义的 %XX 保持不变(因此 "a%2Fb" 仍表示一个路径段),
+// 不构成转义序列的 % 按字面量转义为 %25
+func escapeInvalid(s string, allowed string) string {
+	const hex = "0123456789ABCDEF"
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			sb.WriteString(s[i : i+3])
+			i += 2
+		case isUnreserved(c) || (c != '%' && strings.IndexByte(allowed, c) >= 0):
+			sb.WriteByte(c)
+		default:
+			sb.WriteByte('%')
+			sb.WriteByte(hex[c>>4])
+			sb.WriteByte(hex[c&0x0f])
+		}
+	}
+	return sb.String()
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
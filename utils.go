@@ -1,8 +1,18 @@
 package HiHttp
 
+import "unsafe"
+
 func If(cond bool, then interface{}, els interface{}) interface{} {
 	if cond {
 		return then
 	}
 	return els
 }
+
+// 将字节切片直接转换为字符串而不复制, 之后不能再修改b
+func bytesToString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return *(*string)(unsafe.Pointer(&b))
+}